@@ -2,140 +2,147 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
-	"sync"
+	"os"
 	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/gossip"
+	"github.com/jepsen-io/maelstrom/demo/go/membership"
+	"github.com/jepsen-io/maelstrom/demo/go/topology"
 )
 
+// Nodes are configured with environment variables, since Maelstrom passes no
+// arguments:
+//
+//	BROADCAST_MODE      how messages are replicated: "gossip" (the default)
+//	                    sends each neighbor the messages it hasn't
+//	                    acknowledged, "bloom" also skips messages which
+//	                    a neighbor's latest Bloom filter contains,
+//	                    "digest" reconciles with neighbors by comparing
+//	                    Merkle trees, and "plumtree" pushes messages along
+//	                    spanning trees of the neighbors
+//	BROADCAST_TOPOLOGY  if set, names a topology (see topology.New) which
+//	                    each node computes for itself instead of using the
+//	                    one Maelstrom sends
+//	BROADCAST_MEMBERSHIP if "swim", nodes detect which of their neighbors are
+//	                    unreachable, and replicate with others in their place
 func main() {
 	n := maelstrom.NewNode()
+	if _, err := newServer(n, options{
+		mode:       os.Getenv("BROADCAST_MODE"),
+		topology:   os.Getenv("BROADCAST_TOPOLOGY"),
+		membership: os.Getenv("BROADCAST_MEMBERSHIP"),
+	}); err != nil {
+		log.Fatal(err)
+	}
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	seen := make(map[int]struct{})
-	known := make(map[string]map[int]struct{})
-	var neighbors []string
-	var mu sync.Mutex
-
-	kick := make(chan struct{}, 1)
-	go func() error {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-kick:
-			case <-ticker.C:
-			}
-
-			mu.Lock()
-			for _, neighbor := range neighbors {
-				neighbor := neighbor
-				var missing []int
-				for m := range seen {
-					if _, ok := known[neighbor][m]; !ok {
-						missing = append(missing, m)
-					}
-				}
-				if len(missing) > 0 {
-					n.RPC(neighbor, gossipRequest{Type: "gossip", Messages: missing}, func(msg maelstrom.Message) error {
-						mu.Lock()
-						for _, m := range missing {
-							known[neighbor][m] = struct{}{}
-						}
-						mu.Unlock()
-						return nil
-					})
-				}
-			}
-			mu.Unlock()
-		}
-	}()
+// options configures a server. See main.
+type options struct {
+	mode, topology, membership string
+}
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body broadcastRequest
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-		mu.Lock()
-		defer mu.Unlock()
+// server handles the broadcast workload's messages.
+type server struct {
+	n        *maelstrom.Node
+	topology string
+	members  *membership.Membership // nil unless enabled
 
-		learned := false
-		if _, ok := seen[body.Message]; !ok {
-			seen[body.Message] = struct{}{}
-			learned = true
-		}
-		if learned {
-			select {
-			case kick <- struct{}{}:
-			default:
-			}
-		}
-		return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
-	})
+	seen interface {
+		Add(m int) bool
+		Values() []int
+	}
+	sync interface {
+		SetPeers(peers []string)
+		SetRouter(router gossip.Router)
+		Kick()
+		Close()
+	}
+}
 
-	n.Handle("broadcast_ok", func(msg maelstrom.Message) error {
-		// Our partner nodes will ack our broadcasts, and right now we're just ignoring that.
-		return nil
-	})
+// newServer registers handlers on n for the given options.
+func newServer(n *maelstrom.Node, opts options) (*server, error) {
+	s := &server{n: n, topology: opts.topology}
+	switch opts.mode {
+	case "", "gossip":
+		seen := gossip.NewSet[int]()
+		s.seen, s.sync = seen, gossip.New(n, seen, gossip.Config{})
+	case "bloom":
+		seen := gossip.NewSet[int]()
+		s.seen, s.sync = seen, gossip.New(n, seen, gossip.Config{SummaryInterval: time.Second})
+	case "digest":
+		seen := gossip.NewMerkleSet[int]()
+		s.seen, s.sync = seen, gossip.NewDigest(n, seen, gossip.DigestConfig{})
+	case "plumtree":
+		seen := gossip.NewSet[int]()
+		s.seen, s.sync = seen, gossip.NewPlumtree(n, seen, gossip.PlumtreeConfig{})
+	default:
+		return nil, fmt.Errorf("unknown mode %q", opts.mode)
+	}
 
-	n.Handle("gossip", func(msg maelstrom.Message) error {
-		var body gossipRequest
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-		mu.Lock()
-		defer mu.Unlock()
-
-		learned := false
-		for _, m := range body.Messages {
-			known[msg.Src][m] = struct{}{}
-			if _, ok := seen[m]; !ok {
-				seen[m] = struct{}{}
-				learned = true
-			}
-		}
-		if learned {
-			select {
-			case kick <- struct{}{}:
-			default:
-			}
-		}
-		return n.Reply(msg, map[string]any{"type": "gossip_ok"})
-	})
-
-	n.Handle("gossip_ok", func(msg maelstrom.Message) error {
-		// Our partner nodes will ack our gossips, and right now we're just ignoring that.
-		return nil
-	})
-
-	n.Handle("read", func(msg maelstrom.Message) error {
-		mu.Lock()
-		defer mu.Unlock()
-		messages := make([]int, 0, len(seen))
-		for m := range seen {
-			messages = append(messages, m)
-		}
-		return n.Reply(msg, map[string]any{"type": "read_ok", "messages": messages})
-	})
+	switch opts.membership {
+	case "":
+	case "swim":
+		s.members = membership.New(n, membership.Config{})
+		s.members.OnChange(func(e membership.Event) {
+			log.Printf("membership: %s is %s (incarnation %d)", e.Node, e.Status, e.Incarnation)
+		})
+		s.sync.SetRouter(s.members)
+	default:
+		return nil, fmt.Errorf("unknown membership %q", opts.membership)
+	}
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		var body topologyRequest
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-		mu.Lock()
-		defer mu.Unlock()
-		neighbors = body.Topology[n.ID()]
-		for _, neighbor := range neighbors {
-			known[neighbor] = make(map[int]struct{})
-		}
+	n.Handle("broadcast", s.handleBroadcast)
+	n.Handle("read", s.handleRead)
+	n.Handle("topology", s.handleTopology)
+	return s, nil
+}
 
-		return n.Reply(msg, map[string]any{"type": "topology_ok"})
-	})
+// Close stops replicating.
+func (s *server) Close() {
+	s.sync.Close()
+	if s.members != nil {
+		s.members.Close()
+	}
+}
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
+func (s *server) handleBroadcast(msg maelstrom.Message) error {
+	var body broadcastRequest
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
 	}
+	if s.seen.Add(body.Message) {
+		s.sync.Kick()
+	}
+	return s.n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+}
+
+func (s *server) handleRead(msg maelstrom.Message) error {
+	return s.n.Reply(msg, map[string]any{"type": "read_ok", "messages": s.seen.Values()})
+}
+
+func (s *server) handleTopology(msg maelstrom.Message) error {
+	var body topologyRequest
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	top := topology.Topology(body.Topology)
+	if s.topology != "" {
+		// Ignore the provided topology in favor of our own.
+		var err error
+		if top, err = topology.New(s.topology, s.n.NodeIDs()); err != nil {
+			return err
+		}
+	}
+	log.Printf("topology: %s", top.Stats())
+	s.sync.SetPeers(top[s.n.ID()])
+
+	return s.n.Reply(msg, map[string]any{"type": "topology_ok"})
 }
 
 type broadcastRequest struct {
@@ -143,11 +150,6 @@ type broadcastRequest struct {
 	Message int    `json:"message"`
 }
 
-type gossipRequest struct {
-	Type     string `json:"type"`
-	Messages []int  `json:"messages"`
-}
-
 type topologyRequest struct {
 	Topology map[string][]string `json:"topology"`
 }
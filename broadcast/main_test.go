@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+	"github.com/jepsen-io/maelstrom/demo/go/workload"
+)
+
+var modes = []string{"gossip", "bloom", "digest", "plumtree"}
+
+func TestMain(m *testing.M) {
+	// Nodes log every message they send and receive.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestServer(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode, func(t *testing.T) {
+			cfg := workload.Config{Rate: 100, TimeLimit: time.Second}
+			if mode == "bloom" {
+				// Recovering an element lost to a false positive takes up to
+				// two summary rounds, a second apart.
+				cfg.FinalDelay = 3 * time.Second
+			}
+			res := run(t, options{mode: mode}, 5, 0, cfg)
+			if res.Valid != checker.Valid {
+				t.Fatalf("unexpected results:\n%s", res)
+			}
+		})
+	}
+
+	t.Run("swim", func(t *testing.T) {
+		res := run(t, options{membership: "swim"}, 5, 0, workload.Config{Rate: 100, TimeLimit: time.Second})
+		if res.Valid != checker.Valid {
+			t.Fatalf("unexpected results:\n%s", res)
+		}
+	})
+}
+
+// BenchmarkBroadcastLag runs the broadcast-lag workload from the justfile,
+// for a shorter time, and reports the number of messages exchanged between
+// servers for each operation, their total size, and the median and maximum
+// stable latencies. As in Maelstrom, every message takes 100ms. Digests take
+// several round trips per hop, so the final reads wait long enough for any
+// mode to converge.
+func BenchmarkBroadcastLag(b *testing.B) {
+	for _, bm := range []struct {
+		mode, topology string
+	}{
+		{"gossip", ""},
+		{"digest", ""},
+		{"plumtree", ""},
+		// Plumtree builds its own trees, so give it a random overlay with a
+		// smaller diameter than the grid.
+		{"plumtree", "random6"},
+	} {
+		name := bm.mode
+		if bm.topology != "" {
+			name += "-" + bm.topology
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				res := run(b, options{mode: bm.mode, topology: bm.topology}, 25, 100*time.Millisecond, workload.Config{
+					Rate:       100,
+					TimeLimit:  10 * time.Second,
+					FinalDelay: 10 * time.Second,
+				})
+				if res.Valid != checker.Valid {
+					b.Fatalf("unexpected results:\n%s", res)
+				}
+				latencies := res.Workload.Details["stable-latencies"].(checker.Quantiles)
+				b.ReportMetric(res.Net.MsgsPerOp, "msgs/op")
+				b.ReportMetric(float64(res.Net.ServerBytes)/float64(res.Stats.Count), "bytes/op")
+				b.ReportMetric(float64(latencies[0.5]), "median-ms")
+				b.ReportMetric(float64(latencies[1]), "max-ms")
+			}
+		})
+	}
+}
+
+// BenchmarkBroadcastPartition is like BenchmarkBroadcastLag, but partitions
+// the network every few seconds, after which gossip resends every message a
+// neighbor learned of from others while it was unreachable.
+func BenchmarkBroadcastPartition(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		opts options
+	}{
+		{"gossip", options{mode: "gossip"}},
+		{"bloom", options{mode: "bloom"}},
+		{"gossip-swim", options{mode: "gossip", membership: "swim"}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				res := run(b, bm.opts, 25, 100*time.Millisecond, workload.Config{
+					Rate:            100,
+					TimeLimit:       20 * time.Second,
+					FinalDelay:      10 * time.Second,
+					Nemesis:         []string{"partition"},
+					NemesisInterval: 2 * time.Second,
+				})
+				if res.Valid != checker.Valid {
+					b.Fatalf("unexpected results:\n%s", res)
+				}
+				latencies := res.Workload.Details["stable-latencies"].(checker.Quantiles)
+				b.ReportMetric(res.Net.MsgsPerOp, "msgs/op")
+				b.ReportMetric(float64(res.Net.ServerBytes)/float64(res.Stats.Count), "bytes/op")
+				b.ReportMetric(float64(latencies[0.5]), "median-ms")
+				b.ReportMetric(float64(latencies[1]), "max-ms")
+			}
+		})
+	}
+}
+
+// run runs the broadcast workload against a simulated cluster of servers,
+// with constant latency.
+func run(tb testing.TB, opts options, nodes int, latency time.Duration, cfg workload.Config) workload.Results {
+	tb.Helper()
+
+	net := sim.NewNetwork()
+	net.Latency = latency
+	net.LatencyDist = sim.Constant
+	defer net.Close()
+
+	for i := 0; i < nodes; i++ {
+		id := fmt.Sprintf("n%d", i)
+		n := maelstrom.NewNode()
+		s, err := newServer(n, opts)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		defer s.Close()
+		net.AddNode(id, n)
+		cfg.Nodes = append(cfg.Nodes, id)
+	}
+
+	w, _ := workload.New("broadcast")
+	res, err := workload.Run(context.Background(), net, w, cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}
@@ -4,75 +4,429 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
-	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/crdt"
+	"github.com/jepsen-io/maelstrom/demo/go/gossip"
 )
 
+// Nodes are configured with environment variables, since Maelstrom passes no
+// arguments:
+//
+//	GCOUNTER_MODE        how adds are recorded: "cas" (the default) adds to a
+//	                     single seq-kv key with compare-and-swap, "sharded"
+//	                     gives each node its own seq-kv key, and "crdt"
+//	                     keeps a count per node, which nodes gossip to each
+//	                     other. "pn" is like "crdt", but also allows negative
+//	                     deltas, for the pn-counter workload
+//	GCOUNTER_CHECKPOINT  if true, "crdt" nodes also save their own count in
+//	                     seq-kv, and restore it from there when they start
 func main() {
+	checkpoint, _ := strconv.ParseBool(os.Getenv("GCOUNTER_CHECKPOINT"))
 	n := maelstrom.NewNode()
-	kv := maelstrom.NewSeqKV(n)
+	if _, err := newServer(n, options{mode: os.Getenv("GCOUNTER_MODE"), checkpoint: checkpoint}); err != nil {
+		log.Fatal(err)
+	}
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	n.Handle("add", func(msg maelstrom.Message) error {
-		var body addReqeuest
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
+// options configures a server. See main.
+type options struct {
+	mode       string
+	checkpoint bool
+}
+
+// counter records adds and serves reads.
+type counter interface {
+	Add(ctx context.Context, delta int) error
+	Read(ctx context.Context) (int, error)
+	Close()
+}
+
+// server handles the g-counter workload's messages.
+type server struct {
+	n       *maelstrom.Node
+	counter counter
+}
+
+// newServer registers handlers on n for the given options.
+func newServer(n *maelstrom.Node, opts options) (*server, error) {
+	s := &server{n: n}
+	kv := maelstrom.NewSeqKV(n)
+	switch opts.mode {
+	case "", "cas":
+		s.counter = &casCounter{kv: kv}
+	case "sharded":
+		s.counter = newShardedCounter(n, kv)
+	case "crdt":
+		if !opts.checkpoint {
+			kv = nil
 		}
+		s.counter = newCRDTCounter(n, kv)
+	case "pn":
+		s.counter = newPNCounter(n)
+	default:
+		return nil, fmt.Errorf("unknown mode %q", opts.mode)
+	}
 
-		for {
-			cur, err := kv.ReadInt(context.TODO(), counterKey)
-			keyExists := true
-			if err != nil {
-				var rpcerr *maelstrom.RPCError
-				if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.KeyDoesNotExist {
-					cur = 0
-					keyExists = false
-				} else {
-					return err
-				}
+	n.Handle("add", s.handleAdd)
+	n.Handle("read", s.handleRead)
+	return s, nil
+}
+
+// Close stops any background work.
+func (s *server) Close() { s.counter.Close() }
+
+func (s *server) handleAdd(msg maelstrom.Message) error {
+	var body addRequest
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	if err := s.counter.Add(context.TODO(), int(body.Delta)); err != nil {
+		return err
+	}
+	return s.n.Reply(msg, map[string]any{"type": "add_ok"})
+}
+
+func (s *server) handleRead(msg maelstrom.Message) error {
+	value, err := s.counter.Read(context.TODO())
+	if err != nil {
+		return err
+	}
+	return s.n.Reply(msg, map[string]any{"type": "read_ok", "value": value})
+}
+
+// casCounter keeps the counter in a single seq-kv key, and adds to it with a
+// compare-and-swap loop.
+type casCounter struct {
+	kv      *maelstrom.KV
+	retries atomic.Int64 // compare-and-swaps which lost a race
+}
+
+func (c *casCounter) Add(ctx context.Context, delta int) error {
+	for {
+		cur, err := c.kv.ReadInt(ctx, counterKey)
+		keyExists := true
+		if err != nil {
+			var rpcerr *maelstrom.RPCError
+			if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.KeyDoesNotExist {
+				cur = 0
+				keyExists = false
+			} else {
+				return err
 			}
-			if err := kv.CompareAndSwap(context.TODO(), counterKey, cur, cur+int(body.Delta), !keyExists); err != nil {
-				var rpcerr *maelstrom.RPCError
-				if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
-					continue
-				} else {
-					return err
-				}
+		}
+		if err := c.kv.CompareAndSwap(ctx, counterKey, cur, cur+delta, !keyExists); err != nil {
+			var rpcerr *maelstrom.RPCError
+			if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
+				c.retries.Add(1)
+				continue
+			} else {
+				return err
 			}
-			break
 		}
-		return n.Reply(msg, map[string]any{"type": "add_ok"})
-	})
+		return nil
+	}
+}
+
+func (c *casCounter) Read(ctx context.Context) (int, error) {
+	if err := c.kv.Sync(ctx); err != nil {
+		return 0, err
+	}
+	return readInt(ctx, c.kv, counterKey)
+}
+
+func (c *casCounter) Close() {}
+
+// shardedCounter gives each node its own seq-kv key, holding the sum of the
+// adds it has served. No other node writes it, so adds are blind writes of
+// the node's new count, with no compare-and-swap to retry, and reads sum
+// every node's key.
+type shardedCounter struct {
+	n  *maelstrom.Node
+	kv *maelstrom.KV
+
+	mu       sync.Mutex // serializes writes to our key
+	count    int
+	restored chan struct{} // closed once our count is read back on init
+	done     chan struct{}
+}
+
+func newShardedCounter(n *maelstrom.Node, kv *maelstrom.KV) *shardedCounter {
+	c := &shardedCounter{n: n, kv: kv, restored: make(chan struct{}), done: make(chan struct{})}
+	n.Handle("init", c.handleInit)
+	return c
+}
+
+func (c *shardedCounter) Add(ctx context.Context, delta int) error {
+	select {
+	case <-c.restored:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.kv.Write(ctx, shardKey(c.n.ID()), c.count+delta); err != nil {
+		return err
+	}
+	c.count += delta
+	return nil
+}
+
+func (c *shardedCounter) Read(ctx context.Context) (int, error) {
+	if err := c.kv.Sync(ctx); err != nil {
+		return 0, err
+	}
+
+	ids := c.n.NodeIDs()
+	counts := make([]int, len(ids))
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			counts[i], errs[i] = readInt(ctx, c.kv, shardKey(id))
+		}(i, id)
+	}
+	wg.Wait()
+
+	sum := 0
+	for i := range ids {
+		if errs[i] != nil {
+			return 0, errs[i]
+		}
+		sum += counts[i]
+	}
+	return sum, nil
+}
+
+func (c *shardedCounter) Close() { close(c.done) }
 
-	n.Handle("read", func(msg maelstrom.Message) error {
+// handleInit reads back the node's count, in case it is restarting. Adds
+// can't safely overwrite a count that hasn't been read, so it retries, every
+// restoreInterval, until it succeeds or the counter is closed.
+func (c *shardedCounter) handleInit(msg maelstrom.Message) error {
+	go func() {
 		for {
-			// Do a random garbage write just to ensure we're up-to-date
-			// Note that without this write, the underlying KV store is allowed to serve us stale reads.
-			if err := kv.Write(context.TODO(), "garbage", rand.Int63()); err != nil {
-				return err
+			err := c.restore()
+			if err == nil {
+				close(c.restored)
+				return
 			}
-			cur, err := kv.ReadInt(context.TODO(), counterKey)
-			if err != nil {
-				var rpcerr *maelstrom.RPCError
-				if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.KeyDoesNotExist {
-					cur = 0
-				} else {
-					return err
-				}
+			log.Printf("restore count: %s", err)
+			select {
+			case <-c.done:
+				return
+			case <-time.After(restoreInterval):
 			}
-			return n.Reply(msg, map[string]any{"type": "read_ok", "value": cur})
 		}
-	})
+	}()
+	return nil
+}
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
+// restoreInterval is how long a node waits between attempts to read back its
+// count, so that it doesn't flood seq-kv while it's unreachable.
+const restoreInterval = 100 * time.Millisecond
+
+func (c *shardedCounter) restore() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.kv.Sync(ctx); err != nil {
+		return err
+	}
+	count, err := readInt(ctx, c.kv, shardKey(c.n.ID()))
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count = count
+	return nil
+}
+
+// shardKey is the seq-kv key holding node's count in sharded mode.
+func shardKey(node string) string { return "shard-" + node }
+
+// readInt reads an integer key, which is zero if it doesn't exist.
+func readInt(ctx context.Context, kv *maelstrom.KV, key string) (int, error) {
+	v, err := kv.ReadInt(ctx, key)
+	if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+		return 0, nil
+	}
+	return v, err
+}
+
+// crdtCounter is a state-based grow-only counter: each node counts its own
+// adds, the counts are gossiped between nodes and merged by taking the
+// maximum of each, and the value is their sum. Reads are local, so they may
+// miss recent adds on other nodes, but both adds and reads stay available
+// during partitions.
+type crdtCounter struct {
+	n      *maelstrom.Node
+	kv     *maelstrom.KV // nil unless checkpointing
+	counts *gossip.Map[string, int]
+	sync   *gossip.Gossip
+
+	mu       sync.Mutex    // serializes updates to our own count
+	restored chan struct{} // closed once any checkpoint is restored
+	done     chan struct{}
+}
+
+// newCRDTCounter returns a crdtCounter on n. If kv is not nil, the node's
+// count is restored from it on init, and saved to it periodically.
+func newCRDTCounter(n *maelstrom.Node, kv *maelstrom.KV) *crdtCounter {
+	c := &crdtCounter{
+		n:        n,
+		kv:       kv,
+		counts:   gossip.NewMap[string, int](gossip.Max[int]),
+		restored: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.sync = gossip.New(n, c.counts, gossip.Config{})
+	if kv == nil {
+		close(c.restored)
+	} else {
+		n.Handle("init", c.handleInit)
+		go c.checkpoint()
+	}
+	return c
+}
+
+func (c *crdtCounter) Add(ctx context.Context, delta int) error {
+	if delta < 0 {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, "delta must not be negative")
+	}
+	// An add before the restore would be lost when it was merged.
+	select {
+	case <-c.restored:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Start from the largest count seen for this node, in case it was
+	// restored by gossip from peers.
+	cur, _ := c.counts.Get(c.n.ID())
+	if c.counts.Update(c.n.ID(), cur+delta) {
+		c.sync.Kick()
+	}
+	return nil
+}
+
+func (c *crdtCounter) Read(ctx context.Context) (int, error) {
+	sum := 0
+	for _, count := range c.counts.Values() {
+		sum += count
 	}
+	return sum, nil
+}
+
+func (c *crdtCounter) Close() {
+	c.sync.Close()
+	close(c.done)
+}
+
+// handleInit restores the node's count from its checkpoint, if any.
+func (c *crdtCounter) handleInit(msg maelstrom.Message) error {
+	go func() {
+		defer close(c.restored)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := c.kv.Sync(ctx); err != nil {
+			log.Printf("restore checkpoint: %s", err)
+			return
+		}
+		saved, err := c.kv.ReadInt(ctx, c.checkpointKey())
+		if err != nil {
+			if maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+				log.Printf("restore checkpoint: %s", err)
+			}
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.counts.Update(c.n.ID(), saved) {
+			c.sync.Kick()
+		}
+	}()
+	return nil
 }
 
+// checkpoint saves the node's count to seq-kv every second, if it changed.
+func (c *crdtCounter) checkpoint() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	saved := 0
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		cur, _ := c.counts.Get(c.n.ID())
+		if cur <= saved {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := c.kv.Write(ctx, c.checkpointKey(), cur); err != nil {
+			log.Printf("checkpoint: %s", err)
+		} else {
+			saved = cur
+		}
+		cancel()
+	}
+}
+
+// checkpointKey is the seq-kv key holding the node's count.
+func (c *crdtCounter) checkpointKey() string {
+	return "count-" + c.n.ID()
+}
+
+// pnCounter is a state-based PN-counter: each node keeps separate tallies of
+// its own increments and decrements, which are gossiped and merged like
+// crdtCounter's counts, and the value is the difference of their sums.
+type pnCounter struct {
+	n      *maelstrom.Node
+	counts *crdt.Replica[*crdt.PNCounter]
+	sync   *gossip.Gossip
+}
+
+func newPNCounter(n *maelstrom.Node) *pnCounter {
+	c := &pnCounter{n: n, counts: crdt.NewReplica(crdt.NewPNCounter())}
+	c.sync = gossip.New(n, c.counts, gossip.Config{})
+	return c
+}
+
+func (c *pnCounter) Add(ctx context.Context, delta int) error {
+	c.counts.Update(func(v *crdt.PNCounter) *crdt.PNCounter { return v.Add(c.n.ID(), delta) })
+	c.sync.Kick()
+	return nil
+}
+
+func (c *pnCounter) Read(ctx context.Context) (int, error) {
+	var value int
+	c.counts.Read(func(v *crdt.PNCounter) { value = v.Value() })
+	return value, nil
+}
+
+func (c *pnCounter) Close() { c.sync.Close() }
+
 const counterKey = "mykey"
 
-type addReqeuest struct {
+type addRequest struct {
 	Type  string `json:"type"`
 	Delta int64  `json:"delta"`
 }
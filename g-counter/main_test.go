@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+	"github.com/jepsen-io/maelstrom/demo/go/workload"
+)
+
+func TestMain(m *testing.M) {
+	// Nodes log every message they send and receive.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestServer(t *testing.T) {
+	for _, mode := range []string{"cas", "sharded", "crdt"} {
+		t.Run(mode, func(t *testing.T) {
+			res := run(t, newCluster(t, options{mode: mode}, 3), workload.Config{Rate: 100, TimeLimit: time.Second})
+			if res.Valid != checker.Valid {
+				t.Fatalf("unexpected results:\n%s", res)
+			}
+		})
+	}
+
+	t.Run("crdt-partition", func(t *testing.T) {
+		// Every operation succeeds, even while the nodes are partitioned.
+		res := run(t, newCluster(t, options{mode: "crdt"}, 3), workload.Config{
+			Rate:            100,
+			TimeLimit:       2 * time.Second,
+			Nemesis:         []string{"partition"},
+			NemesisInterval: 500 * time.Millisecond,
+			FinalDelay:      3 * time.Second,
+			Availability:    1,
+		})
+		if res.Valid != checker.Valid {
+			t.Fatalf("unexpected results:\n%s", res)
+		}
+	})
+
+	t.Run("pn", func(t *testing.T) {
+		res := runWorkload(t, newCluster(t, options{mode: "pn"}, 3), "pn-counter", workload.Config{
+			Rate:            100,
+			TimeLimit:       2 * time.Second,
+			Nemesis:         []string{"partition"},
+			NemesisInterval: 500 * time.Millisecond,
+			FinalDelay:      3 * time.Second,
+			Availability:    1,
+		})
+		if res.Valid != checker.Valid {
+			t.Fatalf("unexpected results:\n%s", res)
+		}
+	})
+
+	t.Run("crdt-negative", func(t *testing.T) {
+		// Only "pn" accepts negative deltas.
+		res := runWorkload(t, newCluster(t, options{mode: "crdt"}, 3), "pn-counter", workload.Config{
+			Rate:      100,
+			TimeLimit: time.Second,
+		})
+		for _, op := range res.History {
+			if op.F == "add" && op.Type == checker.OK && op.Value.(int) < 0 {
+				t.Fatalf("negative add succeeded: %v", op)
+			}
+		}
+	})
+
+	t.Run("crdt-checkpoint", func(t *testing.T) {
+		c := newCluster(t, options{mode: "crdt", checkpoint: true}, 3)
+		client := c.net.AddClient("c1")
+		if _, err := client.SyncRPC(context.Background(), maelstrom.SeqKV, map[string]any{
+			"type": "write", "key": "count-n0", "value": 5,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The checker doesn't know about the restored count, so check the
+		// final reads here: each includes it as well as every add.
+		res := run(t, c, workload.Config{Rate: 100, TimeLimit: time.Second})
+		if res.Stats.OKCount != res.Stats.Count {
+			t.Fatalf("unexpected results:\n%s", res)
+		}
+		var added int
+		for _, op := range res.History {
+			if op.Type == checker.OK && op.F == "add" {
+				added += op.Value.(int)
+			}
+		}
+		for _, op := range res.History[len(res.History)-2*len(c.nodes):] {
+			if op.Type != checker.OK || op.F != "read" {
+				continue
+			}
+			if got, want := op.Value.(int), added+5; got != want {
+				t.Fatalf("final read=%v, want %v", got, want)
+			}
+		}
+	})
+}
+
+// BenchmarkCounter compares adds to a single key with compare-and-swap,
+// which retry whenever another add wins the race, against blind writes to
+// each node's own key, with many concurrent clients.
+func BenchmarkCounter(b *testing.B) {
+	for _, mode := range []string{"cas", "sharded"} {
+		b.Run(mode, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := newCluster(b, options{mode: mode}, 5)
+				c.net.Latency = 5 * time.Millisecond
+				c.net.LatencyDist = sim.Constant
+				res := run(b, c, workload.Config{
+					Concurrency: 20,
+					Rate:        1000,
+					TimeLimit:   5 * time.Second,
+					Timeout:     5 * time.Second,
+				})
+				if res.Valid != checker.Valid {
+					b.Fatalf("unexpected results:\n%s", res)
+				}
+
+				var retries int64
+				for _, s := range c.servers {
+					if cas, ok := s.counter.(*casCounter); ok {
+						retries += cas.retries.Load()
+					}
+				}
+				adds := res.Stats.ByF["add"]
+				b.ReportMetric(float64(retries)/float64(adds.Count), "retries/add")
+				b.ReportMetric(float64(adds.Count)/5, "adds/s")
+				b.ReportMetric(addLatency(res.History).Seconds()*1000, "add-ms")
+				b.ReportMetric(float64(res.Net.Services)/float64(res.Stats.Count), "kv-msgs/op")
+			}
+		})
+	}
+}
+
+// addLatency returns the mean latency of successful adds.
+func addLatency(h checker.History) time.Duration {
+	invoked := make(map[int]int64) // process -> time of its pending op
+	var total int64
+	var n int
+	for _, op := range h {
+		switch {
+		case op.Type == checker.Invoke:
+			invoked[op.Process] = op.Time
+		case op.Type == checker.OK && op.F == "add":
+			total += op.Time - invoked[op.Process]
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(total / int64(n))
+}
+
+// cluster is a set of servers on a simulated network with a seq-kv service.
+type cluster struct {
+	net     *sim.Network
+	nodes   []string
+	servers []*server
+}
+
+// newCluster returns a cluster of servers with the given options.
+func newCluster(tb testing.TB, opts options, nodes int) *cluster {
+	tb.Helper()
+
+	c := &cluster{net: sim.NewNetwork()}
+	tb.Cleanup(func() { c.net.Close() })
+	c.net.AddService(maelstrom.SeqKV, sim.NewKV(maelstrom.SeqKV).Node())
+	for i := 0; i < nodes; i++ {
+		id := fmt.Sprintf("n%d", i)
+		n := maelstrom.NewNode()
+		s, err := newServer(n, opts)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(s.Close)
+		c.net.AddNode(id, n)
+		c.nodes = append(c.nodes, id)
+		c.servers = append(c.servers, s)
+	}
+	return c
+}
+
+// run runs the g-counter workload against a cluster.
+func run(tb testing.TB, c *cluster, cfg workload.Config) workload.Results {
+	tb.Helper()
+	return runWorkload(tb, c, "g-counter", cfg)
+}
+
+// runWorkload runs the named workload against a cluster.
+func runWorkload(tb testing.TB, c *cluster, name string, cfg workload.Config) workload.Results {
+	tb.Helper()
+
+	cfg.Nodes = c.nodes
+	w, _ := workload.New(name)
+	res, err := workload.Run(context.Background(), c.net, w, cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}
@@ -3,3 +3,5 @@ module maelstrom-broadcast
 go 1.20
 
 require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20230113211434-22f433519054
+
+replace github.com/jepsen-io/maelstrom/demo/go => ../maelstrom/demo/go
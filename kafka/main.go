@@ -5,15 +5,351 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+// Nodes are configured with environment variables, since Maelstrom passes no
+// arguments:
+//
+//	KAFKA_MODE          "shared" (the default) lets every node append to
+//	                    every log, and "partitioned" makes each log's owner,
+//	                    chosen by hashing its key, serve all of its sends and
+//	                    polls, with other nodes forwarding them
+//	KAFKA_POLL_BATCH    the most records poll returns from each log.
+//	                    Defaults to 10
+//	KAFKA_SEGMENT_SIZE  the number of records in each segment of a log. Every
+//	                    node must use the same size. Defaults to 100
+//	KAFKA_TXN_TIMEOUT   how many milliseconds a transaction may stay
+//	                    undecided before readers abort it. Defaults to 5000
+//
+// and, to delete old records, which Maelstrom's checker would see as lost:
+//
+//	KAFKA_RETAIN_RECORDS    keep at least this many of each log's latest
+//	                        records, and delete older ones
+//	KAFKA_RETAIN_COMMITTED  if true, delete records below the lowest offset
+//	                        committed for each log
+//	KAFKA_COMPACT           if true, delete records which a later record
+//	                        with the same subkey supersedes
 func main() {
 	n := maelstrom.NewNode()
+	pollBatch, _ := strconv.Atoi(os.Getenv("KAFKA_POLL_BATCH"))
+	segmentSize, _ := strconv.Atoi(os.Getenv("KAFKA_SEGMENT_SIZE"))
+	retainRecords, _ := strconv.Atoi(os.Getenv("KAFKA_RETAIN_RECORDS"))
+	retainCommitted, _ := strconv.ParseBool(os.Getenv("KAFKA_RETAIN_COMMITTED"))
+	compact, _ := strconv.ParseBool(os.Getenv("KAFKA_COMPACT"))
+	txnTimeout, _ := strconv.Atoi(os.Getenv("KAFKA_TXN_TIMEOUT"))
+	newSrv(n, options{
+		mode:            os.Getenv("KAFKA_MODE"),
+		pollBatch:       pollBatch,
+		segmentSize:     segmentSize,
+		retainRecords:   retainRecords,
+		retainCommitted: retainCommitted,
+		compact:         compact,
+		txnTimeout:      time.Duration(txnTimeout) * time.Millisecond,
+	})
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type sendRequest struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	Msg  int    `json:"msg"`
+
+	// Subkey extends the protocol: when compacting, only the latest record
+	// with each subkey is kept.
+	Subkey string `json:"subkey,omitempty"`
+
+	// Producer and Seq extend the protocol with idempotent sends. A producer
+	// numbers its sends to each log in increasing order, and retries a send
+	// with the same number until it succeeds, which returns the offset of
+	// the first attempt that was appended rather than appending it again.
+	Producer string `json:"producer,omitempty"`
+	Seq      int    `json:"seq,omitempty"`
+}
+
+type sendResponse struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+}
+
+// sendBatchRequest extends the protocol with a request to append many
+// messages to each of several logs.
+type sendBatchRequest struct {
+	Type string           `json:"type"`
+	Msgs map[string][]int `json:"msgs"`
+
+	// Txn is set when nodes forward the logs of a transaction to their
+	// owners, which append its records as pending.
+	Txn string `json:"txn,omitempty"`
+}
+
+// sendBatchResponse holds the offsets of the messages appended to each log.
+// Each log's batch is appended in order, and if any of it fails, its offsets
+// are those of the messages before the failure, and its error says why the
+// rest weren't appended, so a client can retry them.
+type sendBatchResponse struct {
+	Type    string                `json:"type"`
+	Offsets map[string][]int      `json:"offsets"`
+	Errors  map[string]batchError `json:"errors,omitempty"`
+}
+
+type batchError struct {
+	Code int    `json:"code"`
+	Text string `json:"text"`
+}
+
+// newBatchError returns the error reported for a log whose batch failed.
+func newBatchError(err error) batchError {
+	var rpcerr *maelstrom.RPCError
+	if errors.As(err, &rpcerr) {
+		return batchError{Code: rpcerr.Code, Text: rpcerr.Text}
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return batchError{Code: maelstrom.Timeout, Text: err.Error()}
+	}
+	return batchError{Code: maelstrom.Crash, Text: err.Error()}
+}
+
+// sendTxnRequest extends the protocol with a request to append messages to
+// several logs atomically: either all of them are appended, or none are.
+type sendTxnRequest struct {
+	Type string           `json:"type"`
+	Msgs map[string][]int `json:"msgs"`
+}
+
+type sendTxnResponse struct {
+	Type    string           `json:"type"`
+	Offsets map[string][]int `json:"offsets"`
+}
+
+type pollRequest struct {
+	Type    string         `json:"type"`
+	Offsets map[string]int `json:"offsets"`
+
+	// Timeout extends the protocol with long polls: if none of the logs has
+	// records past its offset, wait up to this many milliseconds for some.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+type pollResponse struct {
+	Type string              `json:"type"`
+	Msgs map[string][]record `json:"msgs"`
+}
+
+// subscribeRequest extends the protocol with a request for the records of
+// logs from the given offsets on, which the node pushes to the subscriber in
+// records messages as they are appended.
+type subscribeRequest struct {
+	Type    string         `json:"type"`
+	Offsets map[string]int `json:"offsets"`
+}
+
+type subscribeResponse struct {
+	Type         string `json:"type"`
+	Subscription string `json:"subscription"`
+}
+
+type unsubscribeRequest struct {
+	Type         string `json:"type"`
+	Subscription string `json:"subscription"`
+}
+
+// recordsMessage pushes records to a subscriber, which acknowledges them
+// with a records_ok reply. The node sends no more until it does.
+type recordsMessage struct {
+	Type         string              `json:"type"`
+	Subscription string              `json:"subscription"`
+	Msgs         map[string][]record `json:"msgs"`
+}
+
+type record struct {
+	offset int
+	msg    int
+}
+
+func (r record) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]int{r.offset, r.msg})
+}
+
+type commitOffsetsRequest struct {
+	Type    string         `json:"type"`
+	Offsets map[string]int `json:"offsets"`
+
+	// Group and Generation extend the protocol: offsets committed for a
+	// group are separate from those committed without one, and a commit with
+	// a generation is rejected if the group's membership has changed since.
+	Group      string `json:"group,omitempty"`
+	Generation int    `json:"generation,omitempty"`
+}
+
+type commitOffsetsResponse struct {
+	Type string `json:"type"`
+}
+
+type listCommittedOffsetsRequest struct {
+	Type  string   `json:"type"`
+	Keys  []string `json:"keys"`
+	Group string   `json:"group,omitempty"`
+}
+
+type listCommittedOffsetsResponse struct {
+	Type    string         `json:"type"`
+	Offsets map[string]int `json:"offsets"`
+}
+
+// groupRequest is the body of a join_group, leave_group or assign request.
+// An assign request also lists the logs the group consumes.
+type groupRequest struct {
+	Type     string   `json:"type"`
+	Group    string   `json:"group"`
+	Consumer string   `json:"consumer"`
+	Keys     []string `json:"keys,omitempty"`
+}
+
+// groupResponse is the body of a join_group_ok or leave_group_ok reply, with
+// the group's new membership, or of an assign_ok reply, with the logs
+// assigned to the consumer.
+type groupResponse struct {
+	Type       string   `json:"type"`
+	Generation int      `json:"generation"`
+	Members    []string `json:"members,omitempty"`
+	Keys       []string `json:"keys,omitempty"`
+}
+
+// options configures a srv. See main.
+type options struct {
+	mode            string
+	pollBatch       int
+	segmentSize     int
+	retainRecords   int
+	retainCommitted bool
+	compact         bool
+	txnTimeout      time.Duration
+}
+
+// maintained reports whether any records are ever deleted.
+func (o options) maintained() bool {
+	return o.retainRecords > 0 || o.retainCommitted || o.compact
+}
+
+type srv struct {
+	n    *maelstrom.Node
+	kv   *maelstrom.KV
+	opts options
+
+	mu          sync.Mutex
+	sealed      map[string]map[int][]entry // log -> full segments, which only compaction changes
+	tails       map[string]segment         // log -> last segment this node has seen
+	appends     map[string]*sync.Mutex     // log -> lock serializing its owner's sends
+	starts      map[string]int             // log -> first segment which may not be truncated
+	maintaining map[string]int             // log -> latest tail to run retention for
+	groups      map[string]bool            // groups this node has registered
+	appended    chan struct{}              // closed on the next append, if anyone is waiting
+	subs        map[string]func()          // subscription -> cancels it
+	txns        map[string]string          // transaction -> its decision, once made
+	nextSub     int
+	nextTxn     int
+
+	conflicts atomic.Int64 // appends which lost a race with another node
+}
+
+// segment is a numbered segment of a log, with its records.
+type segment struct {
+	n         int
+	msgs      []entry // nil if the segment doesn't exist yet
+	truncated bool    // if retention has deleted the segment
+}
+
+// entry is a record in a segment, stored as its message, or as a [subkey,
+// message] pair if it has a subkey, or as an object if it came from an
+// idempotent producer or a transaction, or as null once compaction has
+// deleted it.
+type entry struct {
+	msg      int
+	subkey   string
+	producer string
+	seq      int
+	txn      string
+	deleted  bool
+}
+
+// objectEntry is the form of an entry from an idempotent producer or a
+// transaction.
+type objectEntry struct {
+	Msg      int    `json:"msg"`
+	Subkey   string `json:"subkey,omitempty"`
+	Producer string `json:"producer,omitempty"`
+	Seq      int    `json:"seq,omitempty"`
+	Txn      string `json:"txn,omitempty"`
+}
+
+func (e entry) MarshalJSON() ([]byte, error) {
+	if e.deleted {
+		return []byte("null"), nil
+	} else if e.producer != "" || e.txn != "" {
+		return json.Marshal(objectEntry{Msg: e.msg, Subkey: e.subkey, Producer: e.producer, Seq: e.seq, Txn: e.txn})
+	} else if e.subkey != "" {
+		return json.Marshal([]any{e.subkey, e.msg})
+	}
+	return json.Marshal(e.msg)
+}
+
+// parseEntry parses an entry read from lin-kv.
+func parseEntry(v any) entry {
+	switch v := v.(type) {
+	case float64:
+		return entry{msg: int(v)}
+	case []any:
+		if len(v) == 2 {
+			subkey, _ := v[0].(string)
+			msg, _ := v[1].(float64)
+			return entry{msg: int(msg), subkey: subkey}
+		}
+	case map[string]any:
+		msg, _ := v["msg"].(float64)
+		subkey, _ := v["subkey"].(string)
+		producer, _ := v["producer"].(string)
+		seq, _ := v["seq"].(float64)
+		txn, _ := v["txn"].(string)
+		return entry{msg: int(msg), subkey: subkey, producer: producer, seq: int(seq), txn: txn}
+	}
+	return entry{deleted: true}
+}
 
-	s := newSrv(n)
+// newSrv registers handlers for the kafka workload on n.
+func newSrv(n *maelstrom.Node, opts options) *srv {
+	if opts.pollBatch <= 0 {
+		opts.pollBatch = 10
+	}
+	if opts.segmentSize <= 0 {
+		opts.segmentSize = 100
+	}
+	if opts.txnTimeout <= 0 {
+		opts.txnTimeout = 5 * time.Second
+	}
+	s := &srv{
+		n:           n,
+		kv:          maelstrom.NewLinKV(n),
+		opts:        opts,
+		sealed:      make(map[string]map[int][]entry),
+		tails:       make(map[string]segment),
+		appends:     make(map[string]*sync.Mutex),
+		starts:      make(map[string]int),
+		maintaining: make(map[string]int),
+		groups:      make(map[string]bool),
+		subs:        make(map[string]func()),
+		txns:        make(map[string]string),
+	}
 
 	n.Handle("send", func(msg maelstrom.Message) error {
 		var body sendRequest
@@ -21,159 +357,1359 @@ func main() {
 			return err
 		}
 
-		offset, err := s.send(body)
+		offset, err := s.send(body)
+		if err != nil {
+			return err
+		}
+		return n.Reply(msg, sendResponse{
+			Type:   "send_ok",
+			Offset: offset,
+		})
+	})
+
+	n.Handle("send_batch", func(msg maelstrom.Message) error {
+		var body sendBatchRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		resp := s.sendBatch(body)
+		resp.Type = "send_batch_ok"
+		return n.Reply(msg, resp)
+	})
+
+	n.Handle("send_txn", func(msg maelstrom.Message) error {
+		var body sendTxnRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		offsets, err := s.sendTxn(body)
+		if err != nil {
+			return err
+		}
+		return n.Reply(msg, sendTxnResponse{
+			Type:    "send_txn_ok",
+			Offsets: offsets,
+		})
+	})
+
+	n.Handle("poll", func(msg maelstrom.Message) error {
+		var body pollRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		msgs, err := s.poll(context.Background(), body)
+		if err != nil {
+			return err
+		}
+		return n.Reply(msg, pollResponse{
+			Type: "poll_ok",
+			Msgs: msgs,
+		})
+	})
+
+	n.Handle("subscribe", func(msg maelstrom.Message) error {
+		var body subscribeRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		if len(body.Offsets) == 0 {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, "subscription without offsets would never push records")
+		}
+		return n.Reply(msg, subscribeResponse{
+			Type:         "subscribe_ok",
+			Subscription: s.subscribe(msg.Src, body.Offsets),
+		})
+	})
+
+	n.Handle("unsubscribe", func(msg maelstrom.Message) error {
+		var body unsubscribeRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		if !s.unsubscribe(body.Subscription) {
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, fmt.Sprintf("no subscription %s", body.Subscription))
+		}
+		return n.Reply(msg, map[string]any{"type": "unsubscribe_ok"})
+	})
+
+	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
+		var body commitOffsetsRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		if err := s.commitOffsets(body); err != nil {
+			return err
+		}
+		return n.Reply(msg, commitOffsetsResponse{
+			Type: "commit_offsets_ok",
+		})
+	})
+
+	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
+		var body listCommittedOffsetsRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		offsets, err := s.listCommittedOffsets(body)
+		if err != nil {
+			return err
+		}
+		return n.Reply(msg, listCommittedOffsetsResponse{
+			Type:    "list_committed_offsets_ok",
+			Offsets: offsets,
+		})
+	})
+
+	n.Handle("join_group", func(msg maelstrom.Message) error {
+		var body groupRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		if s.opts.retainCommitted {
+			if err := s.registerGroup(body.Group); err != nil {
+				return err
+			}
+		}
+		g, err := s.updateGroup(body.Group, func(g *membership) (bool, error) { return g.join(body.Consumer), nil })
+		if err != nil {
+			return err
+		}
+		return n.Reply(msg, groupResponse{Type: "join_group_ok", Generation: g.Generation, Members: g.Members})
+	})
+
+	n.Handle("leave_group", func(msg maelstrom.Message) error {
+		var body groupRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		g, err := s.updateGroup(body.Group, func(g *membership) (bool, error) { return g.leave(body.Consumer), nil })
+		if err != nil {
+			return err
+		}
+		return n.Reply(msg, groupResponse{Type: "leave_group_ok", Generation: g.Generation, Members: g.Members})
+	})
+
+	n.Handle("assign", func(msg maelstrom.Message) error {
+		var body groupRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		g, _, err := s.group(body.Group)
+		if err != nil {
+			return err
+		}
+		keys, ok := g.assign(body.Consumer, body.Keys)
+		if !ok {
+			return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("%s is not a member of group %s", body.Consumer, body.Group))
+		}
+		return n.Reply(msg, groupResponse{Type: "assign_ok", Generation: g.Generation, Keys: keys})
+	})
+
+	return s
+}
+
+// Logs are stored in segments of a fixed number of records. Segment i of a
+// log, under "segments/<key>/<i>", is a list of the messages from offset
+// firstOffset + i*segmentSize onwards. A send appends to the last segment with
+// a compare-and-swap of the whole list, which claims the next offset and
+// writes the record in one step, and only starts a new segment once the last
+// one is full, so the offsets of single sends are dense. A batch which doesn't
+// fit in the last segment fills it with nulls and starts the next one, so its
+// offsets stay contiguous. A poll reads the one or two segments holding the
+// records it wants, rather than each record, and a missing or partly full
+// segment is always the end of the log.
+//
+// Full segments are only changed by compaction, so each node caches them.
+// "index/<key>" holds the number of a segment which exists, and no later than
+// the last one, as a hint for where sends from a node which hasn't seen the
+// log should start.
+
+// In partitioned mode, each log is owned by a single node, chosen by hashing
+// its key, and other nodes forward its sends and polls to the owner. The
+// owner serializes its sends, and trusts its cached tail when polling, so it
+// never contends with itself or reads the tail back from lin-kv: it keeps the
+// log in memory, and lin-kv holds it only for durability. If some other node
+// did append to the log, e.g. because it had a different view of the
+// cluster, the owner's next compare-and-swap would fail and it would catch
+// up, so lin-kv also arbitrates ownership.
+
+// timeout is how long a node waits for lin-kv, or for a log's owner, before
+// it gives up on a request.
+const timeout = time.Second
+
+// recheckInterval is how often a long poll in shared mode checks lin-kv for
+// records which other nodes have appended.
+const recheckInterval = 100 * time.Millisecond
+
+// pushAttempts is how many times a node sends a subscriber a batch of
+// records before giving up on it.
+const pushAttempts = 3
+
+// owner returns the node which serves a log, or "" if every node does.
+func (s *srv) owner(key string) string {
+	if s.opts.mode != "partitioned" {
+		return ""
+	}
+	ids := s.n.NodeIDs()
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return ids[h.Sum32()%uint32(len(ids))]
+}
+
+// owns reports whether this node owns a log in partitioned mode.
+func (s *srv) owns(key string) bool { return s.owner(key) == s.n.ID() }
+
+// forward sends a request to another node, and returns its reply.
+func (s *srv) forward(ctx context.Context, dest string, body any) (json.RawMessage, error) {
+	replies := make(chan maelstrom.Message, 1)
+	if err := s.n.RPC(dest, body, func(msg maelstrom.Message) error {
+		replies <- msg
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case msg := <-replies:
+		if err := msg.RPCError(); err != nil {
+			return nil, err
+		}
+		return msg.Body, nil
+	}
+}
+
+// send appends a message to a log.
+func (s *srv) send(req sendRequest) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if owner := s.owner(req.Key); owner != "" && owner != s.n.ID() {
+		body, err := s.forward(ctx, owner, req)
+		if err != nil {
+			return 0, err
+		}
+		var resp sendResponse
+		err = json.Unmarshal(body, &resp)
+		return resp.Offset, err
+	}
+
+	e := entry{msg: req.Msg, subkey: req.Subkey, producer: req.Producer, seq: req.Seq}
+	if e.producer != "" {
+		return s.sendIdempotent(ctx, req.Key, e)
+	}
+	offsets, err := s.append(ctx, req.Key, []entry{e})
+	if err != nil {
+		return 0, err
+	}
+	return offsets[0], nil
+}
+
+// sendBatch appends batches of messages to logs, concurrently. Batches for
+// logs owned by other nodes are forwarded to them.
+func (s *srv) sendBatch(req sendBatchRequest) sendBatchResponse {
+	resp := sendBatchResponse{Offsets: make(map[string][]int), Errors: make(map[string]batchError)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	remote := make(map[string]map[string][]int) // owner -> batches
+	for key, msgs := range req.Msgs {
+		if owner := s.owner(key); owner != "" && owner != s.n.ID() {
+			if remote[owner] == nil {
+				remote[owner] = make(map[string][]int)
+			}
+			remote[owner][key] = msgs
+			continue
+		}
+
+		wg.Add(1)
+		go func(key string, msgs []int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			entries := make([]entry, len(msgs))
+			for i, msg := range msgs {
+				entries[i] = entry{msg: msg, txn: req.Txn}
+			}
+			offsets, err := s.append(ctx, key, entries)
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Offsets[key] = append([]int{}, offsets...)
+			if err != nil {
+				resp.Errors[key] = newBatchError(err)
+			}
+		}(key, msgs)
+	}
+
+	for owner, batches := range remote {
+		wg.Add(1)
+		go func(owner string, batches map[string][]int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			var sub sendBatchResponse
+			body, err := s.forward(ctx, owner, sendBatchRequest{Type: "send_batch", Msgs: batches, Txn: req.Txn})
+			if err == nil {
+				err = json.Unmarshal(body, &sub)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for key := range batches {
+				resp.Offsets[key] = append([]int{}, sub.Offsets[key]...)
+				if err != nil {
+					resp.Errors[key] = newBatchError(err)
+				} else if e, ok := sub.Errors[key]; ok {
+					resp.Errors[key] = e
+				}
+			}
+		}(owner, batches)
+	}
+
+	wg.Wait()
+	return resp
+}
+
+// append appends records to a log, in order, and returns their offsets.
+//
+// The records are appended with a single compare-and-swap, so their offsets
+// are contiguous, and either all of them are appended or none are. Records
+// which don't fit in the tail segment start a new one, and nulls fill the
+// rest of the tail, so a batch can't be larger than a segment.
+func (s *srv) append(ctx context.Context, key string, records []entry) ([]int, error) {
+	if s.owns(key) {
+		mu := s.appendLock(key)
+		mu.Lock()
+		defer mu.Unlock()
+	}
+
+	tail, err := s.tail(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.appendFrom(ctx, key, tail, records)
+}
+
+// appendFrom appends records to a log, starting from a segment at or before
+// its tail. A single record from an idempotent producer isn't appended if it
+// is already in that segment or a later one, and appendFrom returns its
+// offset instead. Since every segment it passes is checked, including the
+// tail just before it's swapped, two attempts at the same record can't both
+// be appended.
+func (s *srv) appendFrom(ctx context.Context, key string, tail segment, records []entry) ([]int, error) {
+	if len(records) > s.opts.segmentSize {
+		return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("batch of %d records is larger than a segment of %d", len(records), s.opts.segmentSize))
+	}
+	for {
+		if len(records) == 1 && records[0].producer != "" {
+			if i := tail.find(records[0]); i >= 0 {
+				return []int{s.offset(tail.n, i)}, nil
+			}
+		}
+
+		if tail.truncated {
+			tail = segment{n: tail.n + 1}
+		} else if len(tail.msgs) == s.opts.segmentSize {
+			s.seal(key, tail)
+			tail = segment{n: tail.n + 1}
+		}
+
+		// If the records don't fit, fill the tail with nulls instead, and
+		// append them to the next segment on the next pass.
+		msgs := append([]entry{}, tail.msgs...)
+		fits := len(msgs)+len(records) <= s.opts.segmentSize
+		if fits {
+			msgs = append(msgs, records...)
+		} else {
+			for len(msgs) < s.opts.segmentSize {
+				msgs = append(msgs, entry{deleted: true})
+			}
+		}
+		var from any
+		if tail.msgs != nil {
+			from = tail.msgs
+		}
+		err := s.kv.CompareAndSwap(ctx, segmentKey(key, tail.n), from, msgs, from == nil)
+		var rpcerr *maelstrom.RPCError
+		if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
+			// Another send appended first, so catch up and try again.
+			s.conflicts.Add(1)
+			if tail, err = s.readSegment(ctx, key, tail.n); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if tail.msgs == nil && tail.n > 0 {
+			// The index is only a hint, so a failure here is harmless.
+			s.maxOffset(indexPrefix, key, tail.n)
+			if s.opts.maintained() {
+				go s.maintain(key, tail.n)
+			}
+		}
+		s.setTail(key, segment{n: tail.n, msgs: msgs})
+		if !fits {
+			tail = segment{n: tail.n, msgs: msgs}
+			continue
+		}
+		s.notify()
+		offsets := make([]int, len(records))
+		for i := range records {
+			offsets[i] = s.offset(tail.n, len(tail.msgs)+i)
+		}
+		return offsets, nil
+	}
+}
+
+// find returns the index of the record in a segment from the same producer
+// with the same sequence number as e, or -1 if there is none.
+func (seg segment) find(e entry) int {
+	for i, m := range seg.msgs {
+		if m.producer == e.producer && m.seq == e.seq && !m.deleted {
+			return i
+		}
+	}
+	return -1
+}
+
+// Each producer of idempotent sends has a checkpoint for each log it sends
+// to, under "producers/<key>/<producer>", holding the latest sequence number
+// it has sent there, and a segment at or before the one its record went into.
+// Before it first tries to append a record, a node raises the checkpoint
+// with a compare-and-swap, so a record with a later sequence number can't
+// be in the log, and another attempt at the latest one only needs to look
+// for it from the checkpoint's segment on.
+type checkpoint struct {
+	Seq     int `json:"seq"`
+	Segment int `json:"segment"`
+}
+
+// sendIdempotent appends a record from an idempotent producer to a log,
+// unless an earlier attempt to send it already has, and returns its offset.
+func (s *srv) sendIdempotent(ctx context.Context, key string, e entry) (int, error) {
+	if s.owns(key) {
+		mu := s.appendLock(key)
+		mu.Lock()
+		defer mu.Unlock()
+	}
+
+	tail, err := s.tail(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	from, err := s.claim(ctx, key, e.producer, e.seq, tail.n)
+	if err != nil {
+		return 0, err
+	}
+	if from != tail.n {
+		if tail, err = s.segment(ctx, key, from); err != nil {
+			return 0, err
+		}
+	}
+	offsets, err := s.appendFrom(ctx, key, tail, []entry{e})
+	if err != nil {
+		return 0, err
+	}
+	return offsets[0], nil
+}
+
+// claim raises a producer's checkpoint for a log to seq, if it is a new
+// sequence number, and returns the segment from which to look for an earlier
+// attempt to send it: the checkpoint's, or else tail, where there can't be
+// one.
+func (s *srv) claim(ctx context.Context, key, producer string, seq, tail int) (int, error) {
+	loc := fmt.Sprintf("producers/%s/%s", key, producer)
+	for {
+		var cur checkpoint
+		raw, err := s.kv.Read(ctx, loc)
+		var rpcerr *maelstrom.RPCError
+		if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.KeyDoesNotExist {
+			raw = nil
+		} else if err != nil {
+			return 0, err
+		} else if buf, err := json.Marshal(raw); err != nil {
+			return 0, err
+		} else if err := json.Unmarshal(buf, &cur); err != nil {
+			return 0, err
+		}
+
+		if raw != nil && seq < cur.Seq {
+			return 0, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("producer %s has already sent %d to %s, after %d", producer, cur.Seq, key, seq))
+		} else if raw != nil && seq == cur.Seq {
+			return cur.Segment, nil
+		}
+
+		err = s.kv.CompareAndSwap(ctx, loc, raw, checkpoint{Seq: seq, Segment: tail}, raw == nil)
+		if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
+			// Another attempt got there first, so it's no longer new.
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		return tail, nil
+	}
+}
+
+// appendLock returns the lock which serializes sends to a log by its owner.
+func (s *srv) appendLock(key string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mu, ok := s.appends[key]
+	if !ok {
+		mu = new(sync.Mutex)
+		s.appends[key] = mu
+	}
+	return mu
+}
+
+// A transaction commits with two phases. First it appends its records to
+// each log as pending, tagged with its ID, which reserves their offsets, and
+// then it decides, by swapping its coordinator record, "txns/<id>", from
+// pending to committed, or to aborted if any append failed. Readers look up
+// the coordinator record of each tagged record they find: a poll skips the
+// records of aborted transactions, and stops short of those of pending ones,
+// since it can't yet tell whether they will be in the log, so no reader sees
+// part of a transaction. Decisions are final, so nodes cache them.
+//
+// A pending transaction blocks polls of its logs, so if its coordinator
+// fails, a reader which finds it still pending after txnTimeout aborts it.
+// Both decisions are compare-and-swaps from pending, so only one stands, and
+// a coordinator which loses the race reports that its transaction failed.
+
+const (
+	txnPending   = "pending"
+	txnCommitted = "committed"
+	txnAborted   = "aborted"
+)
+
+// txnRecord is the coordinator record of a transaction.
+type txnRecord struct {
+	Expires int64  `json:"expires"` // Unix milliseconds, after which readers may abort it
+	Status  string `json:"status"`
+}
+
+// sendTxn appends messages to several logs atomically, and returns their
+// offsets.
+func (s *srv) sendTxn(req sendTxnRequest) (map[string][]int, error) {
+	s.mu.Lock()
+	s.nextTxn++
+	id := fmt.Sprintf("%s-%d", s.n.ID(), s.nextTxn)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	begun := txnRecord{Expires: time.Now().Add(s.opts.txnTimeout).UnixMilli(), Status: txnPending}
+	if err := s.kv.CompareAndSwap(ctx, txnKey(id), nil, begun, true); err != nil {
+		return nil, err
+	}
+
+	resp := s.sendBatch(sendBatchRequest{Msgs: req.Msgs, Txn: id})
+	status := txnCommitted
+	if len(resp.Errors) > 0 {
+		status = txnAborted
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	decided, err := s.decide(ctx, id, begun, status)
+	if err != nil {
+		return nil, err
+	} else if status == txnAborted {
+		return nil, maelstrom.NewRPCError(maelstrom.TxnConflict, fmt.Sprintf("transaction %s aborted: appends to %d logs failed", id, len(resp.Errors)))
+	} else if decided != txnCommitted {
+		return nil, maelstrom.NewRPCError(maelstrom.TxnConflict, fmt.Sprintf("transaction %s aborted by a reader", id))
+	}
+	return resp.Offsets, nil
+}
+
+// txnStatus returns whether a transaction is pending, committed, or
+// aborted, and aborts it if it has been pending for too long.
+func (s *srv) txnStatus(ctx context.Context, id string) (string, error) {
+	s.mu.Lock()
+	status, ok := s.txns[id]
+	s.mu.Unlock()
+	if ok {
+		return status, nil
+	}
+
+	rec, err := s.readTxn(ctx, id)
+	if err != nil {
+		return "", err
+	} else if rec.Status != txnPending {
+		s.mu.Lock()
+		s.txns[id] = rec.Status
+		s.mu.Unlock()
+		return rec.Status, nil
+	} else if time.Now().UnixMilli() < rec.Expires {
+		return txnPending, nil
+	}
+	return s.decide(ctx, id, rec, txnAborted)
+}
+
+// decide swaps a pending transaction's coordinator record to a decision,
+// unless another node has decided it first, and returns the decision which
+// stands.
+func (s *srv) decide(ctx context.Context, id string, from txnRecord, status string) (string, error) {
+	err := s.kv.CompareAndSwap(ctx, txnKey(id), from, txnRecord{Expires: from.Expires, Status: status}, false)
+	var rpcerr *maelstrom.RPCError
+	if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
+		rec, err := s.readTxn(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		status = rec.Status
+	} else if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.txns[id] = status
+	s.mu.Unlock()
+	// Polls waiting on the transaction can go on.
+	s.notify()
+	return status, nil
+}
+
+// readTxn reads a transaction's coordinator record.
+func (s *srv) readTxn(ctx context.Context, id string) (txnRecord, error) {
+	var rec txnRecord
+	raw, err := s.kv.Read(ctx, txnKey(id))
+	if err != nil {
+		return rec, err
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(buf, &rec)
+	return rec, err
+}
+
+func txnKey(id string) string {
+	return "txns/" + id
+}
+
+// poll returns up to a batch of records from each log, starting at the
+// requested offsets. Logs owned by other nodes are polled from them,
+// concurrently. Reads from lin-kv and other nodes share ctx, bounded by the
+// package timeout for a plain poll.
+//
+// A long poll, with a timeout, returns as soon as any log has records, or
+// else empty-handed once the timeout expires or ctx is done. Owners wait for
+// their own appends, and in shared mode, where other nodes append too, nodes
+// also check lin-kv every recheckInterval.
+func (s *srv) poll(ctx context.Context, req pollRequest) (map[string][]record, error) {
+	long := req.Timeout > 0
+	if long && len(req.Offsets) == 0 {
+		return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest, "long poll without offsets would never return records")
+	}
+	wait := timeout
+	if long {
+		wait = time.Duration(req.Timeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	groups := make(map[string]map[string]int) // owner, or "" for this node -> offsets
+	for key, offset := range req.Offsets {
+		owner := s.owner(key)
+		if owner == s.n.ID() {
+			owner = ""
+		}
+		if groups[owner] == nil {
+			groups[owner] = make(map[string]int)
+		}
+		groups[owner][key] = offset
+	}
+
+	type polled struct {
+		msgs map[string][]record
+		err  error
+	}
+	results := make(chan polled, len(groups))
+	for owner, offsets := range groups {
+		go func(owner string, offsets map[string]int) {
+			var p polled
+			if owner == "" {
+				p.msgs, p.err = s.pollLocal(ctx, offsets, long)
+			} else {
+				p.msgs, p.err = s.pollRemote(ctx, owner, offsets, req.Timeout)
+			}
+			results <- p
+		}(owner, offsets)
+	}
+
+	result := make(map[string][]record)
+	var firstErr error
+	for range groups {
+		p := <-results
+		if p.err != nil && firstErr == nil {
+			firstErr = p.err
+		}
+		for key, records := range p.msgs {
+			result[key] = records
+		}
+		if long && len(result) > 0 {
+			return result, nil
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+// pollLocal polls logs which this node serves, and if wait is set, waits
+// until one of them has records, or ctx is done, in which case it returns no
+// records rather than an error.
+func (s *srv) pollLocal(ctx context.Context, offsets map[string]int, wait bool) (map[string][]record, error) {
+	for {
+		// Take the channel before scanning, so no append is missed.
+		appended := s.appendedChan()
+		result := make(map[string][]record)
+		blocked := false
+		for key, offset := range offsets {
+			records, pending, err := s.scan(ctx, key, offset)
+			if err != nil && wait && ctx.Err() != nil {
+				return map[string][]record{}, nil
+			} else if err != nil {
+				return nil, err
+			}
+			if len(records) > 0 {
+				result[key] = records
+			}
+			blocked = blocked || pending
+		}
+		if len(result) > 0 || !wait {
+			return result, nil
+		}
+
+		// Another node may decide a pending transaction.
+		var recheck <-chan time.Time
+		if s.opts.mode != "partitioned" || blocked {
+			recheck = time.After(recheckInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return result, nil
+		case <-appended:
+		case <-recheck:
+		}
+	}
+}
+
+// pollRemote polls logs owned by another node. For a long poll, the owner
+// waits, and if ctx is done first, pollRemote returns no records rather
+// than an error.
+func (s *srv) pollRemote(ctx context.Context, owner string, offsets map[string]int, wait int) (map[string][]record, error) {
+	body, err := s.forward(ctx, owner, pollRequest{Type: "poll", Offsets: offsets, Timeout: wait})
+	if err != nil && wait > 0 && ctx.Err() != nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Msgs map[string][][2]int `json:"msgs"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	msgs := make(map[string][]record, len(resp.Msgs))
+	for key, pairs := range resp.Msgs {
+		for _, p := range pairs {
+			msgs[key] = append(msgs[key], record{offset: p[0], msg: p[1]})
+		}
+	}
+	return msgs, nil
+}
+
+// appendedChan returns a channel which is closed when this node next appends
+// a record to any log.
+func (s *srv) appendedChan() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.appended == nil {
+		s.appended = make(chan struct{})
+	}
+	return s.appended
+}
+
+// notify wakes the long polls waiting for an append.
+func (s *srv) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.appended != nil {
+		close(s.appended)
+		s.appended = nil
+	}
+}
+
+// subscribe starts pushing the records of logs from the given offsets on to
+// a subscriber, and returns the subscription's ID.
+func (s *srv) subscribe(dest string, offsets map[string]int) string {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.nextSub++
+	id := fmt.Sprintf("%s-%d", s.n.ID(), s.nextSub)
+	s.subs[id] = cancel
+	s.mu.Unlock()
+
+	go s.deliver(ctx, id, dest, offsets)
+	return id
+}
+
+// unsubscribe cancels a subscription, and reports whether it existed.
+func (s *srv) unsubscribe(id string) bool {
+	s.mu.Lock()
+	cancel, ok := s.subs[id]
+	delete(s.subs, id)
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// deliver long-polls logs for a subscription, and pushes each batch of
+// records it finds to the subscriber, until the subscription is cancelled or
+// the subscriber stops acknowledging them.
+func (s *srv) deliver(ctx context.Context, id, dest string, offsets map[string]int) {
+	defer s.unsubscribe(id)
+	for ctx.Err() == nil {
+		msgs, err := s.poll(ctx, pollRequest{Offsets: offsets, Timeout: int(timeout / time.Millisecond)})
 		if err != nil {
-			return err
+			log.Printf("subscription %s: %v", id, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(recheckInterval):
+			}
+			continue
+		} else if len(msgs) == 0 {
+			continue
 		}
-		return n.Reply(msg, sendResponse{
-			Type:   "send_ok",
-			Offset: offset,
-		})
-	})
 
-	n.Handle("poll", func(msg maelstrom.Message) error {
-		var body pollRequest
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
+		if !s.push(ctx, dest, recordsMessage{Type: "records", Subscription: id, Msgs: msgs}) {
+			log.Printf("subscription %s: %s stopped acknowledging records", id, dest)
+			return
 		}
-		msgs, err := s.poll(body)
-		if err != nil {
-			return err
+		for key, records := range msgs {
+			offsets[key] = records[len(records)-1].offset + 1
 		}
-		return n.Reply(msg, pollResponse{
-			Type: "poll_ok",
-			Msgs: msgs,
-		})
-	})
+	}
+}
 
-	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
-		var body commitOffsetsRequest
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
+// push sends records to a subscriber, and waits for it to acknowledge them,
+// resending them if it doesn't, up to pushAttempts times in all. So each
+// subscriber has at most one batch in flight, and controls the rate by when
+// it acknowledges them, but may see a batch more than once. It returns false
+// if the subscriber never acknowledges them, or rejects them, or the
+// subscription is cancelled.
+func (s *srv) push(ctx context.Context, dest string, body recordsMessage) bool {
+	for attempt := 0; attempt < pushAttempts; attempt++ {
+		// The records may have been found after the subscription was
+		// cancelled.
+		if ctx.Err() != nil {
+			return false
 		}
-		s.commitOffsets(body)
-		return n.Reply(msg, commitOffsetsResponse{
-			Type: "commit_offsets_ok",
-		})
-	})
+		acks := make(chan *maelstrom.RPCError, 1)
+		if err := s.n.RPC(dest, body, func(msg maelstrom.Message) error {
+			acks <- msg.RPCError()
+			return nil
+		}); err != nil {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case err := <-acks:
+			return err == nil
+		case <-time.After(timeout):
+		}
+	}
+	return false
+}
 
-	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
-		var body listCommittedOffsetsRequest
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
+// scan returns up to a batch of consecutive records from a log, starting at
+// offset, or at the start of the log if retention has deleted the records
+// before it. The segments holding them are read concurrently. It stops at the
+// first record of a pending transaction, and reports whether it did.
+func (s *srv) scan(ctx context.Context, key string, offset int) ([]record, bool, error) {
+	for {
+		if start := s.offset(s.start(key), 0); offset < start {
+			offset = start
 		}
+		first := (offset - firstOffset) / s.opts.segmentSize
+		last := (offset + s.opts.pollBatch - 1 - firstOffset) / s.opts.segmentSize
 
-		offsets, err := s.listCommittedOffsets(body)
-		if err != nil {
-			return err
+		segments := make([]segment, last-first+1)
+		errs := make([]error, len(segments))
+		var wg sync.WaitGroup
+		for i := range segments {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				segments[i], errs[i] = s.segment(ctx, key, first+i)
+			}(i)
 		}
-		return n.Reply(msg, listCommittedOffsetsResponse{
-			Type:    "list_committed_offsets_ok",
-			Offsets: offsets,
-		})
-	})
+		wg.Wait()
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
+		var records []record
+		truncated, ended, pending := false, false, false
+		for i := 0; !ended && len(records) < s.opts.pollBatch; i++ {
+			if i == len(segments) {
+				// Compaction deleted some of the records, so read on to fill
+				// the batch.
+				seg, err := s.segment(ctx, key, first+i)
+				segments, errs = append(segments, seg), append(errs, err)
+			}
+			seg := segments[i]
+			if errs[i] != nil {
+				return nil, false, errs[i]
+			}
+			truncated = truncated || seg.truncated
+			for j, e := range seg.msgs {
+				o := s.offset(seg.n, j)
+				if o < offset || e.deleted || len(records) == s.opts.pollBatch {
+					continue
+				}
+				if e.txn != "" {
+					status, err := s.txnStatus(ctx, e.txn)
+					if err != nil {
+						return nil, false, err
+					} else if status == txnPending {
+						pending = true
+						break
+					} else if status == txnAborted {
+						continue
+					}
+				}
+				records = append(records, record{offset: o, msg: e.msg})
+			}
+			ended = pending || !seg.truncated && len(seg.msgs) < s.opts.segmentSize
+		}
+		if !truncated {
+			return records, pending, nil
+		}
+
+		// Some of the records have been deleted since this node last looked,
+		// so find out where the log starts now, and skip to it.
+		start, _, err := s.getOffset(startPrefix, key)
+		if err != nil {
+			return nil, false, err
+		} else if start <= offset {
+			return records, pending, nil
+		}
+		s.setStart(key, start)
 	}
 }
 
-type sendRequest struct {
-	Type string `json:"type"`
-	Key  string `json:"key"`
-	Msg  int    `json:"msg"`
+// offset returns the offset of the i'th record in segment n.
+func (s *srv) offset(n, i int) int {
+	return firstOffset + n*s.opts.segmentSize + i
 }
 
-type sendResponse struct {
-	Type   string `json:"type"`
-	Offset int    `json:"offset"`
-}
+// tail returns the last segment of a log this node has seen, or if none, the
+// one the index points to.
+func (s *srv) tail(ctx context.Context, key string) (segment, error) {
+	s.mu.Lock()
+	tail, ok := s.tails[key]
+	s.mu.Unlock()
+	if ok {
+		return tail, nil
+	}
 
-type pollRequest struct {
-	Type    string         `json:"type"`
-	Offsets map[string]int `json:"offsets"`
+	n, _, err := s.getOffset(indexPrefix, key)
+	if err != nil {
+		return segment{}, err
+	}
+	return s.readSegment(ctx, key, n)
 }
 
-type pollResponse struct {
-	Type string              `json:"type"`
-	Msgs map[string][]record `json:"msgs"`
+// setTail records the last segment of a log this node has seen, unless it
+// has already seen a later one.
+func (s *srv) setTail(key string, seg segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tail, ok := s.tails[key]; !ok || seg.n > tail.n || seg.n == tail.n && len(seg.msgs) > len(tail.msgs) {
+		s.tails[key] = seg
+	}
 }
 
-type record struct {
-	offset int
-	msg    int
+// segment returns segment n of a log, from the cache if it is full, or if
+// this node owns the log and has seen its tail.
+func (s *srv) segment(ctx context.Context, key string, n int) (segment, error) {
+	s.mu.Lock()
+	msgs, ok := s.sealed[key][n]
+	tail, known := s.tails[key]
+	start := s.starts[key]
+	s.mu.Unlock()
+	if n < start {
+		return segment{n: n, truncated: true}, nil
+	} else if ok {
+		return segment{n: n, msgs: msgs}, nil
+	}
+	if known && s.owns(key) {
+		if n == tail.n {
+			return tail, nil
+		} else if n > tail.n {
+			return segment{n: n}, nil
+		}
+	}
+	return s.readSegment(ctx, key, n)
 }
 
-func (r record) MarshalJSON() ([]byte, error) {
-	return json.Marshal([]int{r.offset, r.msg})
+// readSegment reads segment n of a log from lin-kv, and caches it if it is
+// full.
+func (s *srv) readSegment(ctx context.Context, key string, n int) (segment, error) {
+	v, err := s.kv.Read(ctx, segmentKey(key, n))
+	var rpcerr *maelstrom.RPCError
+	if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.KeyDoesNotExist {
+		return segment{n: n}, nil
+	} else if err != nil {
+		return segment{}, err
+	}
+
+	list, ok := v.([]any)
+	if !ok {
+		return segment{n: n, truncated: true}, nil
+	}
+	seg := segment{n: n, msgs: make([]entry, len(list))}
+	for i, m := range list {
+		seg.msgs[i] = parseEntry(m)
+	}
+	// Only a segment which isn't full can be the tail.
+	if len(seg.msgs) == s.opts.segmentSize {
+		s.seal(key, seg)
+	} else {
+		s.setTail(key, seg)
+	}
+	return seg, nil
 }
 
-type commitOffsetsRequest struct {
-	Type    string         `json:"type"`
-	Offsets map[string]int `json:"offsets"`
+// seal caches a full segment.
+func (s *srv) seal(key string, seg segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seg.n < s.starts[key] {
+		return
+	}
+	if s.sealed[key] == nil {
+		s.sealed[key] = make(map[int][]entry)
+	}
+	s.sealed[key][seg.n] = seg.msgs
 }
 
-type commitOffsetsResponse struct {
-	Type string `json:"type"`
+// Retention deletes whole segments from the front of a log, and compaction
+// deletes records from full segments. Neither touches the tail, so they never
+// contend with sends. Both run in the background on the node which starts a
+// new segment.
+//
+// Lin-kv has no deletes, so retention overwrites a segment with a tombstone,
+// having first raised "start/<key>", the first offset of the first segment
+// which may not be truncated. A poll which runs into a tombstone re-reads the
+// start, and skips forward to it, but a node which has cached a segment keeps
+// serving it until it learns of the new start. Compaction replaces each
+// superseded record with a null, so the offsets of the others don't change,
+// using a compare-and-swap so that it never brings back a segment which
+// retention has deleted.
+
+// start returns the first segment of a log which this node knows may not be
+// truncated.
+func (s *srv) start(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.starts[key]
 }
 
-type listCommittedOffsetsRequest struct {
-	Type string   `json:"type"`
-	Keys []string `json:"keys"`
+// setStart records that a log starts at offset, and forgets the segments
+// before it.
+func (s *srv) setStart(key string, offset int) {
+	n := (offset - firstOffset) / s.opts.segmentSize
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for m := s.starts[key]; m < n; m++ {
+		delete(s.sealed[key], m)
+	}
+	if n > s.starts[key] {
+		s.starts[key] = n
+	}
 }
 
-type listCommittedOffsetsResponse struct {
-	Type    string         `json:"type"`
-	Offsets map[string]int `json:"offsets"`
+// maintain applies the retention policies to a log, once it has started
+// segment tail.
+//
+// Only one pass runs for each log at a time. If the log grows in the
+// meantime, the running pass goes round again for the new tail.
+func (s *srv) maintain(key string, tail int) {
+	s.mu.Lock()
+	latest, running := s.maintaining[key]
+	if tail > latest {
+		s.maintaining[key] = tail
+	}
+	s.mu.Unlock()
+	if running {
+		return
+	}
+
+	for {
+		if err := s.truncate(key, tail); err != nil {
+			log.Printf("truncating %s: %v", key, err)
+		}
+		if s.opts.compact {
+			if err := s.compact(key, tail); err != nil {
+				log.Printf("compacting %s: %v", key, err)
+			}
+		}
+
+		s.mu.Lock()
+		if latest := s.maintaining[key]; latest > tail {
+			tail = latest
+			s.mu.Unlock()
+			continue
+		}
+		delete(s.maintaining, key)
+		s.mu.Unlock()
+		return
+	}
 }
 
-type srv struct {
-	kv *maelstrom.KV
+// truncate deletes the segments of a log before tail which every retention
+// policy allows.
+func (s *srv) truncate(key string, tail int) error {
+	if s.opts.retainRecords <= 0 && !s.opts.retainCommitted {
+		return nil
+	}
+	// The segments entirely before keep may be deleted.
+	segmentsBefore := func(keep int) int {
+		if keep <= firstOffset {
+			return 0
+		}
+		return (keep - firstOffset) / s.opts.segmentSize
+	}
+
+	end := tail
+	if s.opts.retainRecords > 0 {
+		if n := segmentsBefore(s.offset(tail, 0) - s.opts.retainRecords + 1); n < end {
+			end = n
+		}
+	}
+	if s.opts.retainCommitted {
+		committed, err := s.minCommitted(key)
+		if err != nil {
+			return err
+		}
+		if n := segmentsBefore(committed); n < end {
+			end = n
+		}
+	}
+
+	offset, _, err := s.getOffset(startPrefix, key)
+	if err != nil {
+		return err
+	}
+	s.setStart(key, offset)
+	start := s.start(key)
+	if end <= start {
+		return nil
+	}
+	if err := s.maxOffset(startPrefix, key, s.offset(end, 0)); err != nil {
+		return err
+	}
+	s.setStart(key, s.offset(end, 0))
+	for n := start; n < end; n++ {
+		if err := s.kv.Write(context.TODO(), segmentKey(key, n), tombstone); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func newSrv(n *maelstrom.Node) *srv {
-	return &srv{
-		kv: maelstrom.NewLinKV(n),
+// compact deletes the records in a log's full segments which a later record
+// with the same subkey supersedes.
+func (s *srv) compact(key string, tail int) error {
+	ctx := context.TODO()
+	start := s.start(key)
+	segments := make([]segment, 0, tail-start+1)
+	for n := start; n <= tail; n++ {
+		seg, err := s.segment(ctx, key, n)
+		if err != nil {
+			return err
+		}
+		segments = append(segments, seg)
+	}
+
+	latest := make(map[string]int) // subkey -> offset
+	for _, seg := range segments {
+		for i, e := range seg.msgs {
+			if e.subkey != "" && !e.deleted {
+				latest[e.subkey] = s.offset(seg.n, i)
+			}
+		}
+	}
+
+	for _, seg := range segments {
+		if seg.truncated || len(seg.msgs) < s.opts.segmentSize {
+			continue
+		}
+		msgs := append([]entry{}, seg.msgs...)
+		changed := false
+		for i, e := range msgs {
+			if e.subkey != "" && !e.deleted && latest[e.subkey] > s.offset(seg.n, i) {
+				msgs[i] = entry{deleted: true}
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		err := s.kv.CompareAndSwap(ctx, segmentKey(key, seg.n), seg.msgs, msgs, false)
+		var rpcerr *maelstrom.RPCError
+		if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
+			// Another node compacted or truncated the segment first, so leave
+			// it to them, but catch up.
+			if _, err := s.readSegment(ctx, key, seg.n); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		s.seal(key, segment{n: seg.n, msgs: msgs})
 	}
+	return nil
 }
 
-func (s *srv) send(req sendRequest) (int, error) {
-	offset, err := s.incrementAndGetOffer(pendingPrefix, req.Key)
+// minCommitted returns the lowest offset committed for a log by any
+// registered group, or firstOffset, which keeps the whole log, if any of them
+// hasn't committed one for it yet, or none is registered. Consumers using the
+// base protocol register as the group "", from their first commit.
+func (s *srv) minCommitted(key string) (int, error) {
+	groups, _, err := s.registeredGroups()
 	if err != nil {
 		return 0, err
 	}
+	lowest, found := 0, false
+	for _, group := range groups {
+		var offset int
+		var ok bool
+		if group == "" {
+			if offset, ok, err = s.getOffset(committedPrefix, key); err != nil {
+				return 0, err
+			}
+		} else {
+			g, _, err := s.group(group)
+			if err != nil {
+				return 0, err
+			}
+			offset, ok = g.Offsets[key]
+		}
+		if !ok {
+			return firstOffset, nil
+		}
+		if !found || offset < lowest {
+			lowest, found = offset, true
+		}
+	}
+	if !found {
+		return firstOffset, nil
+	}
+	return lowest, nil
+}
 
-	if err := s.appendLogEntry(req.Key, record{msg: req.Msg, offset: offset}); err != nil {
-		return 0, err
+// registeredGroups returns the groups which have joined or committed
+// offsets, and the raw lin-kv value holding them, or nil if there are none.
+func (s *srv) registeredGroups() ([]string, any, error) {
+	v, err := s.kv.Read(context.TODO(), groupsKey)
+	var rpcerr *maelstrom.RPCError
+	if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.KeyDoesNotExist {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	list, _ := v.([]any)
+	groups := make([]string, len(list))
+	for i, g := range list {
+		groups[i], _ = g.(string)
 	}
-	return offset, nil
+	return groups, v, nil
 }
 
-func (s *srv) poll(req pollRequest) (map[string][]record, error) {
-	result := make(map[string][]record)
-	for key, offset := range req.Offsets {
-		for i := 0; i < 10; i++ {
-			if r, err := s.readLogEntry(key, offset+i); err != nil {
-				return nil, err
-			} else if r != nil {
-				result[key] = append(result[key], *r)
+// registerGroup adds a group to those whose committed offsets retention
+// respects.
+func (s *srv) registerGroup(group string) error {
+	s.mu.Lock()
+	registered := s.groups[group]
+	s.mu.Unlock()
+	if registered {
+		return nil
+	}
+
+	for {
+		groups, raw, err := s.registeredGroups()
+		if err != nil {
+			return err
+		}
+		i := sort.SearchStrings(groups, group)
+		if i == len(groups) || groups[i] != group {
+			next := append(append(append([]string{}, groups[:i]...), group), groups[i:]...)
+			err := s.kv.CompareAndSwap(context.TODO(), groupsKey, raw, next, raw == nil)
+			var rpcerr *maelstrom.RPCError
+			if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
+				continue
+			} else if err != nil {
+				return err
 			}
 		}
+
+		s.mu.Lock()
+		s.groups[group] = true
+		s.mu.Unlock()
+		return nil
 	}
-	return result, nil
 }
 
 func (s *srv) commitOffsets(req commitOffsetsRequest) error {
-	for key, offset := range req.Offsets {
-		if err := s.maxOffset(committedPrefix, key, offset); err != nil {
+	if s.opts.retainCommitted {
+		if err := s.registerGroup(req.Group); err != nil {
 			return err
 		}
 	}
-	return nil
+	if req.Group == "" {
+		for key, offset := range req.Offsets {
+			if err := s.maxOffset(committedPrefix, key, offset); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	_, err := s.updateGroup(req.Group, func(g *membership) (bool, error) {
+		if req.Generation != 0 && g.Generation != req.Generation {
+			return false, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("group %s is at generation %d, not %d", req.Group, g.Generation, req.Generation))
+		}
+		return g.commit(req.Offsets), nil
+	})
+	return err
 }
 
 func (s *srv) listCommittedOffsets(req listCommittedOffsetsRequest) (map[string]int, error) {
 	result := make(map[string]int)
+	if req.Group != "" {
+		g, _, err := s.group(req.Group)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range req.Keys {
+			if offset, ok := g.Offsets[key]; ok {
+				result[key] = offset
+			}
+		}
+		return result, nil
+	}
+
 	for _, key := range req.Keys {
 		offset, ok, err := s.getOffset(committedPrefix, key)
 		if err != nil {
@@ -186,30 +1722,151 @@ func (s *srv) listCommittedOffsets(req listCommittedOffsetsRequest) (map[string]
 	return result, nil
 }
 
-const pendingPrefix = "pending"
-const committedPrefix = "committed"
+// A consumer group's membership and committed offsets are stored together as
+// a single lin-kv value under "groups/<group>", which joins, leaves and
+// commits update with a compare-and-swap. Its generation counts the changes
+// in membership, so a consumer can tell that its assignment is out of date,
+// and since a commit swaps the same value, one which checks the generation
+// can't land after a change.
+type membership struct {
+	Generation int            `json:"generation"`
+	Members    []string       `json:"members"` // sorted
+	Offsets    map[string]int `json:"offsets,omitempty"`
+}
 
-func (s *srv) incrementAndGetOffer(prefix, key string) (int, error) {
-	loc := prefix + "/" + key
+// join adds a consumer to the group, and reports whether it wasn't already
+// a member.
+func (g *membership) join(consumer string) bool {
+	i := sort.SearchStrings(g.Members, consumer)
+	if i < len(g.Members) && g.Members[i] == consumer {
+		return false
+	}
+	members := make([]string, 0, len(g.Members)+1)
+	g.Members = append(append(append(members, g.Members[:i]...), consumer), g.Members[i:]...)
+	g.Generation++
+	return true
+}
+
+// leave removes a consumer from the group, and reports whether it was a
+// member.
+func (g *membership) leave(consumer string) bool {
+	i := sort.SearchStrings(g.Members, consumer)
+	if i == len(g.Members) || g.Members[i] != consumer {
+		return false
+	}
+	members := make([]string, 0, len(g.Members)-1)
+	g.Members = append(append(members, g.Members[:i]...), g.Members[i+1:]...)
+	g.Generation++
+	return true
+}
+
+// commit raises the group's committed offsets, and reports whether any of
+// them changed.
+func (g *membership) commit(offsets map[string]int) bool {
+	changed := false
+	for key, offset := range offsets {
+		if cur, ok := g.Offsets[key]; ok && cur >= offset {
+			continue
+		}
+		if g.Offsets == nil {
+			g.Offsets = make(map[string]int)
+		}
+		g.Offsets[key] = offset
+		changed = true
+	}
+	return changed
+}
+
+// assign returns the logs a member consumes, out of all those its group
+// does. The sorted logs are dealt out to the sorted members in turn, so
+// members with the same view of the group agree without talking to each
+// other. It returns false if the consumer isn't a member.
+func (g membership) assign(consumer string, keys []string) ([]string, bool) {
+	i := sort.SearchStrings(g.Members, consumer)
+	if i == len(g.Members) || g.Members[i] != consumer {
+		return nil, false
+	}
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	var assigned []string
+	for j := i; j < len(keys); j += len(g.Members) {
+		assigned = append(assigned, keys[j])
+	}
+	return assigned, true
+}
+
+// group returns a group's membership, and the raw lin-kv value holding it,
+// or nil if it has never had any members or commits.
+func (s *srv) group(group string) (membership, any, error) {
+	v, err := s.kv.Read(context.TODO(), groupKey(group))
+	var rpcerr *maelstrom.RPCError
+	if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.KeyDoesNotExist {
+		return membership{}, nil, nil
+	} else if err != nil {
+		return membership{}, nil, err
+	}
+
+	var g membership
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return membership{}, nil, err
+	}
+	if err := json.Unmarshal(buf, &g); err != nil {
+		return membership{}, nil, err
+	}
+	return g, v, nil
+}
+
+// updateGroup applies f to a group's membership, and returns the result. f
+// reports whether it changed anything, and if not, nothing is written.
+func (s *srv) updateGroup(group string, f func(*membership) (bool, error)) (membership, error) {
 	for {
-		cur, keyExists, err := s.getOffset(prefix, key)
+		g, raw, err := s.group(group)
 		if err != nil {
-			return 0, err
+			return membership{}, err
+		}
+		if changed, err := f(&g); err != nil {
+			return membership{}, err
+		} else if !changed {
+			return g, nil
 		}
 
-		next := cur + 1
-		if err := s.kv.CompareAndSwap(context.TODO(), loc, cur, next, !keyExists); err != nil {
-			var rpcerr *maelstrom.RPCError
-			if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
-				continue
-			} else {
-				return 0, err
-			}
+		err = s.kv.CompareAndSwap(context.TODO(), groupKey(group), raw, g, raw == nil)
+		var rpcerr *maelstrom.RPCError
+		if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.PreconditionFailed {
+			continue
+		} else if err != nil {
+			return membership{}, err
 		}
-		return next, nil
+		return g, nil
 	}
 }
 
+// groupKey is the lin-kv key holding a group's membership.
+func groupKey(group string) string {
+	return "groups/" + group
+}
+
+const committedPrefix = "committed"
+
+// indexPrefix holds the number of a segment of each log which is known to
+// exist, and no later than the last one.
+const indexPrefix = "index"
+
+// startPrefix holds the first offset of each log which retention hasn't
+// deleted.
+const startPrefix = "start"
+
+// groupsKey is the lin-kv key holding the sorted groups which have committed
+// offsets.
+const groupsKey = "groups"
+
+// tombstone replaces a segment which retention has deleted.
+const tombstone = "truncated"
+
+// firstOffset is the offset of the first record in each log.
+const firstOffset = 1
+
 func (s *srv) maxOffset(prefix, key string, target int) error {
 	loc := prefix + "/" + key
 	for {
@@ -248,22 +1905,7 @@ func (s *srv) getOffset(prefix, key string) (int, bool, error) {
 	return cur, true, nil
 }
 
-const recordsPrefix = "records"
-
-func (s *srv) appendLogEntry(key string, r record) error {
-	loc := fmt.Sprintf("%s/%s/%d", recordsPrefix, key, r.offset)
-	return s.kv.Write(context.TODO(), loc, r.msg)
-}
-
-func (s *srv) readLogEntry(key string, offset int) (*record, error) {
-	loc := fmt.Sprintf("%s/%s/%d", recordsPrefix, key, offset)
-	msg, err := s.kv.ReadInt(context.TODO(), loc)
-	if err != nil {
-		var rpcerr *maelstrom.RPCError
-		if errors.As(err, &rpcerr) && rpcerr.Code == maelstrom.KeyDoesNotExist {
-			return nil, nil
-		}
-		return nil, err
-	}
-	return &record{offset: offset, msg: msg}, nil
+// segmentKey is the lin-kv key holding segment n of a log.
+func segmentKey(key string, n int) string {
+	return fmt.Sprintf("segments/%s/%d", key, n)
 }
@@ -0,0 +1,1181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+	"github.com/jepsen-io/maelstrom/demo/go/workload"
+)
+
+func TestMain(m *testing.M) {
+	// Nodes log every message they send and receive.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestServer(t *testing.T) {
+	for _, mode := range []string{"shared", "partitioned"} {
+		for _, nodes := range []int{1, 2, 3} {
+			t.Run(fmt.Sprintf("%s/%d-nodes", mode, nodes), func(t *testing.T) {
+				res := run(t, newCluster(t, options{mode: mode}, nodes), workload.Config{
+					Concurrency: 2 * nodes,
+					Rate:        200,
+					TimeLimit:   time.Second,
+				})
+				if res.Valid != checker.Valid {
+					t.Fatalf("unexpected results:\n%s", res)
+				}
+				checkDense(t, res.History)
+			})
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	c := newCluster(t, options{pollBatch: 10, segmentSize: 10}, 1)
+	c.init(t)
+	for i := 0; i < 25; i++ {
+		c.rpc(t, "n0", map[string]any{"type": "send", "key": "k", "msg": i})
+	}
+
+	for _, tt := range []struct {
+		name   string
+		offset int
+		want   string
+	}{
+		{"Start", 0, "1-10"},
+		{"Segment", 11, "11-20"},
+		{"Spanning", 12, "12-21"},
+		{"End", 20, "20-25"},
+		{"PastEnd", 30, "none"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := offsetRange(c.poll(t, "n0", "k", tt.offset)); got != tt.want {
+				t.Fatalf("offsets=%s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	t.Run("Forwarded", func(t *testing.T) {
+		// Every node returns the same records, whichever node owns the log.
+		c := newCluster(t, options{mode: "partitioned", segmentSize: 10}, 3)
+		c.init(t)
+		for i := 0; i < 15; i++ {
+			c.rpc(t, c.nodes[i%3], map[string]any{"type": "send", "key": "k", "msg": i})
+		}
+		for _, id := range c.nodes {
+			if got, want := offsetRange(c.poll(t, id, "k", 6)), "6-15"; got != want {
+				t.Fatalf("%s: offsets=%s, want %s", id, got, want)
+			}
+		}
+		for _, s := range c.srvs {
+			if got := s.conflicts.Load(); got != 0 {
+				t.Fatalf("%d conflicting appends, want none", got)
+			}
+		}
+	})
+
+	t.Run("StaleIndex", func(t *testing.T) {
+		// A node which hasn't seen the log starts from the index, and walks
+		// forward from there if it lags.
+		c := newCluster(t, options{segmentSize: 10}, 2)
+		c.init(t)
+		for i := 0; i < 25; i++ {
+			c.rpc(t, "n0", map[string]any{"type": "send", "key": "k", "msg": i})
+		}
+		c.rpc(t, maelstrom.LinKV, map[string]any{"type": "write", "key": "index/k", "value": 0})
+
+		var resp struct {
+			Offset int `json:"offset"`
+		}
+		if err := json.Unmarshal(c.rpc(t, "n1", map[string]any{"type": "send", "key": "k", "msg": 25}), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := resp.Offset, 26; got != want {
+			t.Fatalf("offset=%d, want %d", got, want)
+		}
+		if got, want := offsetRange(c.poll(t, "n0", "k", 21)), "21-26"; got != want {
+			t.Fatalf("offsets=%s, want %s", got, want)
+		}
+	})
+}
+
+func TestIdempotent(t *testing.T) {
+	for _, mode := range []string{"shared", "partitioned"} {
+		t.Run(mode, func(t *testing.T) {
+			c := newCluster(t, options{mode: mode, segmentSize: 10}, 2)
+			c.init(t)
+
+			// Every other reply to a send is lost, after its record was
+			// appended, so the producer retries it through the other node.
+			var sends atomic.Int64
+			c.net.Drop(func(msg maelstrom.Message) bool {
+				return msg.Type() == "send_ok" && msg.Dest == "c0" && sends.Add(1)%2 == 1
+			})
+			for i := 0; i < 15; i++ {
+				got, want := c.sendIdempotent(t, "k", "p", i+1, i), firstOffset+i
+				if got != want {
+					t.Fatalf("send %d: offset=%d, want %d", i, got, want)
+				}
+			}
+			c.net.Drop(nil)
+			checkLog(t, c, "k", 15)
+		})
+	}
+
+	t.Run("Stale", func(t *testing.T) {
+		c := newCluster(t, options{}, 1)
+		c.init(t)
+		c.sendIdempotent(t, "k", "p", 2, 0)
+		_, err := c.call("n0", sendRequest{Type: "send", Key: "k", Msg: 1, Producer: "p", Seq: 1})
+		if got, want := maelstrom.ErrorCode(err), maelstrom.PreconditionFailed; got != want {
+			t.Fatalf("error=%v, want code %d", err, want)
+		}
+
+		// Another producer's sequence is separate.
+		if got, want := c.sendIdempotent(t, "k", "q", 1, 2), firstOffset+1; got != want {
+			t.Fatalf("offset=%d, want %d", got, want)
+		}
+	})
+}
+
+// sendIdempotent sends msg to a log as the given producer, retrying on
+// alternate nodes until it gets a reply, and returns its offset.
+func (c *cluster) sendIdempotent(tb testing.TB, key, producer string, seq, msg int) int {
+	tb.Helper()
+	if c.client == nil {
+		c.client = c.net.AddClient("c0")
+	}
+	req := sendRequest{Type: "send", Key: key, Msg: msg, Producer: producer, Seq: seq}
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		resp, err := c.client.SyncRPC(ctx, c.nodes[i%len(c.nodes)], req)
+		cancel()
+		if err == context.DeadlineExceeded {
+			continue
+		} else if err != nil {
+			tb.Fatal(err)
+		}
+		var body struct {
+			Offset int `json:"offset"`
+		}
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			tb.Fatal(err)
+		}
+		return body.Offset
+	}
+	tb.Fatalf("send %d from %s: no reply", seq, producer)
+	return 0
+}
+
+func TestSendBatch(t *testing.T) {
+	for _, mode := range []string{"shared", "partitioned"} {
+		t.Run(mode, func(t *testing.T) {
+			// A batch which fits in the tail goes in whole, and one which
+			// doesn't starts a new segment.
+			c := newCluster(t, options{mode: mode, segmentSize: 10, pollBatch: 20}, 3)
+			c.init(t)
+			for i := 0; i < 3; i++ {
+				c.rpc(t, "n0", map[string]any{"type": "send", "key": "a", "msg": i})
+			}
+			resp := c.sendBatch(t, "n1", map[string][]int{"a": seq(3, 8), "b": seq(0, 2), "c": nil})
+			if got, want := fmt.Sprint(resp.Offsets, resp.Errors), "map[a:[4 5 6 7 8] b:[1 2] c:[]] map[]"; got != want {
+				t.Fatalf("response=%s, want %s", got, want)
+			}
+			resp = c.sendBatch(t, "n1", map[string][]int{"a": seq(8, 12)})
+			if got, want := fmt.Sprint(resp.Offsets, resp.Errors), "map[a:[11 12 13 14]] map[]"; got != want {
+				t.Fatalf("response=%s, want %s", got, want)
+			}
+			checkBatches(t, c, "a", "[1 2 3 4 5 6 7 8 11 12 13 14]", 12)
+		})
+	}
+
+	t.Run("TooLarge", func(t *testing.T) {
+		c := newCluster(t, options{segmentSize: 10}, 1)
+		c.init(t)
+		resp := c.sendBatch(t, "n0", map[string][]int{"a": seq(0, 11), "b": seq(0, 1)})
+		if got, want := fmt.Sprint(resp.Offsets), "map[a:[] b:[1]]"; got != want {
+			t.Fatalf("offsets=%s, want %s", got, want)
+		}
+		if got, want := resp.Errors["a"].Code, maelstrom.MalformedRequest; got != want {
+			t.Fatalf("error=%+v, want code %d", resp.Errors["a"], want)
+		}
+	})
+
+	t.Run("PartialFailure", func(t *testing.T) {
+		c := newCluster(t, options{segmentSize: 10, pollBatch: 20}, 1)
+		c.init(t)
+		for i := 0; i < 5; i++ {
+			c.rpc(t, "n0", map[string]any{"type": "send", "key": "a", "msg": i})
+		}
+
+		// Appends to the second segment of a, and to b, are lost on the way
+		// to lin-kv. a's batch doesn't fit in its tail, so the nulls filling
+		// the tail are appended, but none of the batch is.
+		c.net.Drop(func(msg maelstrom.Message) bool {
+			var body struct {
+				Key string `json:"key"`
+			}
+			json.Unmarshal(msg.Body, &body)
+			return msg.Type() == "cas" && (body.Key == "segments/a/1" || body.Key == "segments/b/0")
+		})
+		resp := c.sendBatch(t, "n0", map[string][]int{"a": seq(5, 12), "b": seq(0, 1)})
+		if got, want := fmt.Sprint(resp.Offsets), "map[a:[] b:[]]"; got != want {
+			t.Fatalf("offsets=%s, want %s", got, want)
+		}
+		for _, key := range []string{"a", "b"} {
+			if got, want := resp.Errors[key].Code, maelstrom.Timeout; got != want {
+				t.Fatalf("%s: error=%+v, want code %d", key, resp.Errors[key], want)
+			}
+		}
+
+		// Retrying the batches appends each message exactly once.
+		c.net.Drop(nil)
+		resp = c.sendBatch(t, "n0", map[string][]int{"a": seq(5, 12), "b": seq(0, 1)})
+		if got, want := fmt.Sprint(resp.Offsets, resp.Errors), "map[a:[11 12 13 14 15 16 17] b:[1]] map[]"; got != want {
+			t.Fatalf("response=%s, want %s", got, want)
+		}
+		checkBatches(t, c, "a", "[1 2 3 4 5 11 12 13 14 15 16 17]", 12)
+	})
+
+	t.Run("Interleaved", func(t *testing.T) {
+		// Batches from n1 keep crossing segment boundaries while n0 sends
+		// single messages to the same log, but no send lands inside a batch.
+		c := newCluster(t, options{segmentSize: 10}, 2)
+		c.net.Latency = 2 * time.Millisecond
+		c.init(t)
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c.call("n0", map[string]any{"type": "send", "key": "a", "msg": -1})
+			}
+		}()
+
+		var batches [][]int
+		for i := 0; i < 20; i++ {
+			msgs := seq(4*i, 4*i+4)
+			resp := c.sendBatch(t, "n1", map[string][]int{"a": msgs})
+			if e, ok := resp.Errors["a"]; ok {
+				t.Fatalf("batch %d: error=%+v", i, e)
+			}
+			batches = append(batches, resp.Offsets["a"])
+		}
+		close(stop)
+		<-done
+
+		for i, offsets := range batches {
+			records := c.poll(t, "n0", "a", offsets[0])
+			if len(records) > 4 {
+				records = records[:4]
+			}
+			if got, want := fmt.Sprint(records), fmt.Sprint([][2]int{
+				{offsets[0], 4 * i}, {offsets[0] + 1, 4*i + 1}, {offsets[0] + 2, 4*i + 2}, {offsets[0] + 3, 4*i + 3},
+			}); got != want {
+				t.Fatalf("batch %d at %v: records=%s, want %s", i, offsets, got, want)
+			}
+		}
+	})
+}
+
+// checkBatches checks that a log holds the messages 0 to n-1, in order, at
+// the given offsets, by polling it from n0.
+func checkBatches(tb testing.TB, c *cluster, key, want string, n int) {
+	tb.Helper()
+	records := c.poll(tb, "n0", key, firstOffset)
+	if got := offsets(records); got != want {
+		tb.Fatalf("log %s: offsets=%s, want %s", key, got, want)
+	}
+	var msgs []int
+	for _, r := range records {
+		msgs = append(msgs, r[1])
+	}
+	if got, want := fmt.Sprint(msgs), fmt.Sprint(seq(0, n)); got != want {
+		tb.Fatalf("log %s: messages=%s, want %s", key, got, want)
+	}
+}
+
+// seq returns the integers from i up to, but not including, j.
+func seq(i, j int) []int {
+	var s []int
+	for ; i < j; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+// checkLog checks that a log holds the messages 0 to n-1, at consecutive
+// offsets, by polling it from n0.
+func checkLog(tb testing.TB, c *cluster, key string, n int) {
+	tb.Helper()
+	var msgs []int
+	for offset := firstOffset; len(msgs) < n; {
+		records := c.poll(tb, "n0", key, offset)
+		if len(records) == 0 {
+			break
+		}
+		for _, r := range records {
+			if r[0] != firstOffset+len(msgs) {
+				tb.Fatalf("log %s: offset %d after %d messages", key, r[0], len(msgs))
+			}
+			msgs = append(msgs, r[1])
+		}
+		offset = records[len(records)-1][0] + 1
+	}
+	if got, want := fmt.Sprint(msgs), fmt.Sprint(seq(0, n)); got != want {
+		tb.Fatalf("log %s: messages=%s, want %s", key, got, want)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	for _, mode := range []string{"shared", "partitioned"} {
+		t.Run(mode, func(t *testing.T) {
+			// Concurrent transactions through every node all commit.
+			c := newCluster(t, options{mode: mode, segmentSize: 10}, 3)
+			c.init(t)
+			results := make(chan sendTxnResponse, 12)
+			for i := 0; i < cap(results); i++ {
+				go func(i int) {
+					var resp sendTxnResponse
+					body, err := c.call(c.nodes[i%3], sendTxnRequest{Type: "send_txn", Msgs: map[string][]int{"a": {i}, "b": {i, i}, "c": nil}})
+					if err == nil {
+						err = json.Unmarshal(body, &resp)
+					}
+					if err != nil {
+						t.Error(err)
+					}
+					results <- resp
+				}(i)
+			}
+
+			offsets := make(map[string]map[int]bool)
+			for i := 0; i < cap(results); i++ {
+				resp := <-results
+				if got, want := fmt.Sprint(len(resp.Offsets["a"]), len(resp.Offsets["b"]), resp.Offsets["c"]), "1 2 []"; got != want {
+					t.Fatalf("offsets=%v, want lengths %s", resp.Offsets, want)
+				}
+				for key, list := range resp.Offsets {
+					for _, o := range list {
+						if offsets[key] == nil {
+							offsets[key] = make(map[int]bool)
+						}
+						offsets[key][o] = true
+					}
+				}
+			}
+			for key, n := range map[string]int{"a": 12, "b": 24} {
+				records := c.poll(t, "n0", key, firstOffset)
+				for offset := firstOffset; len(records) < n; {
+					offset = records[len(records)-1][0] + 1
+					records = append(records, c.poll(t, "n0", key, offset)...)
+				}
+				for _, r := range records {
+					if !offsets[key][r[0]] {
+						t.Fatalf("log %s: unexpected record %v", key, r)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("Aborted", func(t *testing.T) {
+		// A transaction which can't append to one log appends to none.
+		c := newCluster(t, options{segmentSize: 10}, 1)
+		c.init(t)
+		c.rpc(t, "n0", map[string]any{"type": "send", "key": "a", "msg": 0})
+		c.net.Drop(func(msg maelstrom.Message) bool {
+			var body struct {
+				Key string `json:"key"`
+			}
+			json.Unmarshal(msg.Body, &body)
+			return msg.Type() == "cas" && body.Key == "segments/b/0"
+		})
+		_, err := c.call("n0", sendTxnRequest{Type: "send_txn", Msgs: map[string][]int{"a": {1, 2}, "b": {0}}})
+		if got, want := maelstrom.ErrorCode(err), maelstrom.TxnConflict; got != want {
+			t.Fatalf("error=%v, want code %d", err, want)
+		}
+
+		// Its records keep their offsets, but polls skip them.
+		c.net.Drop(nil)
+		c.rpc(t, "n0", map[string]any{"type": "send", "key": "a", "msg": 3})
+		if got, want := offsets(c.poll(t, "n0", "a", firstOffset)), "[1 4]"; got != want {
+			t.Fatalf("offsets=%s, want %s", got, want)
+		}
+		if got := c.poll(t, "n0", "b", firstOffset); len(got) != 0 {
+			t.Fatalf("records=%v, want none", got)
+		}
+	})
+
+	t.Run("Pending", func(t *testing.T) {
+		// The coordinator's commit is lost, so its transaction stays pending
+		// until a reader aborts it.
+		c := newCluster(t, options{segmentSize: 10, txnTimeout: 300 * time.Millisecond}, 2)
+		c.init(t)
+		c.rpc(t, "n0", map[string]any{"type": "send", "key": "a", "msg": 0})
+		c.net.Drop(func(msg maelstrom.Message) bool {
+			var body struct {
+				Key string `json:"key"`
+				To  struct {
+					Status string `json:"status"`
+				} `json:"to"`
+			}
+			json.Unmarshal(msg.Body, &body)
+			return msg.Type() == "cas" && strings.HasPrefix(body.Key, "txns/") && body.To.Status == txnCommitted
+		})
+		errs := make(chan error, 1)
+		go func() {
+			_, err := c.call("n0", sendTxnRequest{Type: "send_txn", Msgs: map[string][]int{"a": {1, 2}, "b": {0}}})
+			errs <- err
+		}()
+
+		// Wait until the transaction has appended to a, and then append
+		// after it. Polls stop short of the pending records.
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+			var seg struct {
+				Value []any `json:"value"`
+			}
+			if err := json.Unmarshal(c.rpc(t, maelstrom.LinKV, map[string]any{"type": "read", "key": "segments/a/0"}), &seg); err != nil {
+				t.Fatal(err)
+			} else if len(seg.Value) == 3 {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatalf("timed out: segment=%v", seg.Value)
+			}
+		}
+		c.rpc(t, "n0", map[string]any{"type": "send", "key": "a", "msg": 3})
+		if got, want := offsetRange(c.poll(t, "n1", "a", firstOffset)), "1-1"; got != want {
+			t.Fatalf("offsets=%s, want %s", got, want)
+		}
+
+		// Once it expires, polls abort it and skip its records.
+		c.waitPoll(t, "n1", "a", firstOffset, "[1 4]")
+		if got := c.poll(t, "n1", "b", firstOffset); len(got) != 0 {
+			t.Fatalf("records=%v, want none", got)
+		}
+		if err := <-errs; err == nil {
+			t.Fatal("transaction committed after it was aborted")
+		}
+	})
+}
+
+func TestLongPoll(t *testing.T) {
+	for _, mode := range []string{"shared", "partitioned"} {
+		t.Run(mode, func(t *testing.T) {
+			// The log is appended to by, and in partitioned mode owned by,
+			// another node than the one polling it.
+			c := newCluster(t, options{mode: mode}, 2)
+			c.init(t)
+			key := "k"
+			for i := 0; c.srvs[0].owns(key); i++ {
+				key = fmt.Sprintf("k%d", i)
+			}
+
+			polled := make(chan string, 1)
+			go func() {
+				body, err := c.call("n0", pollRequest{Type: "poll", Offsets: map[string]int{key: 1}, Timeout: 5000})
+				var resp struct {
+					Msgs map[string][][2]int `json:"msgs"`
+				}
+				if err == nil {
+					err = json.Unmarshal(body, &resp)
+				}
+				polled <- fmt.Sprint(resp.Msgs[key], err)
+			}()
+			time.Sleep(200 * time.Millisecond)
+			c.rpc(t, "n1", map[string]any{"type": "send", "key": key, "msg": 7})
+
+			select {
+			case got := <-polled:
+				if want := "[[1 7]] <nil>"; got != want {
+					t.Fatalf("polled %s, want %s", got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("long poll didn't return after a send")
+			}
+		})
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		c := newCluster(t, options{}, 1)
+		c.init(t)
+		start := time.Now()
+		body := c.rpc(t, "n0", pollRequest{Type: "poll", Offsets: map[string]int{"k": 1}, Timeout: 200})
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("long poll returned after %s, want at least 200ms", elapsed)
+		}
+		if got, want := string(body), `"msgs":{}`; !strings.Contains(got, want) {
+			t.Fatalf("polled %s, want no records", got)
+		}
+	})
+
+	t.Run("NoOffsets", func(t *testing.T) {
+		// With no logs to wait on, a long poll is rejected rather than
+		// returning at once, but a plain poll still returns nothing.
+		c := newCluster(t, options{}, 1)
+		c.init(t)
+		for _, offsets := range []map[string]int{nil, {}} {
+			_, err := c.call("n0", pollRequest{Type: "poll", Offsets: offsets, Timeout: 1000})
+			if got, want := maelstrom.ErrorCode(err), maelstrom.MalformedRequest; got != want {
+				t.Fatalf("offsets=%v: error=%v, want code %d", offsets, err, want)
+			}
+		}
+		if got, want := string(c.rpc(t, "n0", pollRequest{Type: "poll"})), `"msgs":{}`; !strings.Contains(got, want) {
+			t.Fatalf("polled %s, want no records", got)
+		}
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	send := func(c *cluster, from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			c.rpc(t, "n0", map[string]any{"type": "send", "key": "k", "msg": i})
+		}
+	}
+
+	t.Run("Push", func(t *testing.T) {
+		c := newCluster(t, options{segmentSize: 10}, 2)
+		c.init(t)
+		id, batches := c.subscribe(t, "n1", map[string]int{"k": 1}, func(int) bool { return true })
+		send(c, 0, 25)
+
+		// Every record arrives, in order, though maybe in fewer batches than
+		// sends.
+		var offsets [][2]int
+		for len(offsets) < 25 {
+			select {
+			case batch := <-batches:
+				offsets = append(offsets, batch["k"]...)
+			case <-time.After(time.Second):
+				t.Fatalf("timed out after %d records", len(offsets))
+			}
+		}
+		if got, want := offsetRange(offsets), "1-25"; got != want {
+			t.Fatalf("offsets=%s, want %s", got, want)
+		}
+
+		c.rpc(t, "n1", unsubscribeRequest{Type: "unsubscribe", Subscription: id})
+		send(c, 25, 26)
+		select {
+		case batch := <-batches:
+			t.Fatalf("pushed %v after unsubscribing", batch)
+		case <-time.After(3 * recheckInterval):
+		}
+	})
+
+	t.Run("FlowControl", func(t *testing.T) {
+		// The subscriber doesn't acknowledge the first push, so it's sent
+		// again, and nothing newer is sent until it's acknowledged.
+		c := newCluster(t, options{}, 1)
+		c.init(t)
+		send(c, 0, 3)
+		_, batches := c.subscribe(t, "n0", map[string]int{"k": 1}, func(n int) bool { return n > 1 })
+		next := func() string {
+			t.Helper()
+			select {
+			case batch := <-batches:
+				return offsetRange(batch["k"])
+			case <-time.After(2 * timeout):
+				t.Fatal("timed out")
+				return ""
+			}
+		}
+		if got, want := next(), "1-3"; got != want {
+			t.Fatalf("first push: offsets=%s, want %s", got, want)
+		}
+		send(c, 3, 6)
+		for _, want := range []string{"1-3", "4-6"} {
+			if got := next(); got != want {
+				t.Fatalf("offsets=%s, want %s", got, want)
+			}
+		}
+	})
+
+	t.Run("NoOffsets", func(t *testing.T) {
+		// A subscription to no logs would never push anything.
+		c := newCluster(t, options{}, 1)
+		c.init(t)
+		for _, offsets := range []map[string]int{nil, {}} {
+			_, err := c.call("n0", subscribeRequest{Type: "subscribe", Offsets: offsets})
+			if got, want := maelstrom.ErrorCode(err), maelstrom.MalformedRequest; got != want {
+				t.Fatalf("offsets=%v: error=%v, want code %d", offsets, err, want)
+			}
+		}
+		c.srvs[0].mu.Lock()
+		defer c.srvs[0].mu.Unlock()
+		if got := len(c.srvs[0].subs); got != 0 {
+			t.Fatalf("%d subscriptions, want none", got)
+		}
+	})
+}
+
+// subscribe subscribes a new client to logs on node, and returns the
+// subscription's ID and the batches of records pushed to it. The client
+// acknowledges the n'th push, counting from 1, if ack(n) is true.
+func (c *cluster) subscribe(tb testing.TB, node string, offsets map[string]int, ack func(n int) bool) (string, <-chan map[string][][2]int) {
+	tb.Helper()
+	c.subscribers++
+	sub := c.net.AddClient(fmt.Sprintf("s%d", c.subscribers))
+	batches := make(chan map[string][][2]int, 100)
+	var pushes atomic.Int64
+	sub.Handle("records", func(msg maelstrom.Message) error {
+		var body struct {
+			Msgs map[string][][2]int `json:"msgs"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		batches <- body.Msgs
+		if !ack(int(pushes.Add(1))) {
+			return nil
+		}
+		return sub.Reply(msg, map[string]any{"type": "records_ok"})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := sub.SyncRPC(ctx, node, subscribeRequest{Type: "subscribe", Offsets: offsets})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var body subscribeResponse
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		tb.Fatal(err)
+	}
+	return body.Subscription, batches
+}
+
+func TestGroups(t *testing.T) {
+	t.Run("CommittedOffsets", func(t *testing.T) {
+		// Each group, and the base protocol without one, has its own offsets.
+		c := newCluster(t, options{}, 2)
+		c.init(t)
+		for group, offset := range map[string]int{"": 5, "a": 3, "b": 7} {
+			c.rpc(t, "n0", map[string]any{"type": "commit_offsets", "group": group, "offsets": map[string]int{"k": offset}})
+		}
+		for group, want := range map[string]int{"": 5, "a": 3, "b": 7, "c": 0} {
+			var resp struct {
+				Offsets map[string]int `json:"offsets"`
+			}
+			if err := json.Unmarshal(c.rpc(t, "n1", map[string]any{"type": "list_committed_offsets", "group": group, "keys": []string{"k"}}), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Offsets["k"]; got != want {
+				t.Fatalf("group %q: offset=%d, want %d", group, got, want)
+			}
+		}
+	})
+
+	t.Run("Assignment", func(t *testing.T) {
+		c := newCluster(t, options{}, 2)
+		c.init(t)
+		keys := []string{"k0", "k1", "k2", "k3", "k4"}
+		type groupResp struct {
+			Generation int      `json:"generation"`
+			Members    []string `json:"members"`
+			Keys       []string `json:"keys"`
+		}
+		group := func(node string, body map[string]any) groupResp {
+			t.Helper()
+			body["group"] = "g"
+			var resp groupResp
+			if err := json.Unmarshal(c.rpc(t, node, body), &resp); err != nil {
+				t.Fatal(err)
+			}
+			return resp
+		}
+		assignment := func(consumers ...string) string {
+			t.Helper()
+			var assigned []string
+			for i, consumer := range consumers {
+				resp := group(c.nodes[i%2], map[string]any{"type": "assign", "consumer": consumer, "keys": keys})
+				assigned = append(assigned, fmt.Sprintf("%s:%v", consumer, resp.Keys))
+			}
+			return fmt.Sprint(assigned)
+		}
+
+		group("n0", map[string]any{"type": "join_group", "consumer": "b"})
+		joined := group("n1", map[string]any{"type": "join_group", "consumer": "a"})
+		if got, want := fmt.Sprint(joined.Generation, joined.Members), "2 [a b]"; got != want {
+			t.Fatalf("joined=%s, want %s", got, want)
+		}
+		if got, want := assignment("a", "b"), "[a:[k0 k2 k4] b:[k1 k3]]"; got != want {
+			t.Fatalf("assignment=%s, want %s", got, want)
+		}
+
+		// Joining again changes nothing, but leaving reassigns everything.
+		if got := group("n0", map[string]any{"type": "join_group", "consumer": "a"}).Generation; got != 2 {
+			t.Fatalf("generation=%d after rejoining, want 2", got)
+		}
+		left := group("n0", map[string]any{"type": "leave_group", "consumer": "b"})
+		if got, want := fmt.Sprint(left.Generation, left.Members), "3 [a]"; got != want {
+			t.Fatalf("left=%s, want %s", got, want)
+		}
+		if got, want := assignment("a"), "[a:[k0 k1 k2 k3 k4]]"; got != want {
+			t.Fatalf("assignment=%s, want %s", got, want)
+		}
+		if _, err := c.call("n1", map[string]any{"type": "assign", "group": "g", "consumer": "b", "keys": keys}); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			t.Fatalf("assign to former member: err=%v, want PreconditionFailed", err)
+		}
+
+		// A commit from an earlier generation is fenced off.
+		commit := func(generation int) error {
+			_, err := c.call("n0", map[string]any{"type": "commit_offsets", "group": "g", "generation": generation, "offsets": map[string]int{"k1": 1}})
+			return err
+		}
+		if err := commit(2); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			t.Fatalf("stale commit: err=%v, want PreconditionFailed", err)
+		}
+		if err := commit(3); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Fenced", func(t *testing.T) {
+		// Commits from generation 1 race with a join. Whichever way each one
+		// goes, none may land once the join has returned.
+		c := newCluster(t, options{}, 2)
+		c.net.Latency = 2 * time.Millisecond
+		c.init(t)
+		c.rpc(t, "n0", map[string]any{"type": "join_group", "group": "g", "consumer": "a"})
+		committed := func() int {
+			t.Helper()
+			var resp listCommittedOffsetsResponse
+			if err := json.Unmarshal(c.rpc(t, "n0", listCommittedOffsetsRequest{Type: "list_committed_offsets", Group: "g", Keys: []string{"k"}}), &resp); err != nil {
+				t.Fatal(err)
+			}
+			return resp.Offsets["k"]
+		}
+
+		var next atomic.Int64
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		for _, id := range c.nodes {
+			go func(id string) {
+				defer func() { done <- struct{}{} }()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					c.call(id, commitOffsetsRequest{Type: "commit_offsets", Group: "g", Generation: 1, Offsets: map[string]int{"k": int(next.Add(1))}})
+				}
+			}(id)
+		}
+		time.Sleep(50 * time.Millisecond)
+		c.rpc(t, "n1", map[string]any{"type": "join_group", "group": "g", "consumer": "b"})
+		before := committed()
+		close(stop)
+		for range c.nodes {
+			<-done
+		}
+		if got := committed(); got != before {
+			t.Fatalf("offset=%d after the join, then %d", before, got)
+		}
+	})
+}
+
+func TestRetention(t *testing.T) {
+	send := func(c *cluster, from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			c.rpc(t, "n0", map[string]any{"type": "send", "key": "k", "msg": i})
+		}
+	}
+
+	t.Run("Records", func(t *testing.T) {
+		// Starting segment 4 at offset 41 allows offsets 1-26 to be deleted,
+		// which covers segments 0 and 1.
+		c := newCluster(t, options{segmentSize: 10, retainRecords: 15}, 1)
+		c.init(t)
+		send(c, 0, 41)
+		c.waitPoll(t, "n0", "k", 0, "[21-30]")
+		if got, want := string(c.rpc(t, maelstrom.LinKV, map[string]any{"type": "read", "key": "segments/k/1"})), `"truncated"`; !strings.Contains(got, want) {
+			t.Fatalf("read segment 1: %s, want %s", got, want)
+		}
+
+		// Sends carry on at the end of the log.
+		send(c, 41, 45)
+		if got, want := offsetRange(c.poll(t, "n0", "k", 36)), "36-45"; got != want {
+			t.Fatalf("offsets=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Committed", func(t *testing.T) {
+		c := newCluster(t, options{segmentSize: 10, retainCommitted: true}, 2)
+		c.init(t)
+		commit := func(group string, offset int) {
+			t.Helper()
+			c.rpc(t, "n1", map[string]any{"type": "commit_offsets", "group": group, "offsets": map[string]int{"k": offset}})
+		}
+		commit("", 33)
+		commit("a", 25)
+		send(c, 0, 41)
+		c.waitPoll(t, "n0", "k", 0, "[21-30]")
+
+		commit("a", 40)
+		send(c, 41, 51)
+		c.waitPoll(t, "n0", "k", 0, "[31-40]")
+	})
+
+	t.Run("Groups", func(t *testing.T) {
+		// g2 registers by committing another log, and g3 by joining, so
+		// neither lets g1's commit delete anything from k until they commit
+		// k themselves.
+		c := newCluster(t, options{segmentSize: 10, retainCommitted: true}, 2)
+		c.init(t)
+		commit := func(group, key string, offset int) {
+			t.Helper()
+			c.rpc(t, "n1", map[string]any{"type": "commit_offsets", "group": group, "offsets": map[string]int{key: offset}})
+		}
+		commit("g2", "j", 5)
+		c.rpc(t, "n1", map[string]any{"type": "join_group", "group": "g3", "consumer": "a"})
+		commit("g1", "k", 40)
+		send(c, 0, 41)
+		c.srvs[0].settle(t, "k", 4)
+		if got, want := offsetRange(c.poll(t, "n0", "k", 0)), "1-10"; got != want {
+			t.Fatalf("offsets=%s, want %s", got, want)
+		}
+
+		commit("g2", "k", 25)
+		c.srvs[0].settle(t, "k", 4)
+		if got, want := offsetRange(c.poll(t, "n0", "k", 0)), "1-10"; got != want {
+			t.Fatalf("offsets=%s with g3 not committed, want %s", got, want)
+		}
+
+		commit("g3", "k", 33)
+		send(c, 41, 51)
+		c.waitPoll(t, "n0", "k", 0, "[21-30]")
+	})
+
+	t.Run("Mixed", func(t *testing.T) {
+		// Consumers without a group hold on to k from their first commit,
+		// even of another log, and a commit of offset 0 holds all of it.
+		c := newCluster(t, options{segmentSize: 10, retainCommitted: true}, 2)
+		c.init(t)
+		commit := func(group, key string, offset int) {
+			t.Helper()
+			c.rpc(t, "n1", map[string]any{"type": "commit_offsets", "group": group, "offsets": map[string]int{key: offset}})
+		}
+		check := func(want string) {
+			t.Helper()
+			c.srvs[0].settle(t, "k", 4)
+			if got := offsetRange(c.poll(t, "n0", "k", 0)); got != want {
+				t.Fatalf("offsets=%s, want %s", got, want)
+			}
+		}
+		commit("", "j", 5)
+		commit("g", "k", 25)
+		send(c, 0, 41)
+		check("1-10")
+		commit("", "k", 0)
+		check("1-10")
+		commit("", "k", 33)
+		check("21-30")
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		// Every fifth record has no subkey, and the rest cycle through
+		// three, so starting segment 2 at offset 21 leaves the latest record
+		// with each subkey at offsets 17, 19 and 21.
+		c := newCluster(t, options{segmentSize: 10, compact: true}, 1)
+		c.init(t)
+		for i := 0; i < 21; i++ {
+			body := map[string]any{"type": "send", "key": "k", "msg": i}
+			if i%5 != 4 {
+				body["subkey"] = fmt.Sprintf("s%d", i%3)
+			}
+			c.rpc(t, "n0", body)
+		}
+		c.waitPoll(t, "n0", "k", 0, "[5 10 15 17 19 20 21]")
+	})
+}
+
+// settle runs retention for a log up to segment tail, and waits for any run
+// already in progress to finish too.
+func (s *srv) settle(tb testing.TB, key string, tail int) {
+	tb.Helper()
+	s.maintain(key, tail)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		s.mu.Lock()
+		_, running := s.maintaining[key]
+		s.mu.Unlock()
+		if !running {
+			return
+		}
+	}
+	tb.Fatalf("timed out: retention of %s still running", key)
+}
+
+// waitPoll waits up to five seconds until polling a log on node returns
+// records with the given offsets, formatted as by offsets.
+func (c *cluster) waitPoll(tb testing.TB, node, key string, offset int, want string) {
+	tb.Helper()
+	var got string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if got = offsets(c.poll(tb, node, key, offset)); got == want {
+			return
+		}
+	}
+	tb.Fatalf("timed out: offsets=%s, want %s", got, want)
+}
+
+// offsets returns the offsets of records, as a range "[first-last]" if they
+// are consecutive, or else as a list.
+func offsets(records [][2]int) string {
+	if r := offsetRange(records); !strings.HasPrefix(r, "nonconsecutive") && r != "none" {
+		return "[" + r + "]"
+	}
+	var list []int
+	for _, r := range records {
+		list = append(list, r[0])
+	}
+	return fmt.Sprint(list)
+}
+
+// offsetRange returns the offsets of consecutive records as "first-last", or
+// "none" if there are none.
+func offsetRange(records [][2]int) string {
+	if len(records) == 0 {
+		return "none"
+	}
+	for i, r := range records {
+		if r[0] != records[0][0]+i {
+			return fmt.Sprintf("nonconsecutive %v", records)
+		}
+	}
+	return fmt.Sprintf("%d-%d", records[0][0], records[len(records)-1][0])
+}
+
+// checkDense checks that the offsets of each log observed by sends and polls
+// have no holes.
+func checkDense(tb testing.TB, h checker.History) {
+	tb.Helper()
+	for key, offsets := range observedOffsets(h) {
+		for offset := firstOffset; offset < firstOffset+len(offsets); offset++ {
+			if !offsets[offset] {
+				tb.Fatalf("log %s: %d offsets observed, but not %d", key, len(offsets), offset)
+			}
+		}
+	}
+}
+
+// observedOffsets returns the offsets of each log returned by successful
+// sends and polls.
+func observedOffsets(h checker.History) map[string]map[int]bool {
+	offsets := make(map[string]map[int]bool)
+	observe := func(key string, offset int) {
+		if offsets[key] == nil {
+			offsets[key] = make(map[int]bool)
+		}
+		offsets[key][offset] = true
+	}
+	for _, op := range h {
+		if op.Type != checker.OK {
+			continue
+		}
+		switch op.F {
+		case "send":
+			observe(op.Key.(string), op.Value.([]any)[0].(int))
+		case "poll":
+			for key, records := range op.Value.(map[string]any) {
+				for _, r := range records.([]any) {
+					observe(key, int(r.([]any)[0].(float64)))
+				}
+			}
+		}
+	}
+	return offsets
+}
+
+// cluster is a set of servers on a simulated network with a lin-kv service.
+type cluster struct {
+	net    *sim.Network
+	nodes  []string
+	srvs   []*srv
+	client *maelstrom.Node // for rpc
+
+	subscribers int // clients added by subscribe
+}
+
+// newCluster returns a cluster of servers with the given options.
+func newCluster(tb testing.TB, opts options, nodes int) *cluster {
+	tb.Helper()
+
+	c := &cluster{net: sim.NewNetwork()}
+	tb.Cleanup(func() { c.net.Close() })
+	c.net.AddService(maelstrom.LinKV, sim.NewKV(maelstrom.LinKV).Node())
+	for i := 0; i < nodes; i++ {
+		id := fmt.Sprintf("n%d", i)
+		n := maelstrom.NewNode()
+		c.srvs = append(c.srvs, newSrv(n, opts))
+		c.net.AddNode(id, n)
+		c.nodes = append(c.nodes, id)
+	}
+	return c
+}
+
+// init initializes every node, for tests which don't run a workload.
+func (c *cluster) init(tb testing.TB) {
+	tb.Helper()
+	for _, id := range c.nodes {
+		c.rpc(tb, id, maelstrom.InitMessageBody{
+			MessageBody: maelstrom.MessageBody{Type: "init"},
+			NodeID:      id,
+			NodeIDs:     c.nodes,
+		})
+	}
+}
+
+// rpc sends a request to dest from a client, and returns the body of its
+// reply.
+func (c *cluster) rpc(tb testing.TB, dest string, body any) json.RawMessage {
+	tb.Helper()
+	resp, err := c.call(dest, body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return resp
+}
+
+// call is like rpc, but returns an error reply rather than failing.
+func (c *cluster) call(dest string, body any) (json.RawMessage, error) {
+	if c.client == nil {
+		c.client = c.net.AddClient("c0")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := c.client.SyncRPC(ctx, dest, body)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// sendBatch sends a batch of messages to logs through node.
+func (c *cluster) sendBatch(tb testing.TB, node string, msgs map[string][]int) sendBatchResponse {
+	tb.Helper()
+	var resp sendBatchResponse
+	if err := json.Unmarshal(c.rpc(tb, node, sendBatchRequest{Type: "send_batch", Msgs: msgs}), &resp); err != nil {
+		tb.Fatal(err)
+	}
+	return resp
+}
+
+// poll polls a single log on node, and returns its records.
+func (c *cluster) poll(tb testing.TB, node, key string, offset int) [][2]int {
+	tb.Helper()
+	var resp struct {
+		Msgs map[string][][2]int `json:"msgs"`
+	}
+	if err := json.Unmarshal(c.rpc(tb, node, map[string]any{"type": "poll", "offsets": map[string]int{key: offset}}), &resp); err != nil {
+		tb.Fatal(err)
+	}
+	return resp.Msgs[key]
+}
+
+// run runs the kafka workload against a cluster.
+func run(tb testing.TB, c *cluster, cfg workload.Config) workload.Results {
+	tb.Helper()
+
+	cfg.Nodes = c.nodes
+	w, _ := workload.New("kafka")
+	res, err := workload.Run(context.Background(), c.net, w, cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}
+
+// BenchmarkKafka runs the kafka workloads from the justfile, for a shorter
+// time, and reports the number of lin-kv operations and of conflicting
+// appends for each operation.
+func BenchmarkKafka(b *testing.B) {
+	for _, mode := range []string{"shared", "partitioned"} {
+		for _, nodes := range []int{1, 2} {
+			b.Run(fmt.Sprintf("%s/%d-nodes", mode, nodes), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					c := newCluster(b, options{mode: mode}, nodes)
+					res := run(b, c, workload.Config{
+						Concurrency: 2 * nodes,
+						Rate:        1000,
+						TimeLimit:   5 * time.Second,
+					})
+					if res.Valid != checker.Valid {
+						b.Fatalf("unexpected results:\n%s", res)
+					}
+					var conflicts int64
+					for _, s := range c.srvs {
+						conflicts += s.conflicts.Load()
+					}
+					// Each lin-kv operation is a request and a reply.
+					b.ReportMetric(float64(res.Net.Services)/2/float64(res.Stats.Count), "kv-ops/op")
+					b.ReportMetric(float64(conflicts)/float64(res.Stats.Count), "conflicts/op")
+					b.ReportMetric(float64(res.Stats.Count)/5, "ops/s")
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkOps reports the number of lin-kv operations for each send to a
+// log, and for each poll of the last batch of records in it, which are the
+// ones a caught-up consumer wants.
+func BenchmarkOps(b *testing.B) {
+	kvOps := func(c *cluster) int { return c.net.Stats().Services / 2 }
+
+	b.Run("send", func(b *testing.B) {
+		c := newCluster(b, options{}, 1)
+		c.init(b)
+		start := kvOps(c)
+		for i := 0; i < b.N; i++ {
+			c.rpc(b, "n0", map[string]any{"type": "send", "key": "k", "msg": i})
+		}
+		b.ReportMetric(float64(kvOps(c)-start)/float64(b.N), "kv-ops/op")
+	})
+
+	b.Run("send_batch", func(b *testing.B) {
+		// Reports lin-kv operations per message, in batches of ten.
+		c := newCluster(b, options{}, 1)
+		c.init(b)
+		start := kvOps(c)
+		for i := 0; i < b.N; i++ {
+			c.sendBatch(b, "n0", map[string][]int{"k": seq(0, 10)})
+		}
+		b.ReportMetric(float64(kvOps(c)-start)/float64(10*b.N), "kv-ops/msg")
+	})
+
+	b.Run("poll", func(b *testing.B) {
+		c := newCluster(b, options{}, 1)
+		c.init(b)
+		for i := 0; i < 995; i++ {
+			c.rpc(b, "n0", map[string]any{"type": "send", "key": "k", "msg": i})
+		}
+		start := kvOps(c)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.poll(b, "n0", "k", 986)
+		}
+		b.ReportMetric(float64(kvOps(c)-start)/float64(b.N), "kv-ops/op")
+	})
+}
@@ -0,0 +1,263 @@
+// Package checker verifies histories of operations recorded against a
+// Maelstrom system, in the same spirit as the checkers that Maelstrom itself
+// runs at the end of a test.
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Operation types. Every operation in a history is first recorded as an
+// Invoke, and later completed by an OK, Fail, or Info operation from the same
+// process.
+const (
+	Invoke = "invoke"
+	OK     = "ok"
+	Fail   = "fail"
+	Info   = "info"
+)
+
+// Op represents a single entry in a history.
+type Op struct {
+	// Process is the logical client that performed the operation. A process
+	// performs at most one operation at a time.
+	Process int `json:"process"`
+
+	// Type is one of Invoke, OK, Fail or Info.
+	Type string `json:"type"`
+
+	// F is the function being performed, e.g. "read" or "cas".
+	F string `json:"f"`
+
+	// Key is the key the operation applies to, if any.
+	Key any `json:"key,omitempty"`
+
+	// Value is the argument or result of the operation. Its shape depends on
+	// F; see the individual checkers for details.
+	Value any `json:"value"`
+
+	// Time is the time the entry was recorded, in nanoseconds since the start
+	// of the test. Optional; histories are ordered by index, not time.
+	Time int64 `json:"time,omitempty"`
+
+	// Error is a description of the failure for Fail and Info operations.
+	Error string `json:"error,omitempty"`
+
+	// Final is true for operations performed after the main phase of a test,
+	// once the system has had a chance to converge, e.g. final reads.
+	Final bool `json:"final,omitempty"`
+}
+
+// String returns a condensed, human-readable representation of op, similar to
+// the lines in Maelstrom's history.txt.
+func (op Op) String() string {
+	s := fmt.Sprintf("%d\t:%s\t:%s\t", op.Process, op.Type, op.F)
+	if op.Key != nil {
+		s += fmt.Sprintf("%v ", op.Key)
+	}
+	s += fmt.Sprintf("%v", op.Value)
+	if op.Error != "" {
+		s += "\t" + op.Error
+	}
+	return s
+}
+
+// Validity is a checker's verdict on a history.
+type Validity string
+
+// Validity values. A history is Unknown when the checker cannot decide, e.g.
+// because it contains no reads.
+const (
+	Valid   Validity = "true"
+	Invalid Validity = "false"
+	Unknown Validity = "unknown"
+)
+
+// Merge combines two verdicts: any Invalid makes the result Invalid, and
+// otherwise any Unknown makes it Unknown.
+func (v Validity) Merge(other Validity) Validity {
+	switch {
+	case v == Invalid || other == Invalid:
+		return Invalid
+	case v == Unknown || other == Unknown:
+		return Unknown
+	default:
+		return Valid
+	}
+}
+
+// Result is the outcome of checking a workload's history.
+type Result struct {
+	Valid Validity
+
+	// Errors describes each anomaly found in the history.
+	Errors []string
+
+	// Details holds checker-specific statistics, such as counts of lost or
+	// duplicated elements.
+	Details map[string]any
+}
+
+// newResult returns a valid result with no details.
+func newResult() Result {
+	return Result{Valid: Valid, Details: make(map[string]any)}
+}
+
+// errorf records an anomaly and marks the result invalid.
+func (r *Result) errorf(format string, args ...any) {
+	r.Valid = Invalid
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// History is a sequence of operations, in the order they were observed.
+type History []Op
+
+// Pair is an invocation together with its completion.
+type Pair struct {
+	// Index of the invocation in the history.
+	Index int
+
+	Invoke Op
+
+	// Complete is the OK, Fail, or Info operation that completed Invoke. If
+	// the invocation never completed, this is a synthesized Info operation.
+	Complete Op
+
+	// CompleteIndex is the index of the completion in the history, or
+	// len(history) if the invocation never completed.
+	CompleteIndex int
+}
+
+// Pairs matches every invocation in h with its completion. Invocations that
+// never complete are treated as indeterminate.
+func (h History) Pairs() ([]Pair, error) {
+	var pairs []Pair
+	pending := make(map[int]int) // process -> index into pairs
+	for i, op := range h {
+		switch op.Type {
+		case Invoke:
+			if _, ok := pending[op.Process]; ok {
+				return nil, fmt.Errorf("process %d invoked op %d while another was in flight", op.Process, i)
+			}
+			pending[op.Process] = len(pairs)
+			pairs = append(pairs, Pair{Index: i, Invoke: op, CompleteIndex: -1})
+		case OK, Fail, Info:
+			j, ok := pending[op.Process]
+			if !ok {
+				return nil, fmt.Errorf("process %d completed op %d without invoking it", op.Process, i)
+			}
+			delete(pending, op.Process)
+			pairs[j].Complete = op
+			pairs[j].CompleteIndex = i
+		default:
+			return nil, fmt.Errorf("unknown op type %q at %d", op.Type, i)
+		}
+	}
+
+	for _, j := range pending {
+		pairs[j].Complete = pairs[j].Invoke
+		pairs[j].Complete.Type = Info
+		pairs[j].CompleteIndex = len(h)
+	}
+	return pairs, nil
+}
+
+// timed reports whether the operations in h have times.
+func timed(h History) bool {
+	for _, op := range h {
+		if op.Time != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Quantiles summarizes a distribution of durations, in milliseconds, by its
+// values at the quantiles 0 (the minimum), 0.5 (the median), 0.95, 0.99 and 1
+// (the maximum), as in Maelstrom's results.
+type Quantiles map[float64]int64
+
+// quantilePoints are the quantiles reported in Quantiles.
+var quantilePoints = []float64{0, 0.5, 0.95, 0.99, 1}
+
+// newQuantiles summarizes values. It returns nil if there are none.
+func newQuantiles(values []int64) Quantiles {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	q := make(Quantiles, len(quantilePoints))
+	for _, p := range quantilePoints {
+		q[p] = sorted[int(p*float64(len(sorted)-1))]
+	}
+	return q
+}
+
+// String formats q like Maelstrom, e.g. {0 0, 0.5 86, 0.95 170, 0.99 193, 1 224}.
+func (q Quantiles) String() string {
+	var parts []string
+	for _, p := range quantilePoints {
+		if v, ok := q[p]; ok {
+			parts = append(parts, fmt.Sprintf("%v %d", p, v))
+		}
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// equal compares two values by their JSON representation. Values decoded from
+// JSON are float64 while values constructed in Go are usually int, so direct
+// comparison is not useful.
+func equal(a, b any) bool {
+	return jsonString(a) == jsonString(b)
+}
+
+// toInt converts a numeric value, as constructed in Go or decoded from JSON,
+// to an int.
+func toInt(v any) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err == nil
+	default:
+		return 0, false
+	}
+}
+
+// toSlice converts a JSON array, as constructed in Go or decoded from JSON, to
+// a []any.
+func toSlice(v any) ([]any, bool) {
+	if s, ok := v.([]any); ok {
+		return s, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	s := make([]any, rv.Len())
+	for i := range s {
+		s[i] = rv.Index(i).Interface()
+	}
+	return s, true
+}
+
+// jsonString returns the JSON encoding of v, or its Go representation if it
+// cannot be encoded.
+func jsonString(v any) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%#v", v)
+	}
+	return string(buf)
+}
@@ -0,0 +1,282 @@
+package checker_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+func TestSet(t *testing.T) {
+	h := checker.History{
+		{Process: 0, Type: checker.Invoke, F: "broadcast", Value: 1},
+		{Process: 0, Type: checker.OK, F: "broadcast", Value: 1},
+		{Process: 0, Type: checker.Invoke, F: "broadcast", Value: 2},
+		{Process: 0, Type: checker.OK, F: "broadcast", Value: 2},
+		{Process: 1, Type: checker.Invoke, F: "broadcast", Value: 3},
+		{Process: 1, Type: checker.Info, F: "broadcast", Value: 3},
+	}
+
+	t.Run("Unknown", func(t *testing.T) {
+		if res := checker.Set(h, "broadcast"); res.Valid != checker.Unknown {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+	})
+
+	t.Run("OK", func(t *testing.T) {
+		h := append(h,
+			checker.Op{Process: 2, Type: checker.Invoke, F: "read", Final: true},
+			checker.Op{Process: 2, Type: checker.OK, F: "read", Value: []any{2.0, 1.0}, Final: true},
+		)
+		if res := checker.Set(h, "broadcast"); res.Valid != checker.Valid {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+	})
+
+	t.Run("Lost", func(t *testing.T) {
+		h := append(h,
+			checker.Op{Process: 2, Type: checker.Invoke, F: "read", Final: true},
+			checker.Op{Process: 2, Type: checker.OK, F: "read", Value: []any{1, 3, 4}, Final: true},
+		)
+		res := checker.Set(h, "broadcast")
+		if res.Valid != checker.Invalid {
+			t.Fatalf("unexpected result: %+v", res)
+		} else if got, want := res.Details["lost-count"], 1; got != want {
+			t.Fatalf("lost=%v, want %v", got, want)
+		} else if got, want := res.Details["unexpected-count"], 1; got != want {
+			t.Fatalf("unexpected=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		ms := int64(time.Millisecond)
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "broadcast", Value: 1, Time: 10 * ms},
+			{Process: 0, Type: checker.OK, F: "broadcast", Value: 1, Time: 20 * ms},
+			{Process: 0, Type: checker.Invoke, F: "broadcast", Value: 2, Time: 30 * ms},
+			{Process: 0, Type: checker.OK, F: "broadcast", Value: 2, Time: 40 * ms},
+			// 2 is missing while its broadcast is in flight, but not stale.
+			{Process: 1, Type: checker.Invoke, F: "read", Time: 35 * ms},
+			{Process: 1, Type: checker.OK, F: "read", Value: []any{1}, Time: 45 * ms},
+			// 1 is stale until this read.
+			{Process: 1, Type: checker.Invoke, F: "read", Time: 60 * ms},
+			{Process: 1, Type: checker.OK, F: "read", Value: []any{2}, Time: 70 * ms},
+			{Process: 1, Type: checker.Invoke, F: "read", Time: 80 * ms, Final: true},
+			{Process: 1, Type: checker.OK, F: "read", Value: []any{1, 2}, Time: 90 * ms, Final: true},
+		}
+		res := checker.Set(h, "broadcast")
+		if res.Valid != checker.Valid {
+			t.Fatalf("unexpected result: %+v", res)
+		} else if got, want := res.Details["stale-count"], 1; got != want {
+			t.Fatalf("stale=%v, want %v", got, want)
+		} else if got, want := fmt.Sprint(res.Details["stable-latencies"]), "{0 5, 0.5 5, 0.95 5, 0.99 5, 1 50}"; got != want {
+			t.Fatalf("latencies=%v, want %v", got, want)
+		}
+	})
+}
+
+func TestCounter(t *testing.T) {
+	h := checker.History{
+		{Process: 0, Type: checker.Invoke, F: "add", Value: 5},
+		{Process: 0, Type: checker.OK, F: "add", Value: 5},
+		{Process: 1, Type: checker.Invoke, F: "add", Value: -2},
+		{Process: 1, Type: checker.Info, F: "add", Value: -2},
+		{Process: 2, Type: checker.Invoke, F: "add", Value: 4},
+		{Process: 2, Type: checker.Info, F: "add", Value: 4},
+	}
+	for _, tt := range []struct {
+		read  int
+		valid checker.Validity
+	}{
+		{2, checker.Invalid},
+		{3, checker.Valid},
+		{9, checker.Valid},
+		{10, checker.Invalid},
+	} {
+		h := append(h,
+			checker.Op{Process: 3, Type: checker.Invoke, F: "read", Final: true},
+			checker.Op{Process: 3, Type: checker.OK, F: "read", Value: tt.read, Final: true},
+		)
+		if res := checker.Counter(h); res.Valid != tt.valid {
+			t.Fatalf("read %d: unexpected result: %+v", tt.read, res)
+		}
+	}
+}
+
+func TestUniqueIDs(t *testing.T) {
+	h := checker.History{
+		{Process: 0, Type: checker.Invoke, F: "generate"},
+		{Process: 0, Type: checker.OK, F: "generate", Value: "a"},
+		{Process: 1, Type: checker.Invoke, F: "generate"},
+		{Process: 1, Type: checker.OK, F: "generate", Value: 1},
+	}
+	if res := checker.UniqueIDs(h); res.Valid != checker.Valid {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	h = append(h,
+		checker.Op{Process: 0, Type: checker.Invoke, F: "generate"},
+		checker.Op{Process: 0, Type: checker.OK, F: "generate", Value: 1.0},
+	)
+	if res := checker.UniqueIDs(h); res.Valid != checker.Invalid {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestKafka(t *testing.T) {
+	send := func(p int, key string, msg, offset int) checker.History {
+		return checker.History{
+			{Process: p, Type: checker.Invoke, F: "send", Key: key, Value: msg},
+			{Process: p, Type: checker.OK, F: "send", Key: key, Value: []any{offset, msg}},
+		}
+	}
+	poll := func(p int, from map[string]int, msgs map[string]any) checker.History {
+		return checker.History{
+			{Process: p, Type: checker.Invoke, F: "poll", Value: from},
+			{Process: p, Type: checker.OK, F: "poll", Value: msgs},
+		}
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		var h checker.History
+		h = append(h, send(0, "a", 10, 1)...)
+		h = append(h, send(0, "a", 11, 2)...)
+		h = append(h, poll(1, map[string]int{"a": 0}, map[string]any{"a": []any{[]any{1, 10}, []any{2, 11}}})...)
+		if res := checker.Kafka(h); res.Valid != checker.Valid {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+	})
+
+	t.Run("Unobserved", func(t *testing.T) {
+		var h checker.History
+		h = append(h, send(0, "a", 10, 1)...)
+		h = append(h, poll(1, map[string]int{"a": 0}, map[string]any{})...)
+		h = append(h, send(0, "a", 11, 5)...)
+		res := checker.Kafka(h)
+		if res.Valid != checker.Valid {
+			t.Fatalf("unexpected result: %+v", res)
+		} else if got, want := res.Details["unobserved-count"], 2; got != want {
+			t.Fatalf("unobserved=%v, want %v", got, want)
+		}
+	})
+
+	for _, tt := range []struct {
+		name    string
+		anomaly string
+		h       []checker.History
+	}{
+		{"InconsistentOffsets", "inconsistent-offset", []checker.History{
+			send(0, "a", 10, 1),
+			send(0, "a", 11, 1),
+			poll(1, map[string]int{"a": 0}, map[string]any{}),
+		}},
+		{"Duplicate", "duplicate", []checker.History{
+			send(0, "a", 10, 1),
+			poll(1, map[string]int{"a": 0}, map[string]any{"a": []any{[]any{1, 10}, []any{2, 10}}}),
+		}},
+		{"InternalNonmonotonic", "internal-nonmonotonic", []checker.History{
+			send(0, "a", 10, 1),
+			send(0, "a", 11, 2),
+			poll(1, map[string]int{"a": 0}, map[string]any{"a": []any{[]any{2, 11}, []any{1, 10}}}),
+		}},
+		{"PollBeforeRequested", "internal-nonmonotonic", []checker.History{
+			send(0, "a", 10, 1),
+			poll(1, map[string]int{"a": 2}, map[string]any{"a": []any{[]any{1, 10}}}),
+		}},
+		{"Skip", "skip", []checker.History{
+			send(0, "a", 10, 1),
+			send(0, "a", 11, 2),
+			send(0, "a", 12, 3),
+			poll(1, map[string]int{"a": 0}, map[string]any{"a": []any{[]any{1, 10}, []any{3, 12}}}),
+			poll(1, map[string]int{"a": 2}, map[string]any{"a": []any{[]any{2, 11}}}),
+		}},
+		{"Lost", "lost", []checker.History{
+			send(0, "a", 10, 1),
+			send(0, "a", 11, 2),
+			poll(1, map[string]int{"a": 2}, map[string]any{"a": []any{[]any{2, 11}}}),
+		}},
+		{"NonmonotonicSend", "nonmonotonic-send", []checker.History{
+			send(0, "a", 10, 5),
+			send(1, "a", 11, 3),
+			poll(1, map[string]int{"a": 0}, map[string]any{"a": []any{[]any{3, 11}, []any{5, 10}}}),
+		}},
+		{"CommittedBackwards", "committed-backwards", []checker.History{
+			send(0, "a", 10, 1),
+			poll(1, map[string]int{"a": 0}, map[string]any{"a": []any{[]any{1, 10}}}),
+			{
+				{Process: 1, Type: checker.Invoke, F: "commit_offsets", Value: map[string]int{"a": 1}},
+				{Process: 1, Type: checker.OK, F: "commit_offsets", Value: map[string]int{"a": 1}},
+				{Process: 2, Type: checker.Invoke, F: "list_committed_offsets", Value: []any{"a"}},
+				{Process: 2, Type: checker.OK, F: "list_committed_offsets", Value: map[string]any{"a": 0.0}},
+			},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var h checker.History
+			for _, ops := range tt.h {
+				h = append(h, ops...)
+			}
+			res := checker.Kafka(h)
+			if res.Valid != checker.Invalid {
+				t.Fatalf("unexpected result: %+v", res)
+			} else if got := res.Details[tt.anomaly+"-count"]; got == 0 {
+				t.Fatalf("expected %s anomaly: %+v", tt.anomaly, res)
+			}
+		})
+	}
+}
+
+func TestTxnRWRegister(t *testing.T) {
+	txn := func(p int, typ string, mops ...any) checker.History {
+		return checker.History{
+			{Process: p, Type: checker.Invoke, F: "txn", Value: mops},
+			{Process: p, Type: typ, F: "txn", Value: mops},
+		}
+	}
+
+	var h checker.History
+	h = append(h, txn(0, checker.OK, []any{"w", 1, 10}, []any{"r", 1, 10})...)
+	h = append(h, txn(1, checker.Fail, []any{"w", 1, 11})...)
+	h = append(h, txn(2, checker.OK, []any{"r", 1, 10}, []any{"r", 2, nil})...)
+	if res := checker.TxnRWRegister(h); res.Valid != checker.Valid {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	for name, bad := range map[string]checker.History{
+		"Garbage":  txn(3, checker.OK, []any{"r", 1, 12}),
+		"Aborted":  txn(3, checker.OK, []any{"r", 1, 11}),
+		"Internal": txn(3, checker.OK, []any{"r", 1, 10}, []any{"r", 1, nil}),
+	} {
+		if res := checker.TxnRWRegister(append(h, bad...)); res.Valid != checker.Invalid {
+			t.Fatalf("%s: unexpected result: %+v", name, res)
+		}
+	}
+}
+
+func TestTxnListAppend(t *testing.T) {
+	txn := func(p int, mops ...any) checker.History {
+		return checker.History{
+			{Process: p, Type: checker.Invoke, F: "txn", Value: mops},
+			{Process: p, Type: checker.OK, F: "txn", Value: mops},
+		}
+	}
+
+	var h checker.History
+	h = append(h, txn(0, []any{"append", 1, 1}, []any{"r", 1, []any{1}})...)
+	h = append(h, txn(1, []any{"append", 1, 2})...)
+	h = append(h, txn(2, []any{"r", 1, []any{1, 2}}, []any{"r", 2, nil})...)
+	if res := checker.TxnListAppend(h); res.Valid != checker.Valid {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	for name, bad := range map[string]checker.History{
+		"IncompatibleOrder": txn(3, []any{"r", 1, []any{2, 1}}),
+		"Duplicate":         txn(3, []any{"r", 1, []any{1, 1}}),
+		"Internal":          txn(3, []any{"append", 2, 3}, []any{"r", 2, nil}),
+	} {
+		if res := checker.TxnListAppend(append(h, bad...)); res.Valid != checker.Invalid {
+			t.Fatalf("%s: unexpected result: %+v", name, res)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package checker
+
+// Counter checks a history of the g-counter or pn-counter workloads. "add"
+// operations carry their delta as Value, and "read" completions carry the
+// counter value observed.
+//
+// Every final read must fall between the sum of acknowledged deltas plus any
+// indeterminate decrements, and the sum of acknowledged deltas plus any
+// indeterminate increments. The result is Unknown if there are no final reads.
+func Counter(h History) Result {
+	res := newResult()
+
+	pairs, err := h.Pairs()
+	if err != nil {
+		res.errorf("malformed history: %s", err)
+		return res
+	}
+
+	lower, upper := 0, 0
+	for _, p := range pairs {
+		if p.Invoke.F != "add" {
+			continue
+		}
+		delta, ok := toInt(p.Invoke.Value)
+		if !ok {
+			res.errorf("add of non-integer delta %v", p.Invoke.Value)
+			continue
+		}
+
+		switch p.Complete.Type {
+		case OK:
+			lower += delta
+			upper += delta
+		case Info:
+			if delta < 0 {
+				lower += delta
+			} else {
+				upper += delta
+			}
+		}
+	}
+	res.Details["lower"] = lower
+	res.Details["upper"] = upper
+
+	finalReads := 0
+	for _, p := range pairs {
+		if p.Invoke.F != "read" || !p.Complete.Final || p.Complete.Type != OK {
+			continue
+		}
+		finalReads++
+		if v, ok := toInt(p.Complete.Value); !ok {
+			res.errorf("final read returned non-integer %v", p.Complete.Value)
+		} else if v < lower || v > upper {
+			res.errorf("final read of %d outside acceptable range [%d, %d]", v, lower, upper)
+		}
+	}
+	if finalReads == 0 && res.Valid == Valid {
+		res.Valid = Unknown
+	}
+	return res
+}
@@ -0,0 +1,19 @@
+package checker
+
+// Echo checks a history of the echo workload: every acknowledged "echo"
+// operation must have returned the value it sent.
+func Echo(h History) Result {
+	res := newResult()
+
+	pairs, err := h.Pairs()
+	if err != nil {
+		res.errorf("malformed history: %s", err)
+		return res
+	}
+	for _, p := range pairs {
+		if p.Invoke.F == "echo" && p.Complete.Type == OK && !equal(p.Invoke.Value, p.Complete.Value) {
+			res.errorf("sent %s but received %s", jsonString(p.Invoke.Value), jsonString(p.Complete.Value))
+		}
+	}
+	return res
+}
@@ -0,0 +1,408 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Kafka checks a history of the kafka workload. Operations are shaped as
+// follows:
+//
+//	send:                   Key is the log, Value is the message. The
+//	                        completion's Value is [offset, message].
+//	poll:                   Value is a map of logs to offsets. The
+//	                        completion's Value is a map of logs to lists of
+//	                        [offset, message] pairs.
+//	commit_offsets:         Value is a map of logs to offsets.
+//	list_committed_offsets: Value is a list of logs. The completion's Value is
+//	                        a map of logs to offsets.
+//
+// Offsets may be sparse, but the checker detects these anomalies:
+//
+//	inconsistent-offset:  an offset holds two different messages.
+//	duplicate:            a message appears at two different offsets.
+//	internal-nonmonotonic: a poll returned offsets that did not strictly
+//	                      increase, or began before the requested offset.
+//	skip:                 a poll passed over an offset known to hold a message.
+//	lost:                 an acknowledged send was never observed, although
+//	                      polls observed later offsets of the same log.
+//	nonmonotonic-send:    a send was assigned an offset no higher than one
+//	                      already observed before the send was invoked.
+//	committed-backwards:  list_committed_offsets returned an offset lower than
+//	                      one committed or listed before it was invoked.
+//
+// Acknowledged sends which no poll observed, and which are past the highest
+// polled offset of their log, are counted as unobserved. That is not an
+// error, since servers may delay making messages visible.
+//
+// The result is Unknown if the history contains no successful sends or polls.
+func Kafka(h History) Result {
+	res := newResult()
+
+	pairs, err := h.Pairs()
+	if err != nil {
+		res.errorf("malformed history: %s", err)
+		return res
+	}
+
+	a := &kafkaAnalysis{
+		res:      &res,
+		logs:     make(map[string]map[int]any),
+		offsetOf: make(map[string]kafkaPos),
+		polled:   make(map[string]map[int]bool),
+		counts:   make(map[string]int),
+	}
+	a.collect(pairs)
+	a.checkPolls(pairs)
+	a.checkLost(pairs)
+	a.checkRealtime(h, pairs)
+
+	sends, polls := 0, 0
+	for _, p := range pairs {
+		if p.Complete.Type != OK {
+			continue
+		}
+		switch p.Invoke.F {
+		case "send":
+			sends++
+		case "poll":
+			polls++
+		}
+	}
+
+	res.Details["send-count"] = sends
+	res.Details["poll-count"] = polls
+	res.Details["key-count"] = len(a.logs)
+	for _, kind := range kafkaAnomalies {
+		res.Details[kind+"-count"] = a.counts[kind]
+	}
+	res.Details["unobserved-count"] = a.unobserved
+	if (sends == 0 || polls == 0) && res.Valid == Valid {
+		res.Valid = Unknown
+	}
+	return res
+}
+
+// kafkaAnomalies are the kinds of errors the kafka checker reports.
+var kafkaAnomalies = []string{
+	"inconsistent-offset",
+	"duplicate",
+	"internal-nonmonotonic",
+	"skip",
+	"lost",
+	"nonmonotonic-send",
+	"committed-backwards",
+}
+
+// maxKafkaErrors is the number of examples of each anomaly included in the
+// result's Errors.
+const maxKafkaErrors = 8
+
+// kafkaAnalysis accumulates state while checking a kafka history.
+type kafkaAnalysis struct {
+	res *Result
+
+	// Every message known to be at each offset of each log, from either
+	// sends or polls.
+	logs map[string]map[int]any
+
+	// The position of every message.
+	offsetOf map[string]kafkaPos
+
+	// The offsets of each log observed by polls.
+	polled map[string]map[int]bool
+
+	counts     map[string]int
+	unobserved int
+}
+
+// kafkaPos is the position of a message.
+type kafkaPos struct {
+	key    string
+	offset int
+}
+
+// errorf records an anomaly of the given kind.
+func (a *kafkaAnalysis) errorf(kind, format string, args ...any) {
+	a.counts[kind]++
+	if a.counts[kind] <= maxKafkaErrors {
+		a.res.errorf("%s: %s", kind, fmt.Sprintf(format, args...))
+	} else {
+		a.res.Valid = Invalid
+	}
+}
+
+// collect records every message observed by successful sends and polls.
+func (a *kafkaAnalysis) collect(pairs []Pair) {
+	for _, p := range pairs {
+		if p.Complete.Type != OK {
+			continue
+		}
+		switch p.Invoke.F {
+		case "send":
+			key, _ := p.Invoke.Key.(string)
+			if offset, msg, ok := kafkaRecord(p.Complete.Value); !ok {
+				a.res.errorf("malformed send result %v", p.Complete.Value)
+			} else {
+				a.observe(key, offset, msg)
+			}
+
+		case "poll":
+			for key, records := range kafkaPoll(p.Complete.Value) {
+				if a.polled[key] == nil {
+					a.polled[key] = make(map[int]bool)
+				}
+				for _, r := range records {
+					a.polled[key][r.offset] = true
+					a.observe(key, r.offset, r.msg)
+				}
+			}
+		}
+	}
+}
+
+// observe records that msg was seen at offset of key.
+func (a *kafkaAnalysis) observe(key string, offset int, msg any) {
+	log := a.logs[key]
+	if log == nil {
+		log = make(map[int]any)
+		a.logs[key] = log
+	}
+	if prev, ok := log[offset]; !ok {
+		log[offset] = msg
+	} else if !equal(prev, msg) {
+		a.errorf("inconsistent-offset", "%s offset %d holds both %s and %s", key, offset, jsonString(prev), jsonString(msg))
+	}
+
+	pos := kafkaPos{key: key, offset: offset}
+	m := jsonString(msg)
+	if prev, ok := a.offsetOf[m]; !ok {
+		a.offsetOf[m] = pos
+	} else if prev != pos {
+		a.errorf("duplicate", "message %s is at both %s offset %d and %s offset %d", m, prev.key, prev.offset, key, offset)
+	}
+}
+
+// checkPolls verifies that each poll's offsets increase, and that no poll
+// skipped over an offset known to hold a message.
+func (a *kafkaAnalysis) checkPolls(pairs []Pair) {
+	for _, p := range pairs {
+		if p.Invoke.F != "poll" || p.Complete.Type != OK {
+			continue
+		}
+		requested := kafkaOffsets(p.Invoke.Value)
+		for key, records := range kafkaPoll(p.Complete.Value) {
+			from, ok := requested[key]
+			if !ok && len(records) > 0 {
+				from = records[0].offset
+			}
+
+			prev := from - 1
+			for _, r := range records {
+				if r.offset <= prev {
+					if prev == from-1 {
+						a.errorf("internal-nonmonotonic", "poll of %s from offset %d returned offset %d", key, from, r.offset)
+					} else {
+						a.errorf("internal-nonmonotonic", "poll of %s returned offset %d after %d", key, r.offset, prev)
+					}
+				} else if skipped := a.between(key, prev, r.offset); len(skipped) > 0 {
+					a.errorf("skip", "poll of %s from offset %d skipped offsets %v before returning %d", key, from, skipped, r.offset)
+				}
+				if r.offset > prev {
+					prev = r.offset
+				}
+			}
+		}
+	}
+}
+
+// between returns the known offsets of key strictly between lo and hi.
+func (a *kafkaAnalysis) between(key string, lo, hi int) []int {
+	var offsets []int
+	for offset := range a.logs[key] {
+		if offset > lo && offset < hi {
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// checkLost looks for acknowledged sends which were never polled.
+func (a *kafkaAnalysis) checkLost(pairs []Pair) {
+	maxPolled := make(map[string]int)
+	for key, offsets := range a.polled {
+		for offset := range offsets {
+			if m, ok := maxPolled[key]; !ok || offset > m {
+				maxPolled[key] = offset
+			}
+		}
+	}
+
+	for _, p := range pairs {
+		if p.Invoke.F != "send" || p.Complete.Type != OK {
+			continue
+		}
+		key, _ := p.Invoke.Key.(string)
+		offset, msg, ok := kafkaRecord(p.Complete.Value)
+		if !ok || a.polled[key][offset] {
+			continue
+		}
+		if m, ok := maxPolled[key]; ok && offset < m {
+			a.errorf("lost", "send of %s to %s at offset %d was never polled, but offset %d was", jsonString(msg), key, offset, m)
+		} else {
+			a.unobserved++
+		}
+	}
+}
+
+// checkRealtime verifies properties which depend on the real-time order of
+// operations: sends must be assigned offsets beyond every offset already
+// observed, and committed offsets must never go backwards.
+func (a *kafkaAnalysis) checkRealtime(h History, pairs []Pair) {
+	byInvoke := make(map[int]int)   // history index -> pair index
+	byComplete := make(map[int]int) // history index -> pair index
+	for i, p := range pairs {
+		byInvoke[p.Index] = i
+		byComplete[p.CompleteIndex] = i
+	}
+
+	observed := make(map[string]int)  // highest offset observed by completed ops
+	committed := make(map[string]int) // highest offset committed or listed by completed ops
+	floors := make(map[int]map[string]int)
+
+	for i := range h {
+		if j, ok := byInvoke[i]; ok {
+			// Snapshot what the operation must respect.
+			p := pairs[j]
+			switch p.Invoke.F {
+			case "send":
+				key, _ := p.Invoke.Key.(string)
+				if o, ok := observed[key]; ok {
+					floors[j] = map[string]int{key: o}
+				}
+			case "list_committed_offsets":
+				floor := make(map[string]int)
+				keys, _ := toSlice(p.Invoke.Value)
+				for _, k := range keys {
+					k, _ := k.(string)
+					if o, ok := committed[k]; ok {
+						floor[k] = o
+					}
+				}
+				floors[j] = floor
+			}
+			continue
+		}
+
+		j, ok := byComplete[i]
+		if !ok || pairs[j].Complete.Type != OK {
+			continue
+		}
+		p := pairs[j]
+		switch p.Invoke.F {
+		case "send":
+			key, _ := p.Invoke.Key.(string)
+			offset, _, ok := kafkaRecord(p.Complete.Value)
+			if !ok {
+				continue
+			}
+			if floor, ok := floors[j][key]; ok && offset <= floor {
+				a.errorf("nonmonotonic-send", "send to %s was assigned offset %d, but offset %d was already observed", key, offset, floor)
+			}
+			raise(observed, key, offset)
+
+		case "poll":
+			for key, records := range kafkaPoll(p.Complete.Value) {
+				for _, r := range records {
+					raise(observed, key, r.offset)
+				}
+			}
+
+		case "commit_offsets":
+			for key, offset := range kafkaOffsets(p.Invoke.Value) {
+				raise(committed, key, offset)
+			}
+
+		case "list_committed_offsets":
+			listed := kafkaOffsets(p.Complete.Value)
+			for _, key := range sortedKeys(floors[j]) {
+				floor := floors[j][key]
+				if offset, ok := listed[key]; !ok {
+					a.errorf("committed-backwards", "list_committed_offsets omitted %s, but offset %d was already committed", key, floor)
+				} else if offset < floor {
+					a.errorf("committed-backwards", "list_committed_offsets returned %d for %s, but offset %d was already committed", offset, key, floor)
+				}
+			}
+			for key, offset := range listed {
+				raise(committed, key, offset)
+			}
+		}
+	}
+}
+
+// raise sets m[key] to offset if it is higher than the current value.
+func raise(m map[string]int, key string, offset int) {
+	if o, ok := m[key]; !ok || offset > o {
+		m[key] = offset
+	}
+}
+
+// kafkaMsg is a message at an offset in a log.
+type kafkaMsg struct {
+	offset int
+	msg    any
+}
+
+// kafkaRecord parses an [offset, message] pair.
+func kafkaRecord(v any) (offset int, msg any, ok bool) {
+	pair, ok := toSlice(v)
+	if !ok || len(pair) != 2 {
+		return 0, nil, false
+	}
+	offset, ok = toInt(pair[0])
+	return offset, pair[1], ok
+}
+
+// kafkaOffsets parses a map of logs to offsets.
+func kafkaOffsets(v any) map[string]int {
+	offsets := make(map[string]int)
+	switch v := v.(type) {
+	case map[string]int:
+		for k, o := range v {
+			offsets[k] = o
+		}
+	case map[string]any:
+		for k, o := range v {
+			if o, ok := toInt(o); ok {
+				offsets[k] = o
+			}
+		}
+	}
+	return offsets
+}
+
+// kafkaPoll parses a map of logs to lists of [offset, message] pairs.
+func kafkaPoll(v any) map[string][]kafkaMsg {
+	msgs := make(map[string][]kafkaMsg)
+	m, _ := v.(map[string]any)
+	for key, records := range m {
+		list, _ := toSlice(records)
+		for _, r := range list {
+			if offset, msg, ok := kafkaRecord(r); ok {
+				msgs[key] = append(msgs[key], kafkaMsg{offset: offset, msg: msg})
+			}
+		}
+	}
+	return msgs
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,339 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Model is a sequential specification of a single object, used by the
+// linearizability checker.
+type Model interface {
+	// Init returns the initial state of the object.
+	Init() any
+
+	// Step applies an operation to state and returns the resulting state.
+	// The completion is either an OK operation carrying the observed result,
+	// or an Info operation whose result is unknown. Returns false if the
+	// operation could not have completed that way from state.
+	//
+	// Implementations must not modify state in place.
+	Step(state any, invoke, complete Op) (bool, any)
+}
+
+// Register models a single key in Maelstrom's lin-kv service. The state is
+// the current value, or nil if the key does not exist.
+//
+// Operations are interpreted as follows:
+//
+//	read:  the completion's Value is the value observed (nil if missing)
+//	write: Value is the value written
+//	cas:   Value is a two-element slice of [from, to]
+type Register struct{}
+
+// Init returns the state of a key that has never been written.
+func (Register) Init() any { return nil }
+
+// Step applies a read, write, or cas to the register.
+func (Register) Step(state any, invoke, complete Op) (bool, any) {
+	switch invoke.F {
+	case "read":
+		if complete.Type == Info {
+			return true, state
+		}
+		return equal(state, complete.Value), state
+
+	case "write":
+		return true, invoke.Value
+
+	case "cas":
+		from, to, ok := casArgs(invoke.Value)
+		if !ok {
+			return false, state
+		}
+		if state != nil && equal(state, from) {
+			return true, to
+		}
+		// An indeterminate cas whose precondition does not hold simply failed.
+		return complete.Type == Info, state
+
+	default:
+		return false, state
+	}
+}
+
+// casArgs extracts [from, to] from a cas operation's value.
+func casArgs(v any) (from, to any, ok bool) {
+	s, ok := toSlice(v)
+	if !ok || len(s) != 2 {
+		return nil, nil, false
+	}
+	return s[0], s[1], true
+}
+
+// LinearizableResult describes the outcome of a linearizability check. When a
+// history is not linearizable it holds a minimal counterexample: the longest
+// linearization the search found, and the operations which were concurrent
+// at that point but could not be linearized next.
+type LinearizableResult struct {
+	Valid bool
+
+	// Key is the key whose subhistory could not be linearized, when checking
+	// with LinearizableKV.
+	Key any
+
+	// Linearized is the longest sequence of completed operations, in
+	// linearization order, for which the search found a valid order.
+	Linearized []Op
+
+	// State is the model state after applying Linearized.
+	State any
+
+	// Pending are the operations which could have taken effect next, none of
+	// which are consistent with State.
+	Pending []Op
+}
+
+// String returns a human-readable report of the result.
+func (r LinearizableResult) String() string {
+	if r.Valid {
+		return "linearizable"
+	}
+
+	var b strings.Builder
+	b.WriteString("not linearizable")
+	if r.Key != nil {
+		fmt.Fprintf(&b, " (key %v)", r.Key)
+	}
+	b.WriteString("\nlongest linearizable prefix:\n")
+	for _, op := range r.Linearized {
+		fmt.Fprintf(&b, "  %s\n", op)
+	}
+	fmt.Fprintf(&b, "state: %s\n", jsonString(r.State))
+	b.WriteString("none of these could be linearized next:\n")
+	for _, op := range r.Pending {
+		fmt.Fprintf(&b, "  %s\n", op)
+	}
+	return b.String()
+}
+
+// Linearizable checks whether h is linearizable with respect to model, using
+// the Wing & Gong search with Lowe's memoization (as in Knossos and
+// Porcupine).
+//
+// Failed operations are ignored. Indeterminate operations, and invocations
+// that never complete, may take effect at any point after their invocation,
+// or not at all.
+func Linearizable(h History, model Model) (LinearizableResult, error) {
+	pairs, err := h.Pairs()
+	if err != nil {
+		return LinearizableResult{}, err
+	}
+	return checkLinearizable(pairs, model), nil
+}
+
+// LinearizableKV checks a history of lin-kv operations on many keys. Since
+// linearizability is compositional, each key is checked independently against
+// the Register model. The result describes the first key that fails.
+func LinearizableKV(h History) (LinearizableResult, error) {
+	pairs, err := h.Pairs()
+	if err != nil {
+		return LinearizableResult{}, err
+	}
+
+	var keys []string
+	byKey := make(map[string][]Pair)
+	for _, p := range pairs {
+		k := jsonString(p.Invoke.Key)
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], p)
+	}
+
+	for _, k := range keys {
+		res := checkLinearizable(byKey[k], Register{})
+		if !res.Valid {
+			res.Key = byKey[k][0].Invoke.Key
+			return res, nil
+		}
+	}
+	return LinearizableResult{Valid: true}, nil
+}
+
+// linOp is an operation participating in the linearizability search.
+type linOp struct {
+	invoke, complete Op
+	call, ret        int
+}
+
+// linEntry is a call or return event in the doubly linked list that the
+// search lifts operations out of as it linearizes them.
+type linEntry struct {
+	id         int
+	match      *linEntry // return entry, for call entries only
+	prev, next *linEntry
+}
+
+func checkLinearizable(pairs []Pair, model Model) LinearizableResult {
+	var ops []linOp
+	for _, p := range pairs {
+		switch {
+		case p.Complete.Type == Fail:
+			continue // never took effect
+		case p.Complete.Type == Info && p.Invoke.F == "read":
+			continue // constrains nothing
+		}
+
+		ret := p.CompleteIndex
+		if p.Complete.Type == Info {
+			ret = int(^uint(0) >> 1) // may take effect any time after invocation
+		}
+		ops = append(ops, linOp{invoke: p.Invoke, complete: p.Complete, call: p.Index, ret: ret})
+	}
+
+	// Build the event list, ordered by time.
+	type event struct {
+		time int
+		e    *linEntry
+	}
+	events := make([]event, 0, 2*len(ops))
+	for i, op := range ops {
+		ret := &linEntry{id: i}
+		events = append(events, event{op.call, &linEntry{id: i, match: ret}}, event{op.ret, ret})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time < events[j].time })
+
+	head := &linEntry{id: -1}
+	prev := head
+	for _, ev := range events {
+		ev.e.prev = prev
+		prev.next = ev.e
+		prev = ev.e
+	}
+
+	type frame struct {
+		e     *linEntry
+		state any
+	}
+	var stack []frame
+	var best []int
+	var bestState any
+
+	state := model.Init()
+	bestState = state
+	linearized := make(bitset, (len(ops)+63)/64)
+	cache := make(map[string]struct{})
+
+	e := head.next
+	for head.next != nil {
+		if e.match != nil {
+			op := ops[e.id]
+			if ok, next := model.Step(state, op.invoke, op.complete); ok {
+				linearized.set(e.id)
+				key := linearized.key() + jsonString(next)
+				if _, seen := cache[key]; !seen {
+					cache[key] = struct{}{}
+					stack = append(stack, frame{e: e, state: state})
+					state = next
+					e.lift()
+
+					if len(stack) > len(best) {
+						best = best[:0]
+						for _, f := range stack {
+							best = append(best, f.e.id)
+						}
+						bestState = state
+					}
+					e = head.next
+					continue
+				}
+				linearized.clear(e.id)
+			}
+			e = e.next
+			continue
+		}
+
+		// We reached the return of an operation we were unable to linearize,
+		// so backtrack.
+		if len(stack) == 0 {
+			return counterexample(ops, best, bestState)
+		}
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = f.state
+		linearized.clear(f.e.id)
+		f.e.unlift()
+		e = f.e.next
+	}
+	return LinearizableResult{Valid: true}
+}
+
+// counterexample builds a report from the longest linearization found.
+func counterexample(ops []linOp, best []int, state any) LinearizableResult {
+	res := LinearizableResult{State: state}
+
+	done := make(map[int]bool)
+	for _, id := range best {
+		done[id] = true
+		res.Linearized = append(res.Linearized, ops[id].complete)
+	}
+
+	// The earliest-returning operation we could not linearize must have taken
+	// effect before it returned, so the candidates are every remaining
+	// operation invoked before that point.
+	deadline := int(^uint(0) >> 1)
+	for id, op := range ops {
+		if !done[id] && op.ret < deadline {
+			deadline = op.ret
+		}
+	}
+	var pending []linOp
+	for id, op := range ops {
+		if !done[id] && op.call < deadline {
+			pending = append(pending, op)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].call < pending[j].call })
+	for _, op := range pending {
+		res.Pending = append(res.Pending, op.complete)
+	}
+	return res
+}
+
+// lift removes a call entry and its matching return from the list.
+func (e *linEntry) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift reinserts an entry previously removed by lift.
+func (e *linEntry) unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// bitset is a set of operation ids.
+type bitset []uint64
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (i % 64) }
+
+// key returns a string uniquely identifying the contents of the set.
+func (b bitset) key() string {
+	var sb strings.Builder
+	for _, w := range b {
+		fmt.Fprintf(&sb, "%x.", w)
+	}
+	return sb.String()
+}
@@ -0,0 +1,223 @@
+package checker_test
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+func TestLinearizable(t *testing.T) {
+	t.Run("Sequential", func(t *testing.T) {
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "write", Value: 1},
+			{Process: 0, Type: checker.OK, F: "write", Value: 1},
+			{Process: 0, Type: checker.Invoke, F: "cas", Value: []any{1, 2}},
+			{Process: 0, Type: checker.OK, F: "cas", Value: []any{1, 2}},
+			{Process: 0, Type: checker.Invoke, F: "read"},
+			{Process: 0, Type: checker.OK, F: "read", Value: 2},
+		}
+		mustLinearizable(t, h, true)
+	})
+
+	t.Run("ConcurrentReadMayObserveEither", func(t *testing.T) {
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "write", Value: 1},
+			{Process: 0, Type: checker.OK, F: "write", Value: 1},
+			{Process: 0, Type: checker.Invoke, F: "write", Value: 2},
+			{Process: 1, Type: checker.Invoke, F: "read"},
+			{Process: 1, Type: checker.OK, F: "read", Value: 1},
+			{Process: 2, Type: checker.Invoke, F: "read"},
+			{Process: 2, Type: checker.OK, F: "read", Value: 2},
+			{Process: 0, Type: checker.OK, F: "write", Value: 2},
+		}
+		mustLinearizable(t, h, true)
+	})
+
+	t.Run("StaleRead", func(t *testing.T) {
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "write", Value: 1},
+			{Process: 0, Type: checker.OK, F: "write", Value: 1},
+			{Process: 0, Type: checker.Invoke, F: "write", Value: 2},
+			{Process: 0, Type: checker.OK, F: "write", Value: 2},
+			{Process: 1, Type: checker.Invoke, F: "read"},
+			{Process: 1, Type: checker.OK, F: "read", Value: 1},
+		}
+		res := mustLinearizable(t, h, false)
+		if got, want := len(res.Linearized), 2; got != want {
+			t.Fatalf("len(Linearized)=%d, want %d", got, want)
+		} else if got, want := res.State, 2; got != want {
+			t.Fatalf("State=%v, want %v", got, want)
+		} else if got, want := len(res.Pending), 1; got != want {
+			t.Fatalf("len(Pending)=%d, want %d", got, want)
+		} else if got, want := res.Pending[0].F, "read"; got != want {
+			t.Fatalf("Pending[0].F=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("ReadMissingKey", func(t *testing.T) {
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "read"},
+			{Process: 0, Type: checker.OK, F: "read", Value: nil},
+			{Process: 0, Type: checker.Invoke, F: "cas", Value: []any{nil, 1}},
+			{Process: 0, Type: checker.OK, F: "cas", Value: []any{nil, 1}},
+		}
+		mustLinearizable(t, h, false)
+	})
+
+	t.Run("FailedCASIgnored", func(t *testing.T) {
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "write", Value: 1},
+			{Process: 0, Type: checker.OK, F: "write", Value: 1},
+			{Process: 1, Type: checker.Invoke, F: "cas", Value: []any{1, 3}},
+			{Process: 1, Type: checker.Fail, F: "cas", Value: []any{1, 3}},
+			{Process: 0, Type: checker.Invoke, F: "read"},
+			{Process: 0, Type: checker.OK, F: "read", Value: 1},
+		}
+		mustLinearizable(t, h, true)
+	})
+
+	t.Run("IndeterminateWrite", func(t *testing.T) {
+		// An info write may take effect long after it was invoked...
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "write", Value: 1},
+			{Process: 0, Type: checker.Info, F: "write", Value: 1},
+			{Process: 1, Type: checker.Invoke, F: "read"},
+			{Process: 1, Type: checker.OK, F: "read", Value: nil},
+			{Process: 1, Type: checker.Invoke, F: "read"},
+			{Process: 1, Type: checker.OK, F: "read", Value: 1},
+		}
+		mustLinearizable(t, h, true)
+
+		// ...but not before it was invoked.
+		h = checker.History{
+			{Process: 1, Type: checker.Invoke, F: "read"},
+			{Process: 1, Type: checker.OK, F: "read", Value: 1},
+			{Process: 0, Type: checker.Invoke, F: "write", Value: 1},
+		}
+		mustLinearizable(t, h, false)
+	})
+
+	t.Run("ErrOverlappingInvoke", func(t *testing.T) {
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "read"},
+			{Process: 0, Type: checker.Invoke, F: "read"},
+		}
+		if _, err := checker.Linearizable(h, checker.Register{}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestLinearizableKV(t *testing.T) {
+	t.Run("IndependentKeys", func(t *testing.T) {
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "write", Key: 1, Value: 1},
+			{Process: 0, Type: checker.OK, F: "write", Key: 1, Value: 1},
+			{Process: 0, Type: checker.Invoke, F: "write", Key: 2, Value: 2},
+			{Process: 0, Type: checker.OK, F: "write", Key: 2, Value: 2},
+			{Process: 0, Type: checker.Invoke, F: "read", Key: 1},
+			{Process: 0, Type: checker.OK, F: "read", Key: 1, Value: 1},
+		}
+		res, err := checker.LinearizableKV(h)
+		if err != nil {
+			t.Fatal(err)
+		} else if !res.Valid {
+			t.Fatalf("unexpected result: %s", res)
+		}
+	})
+
+	t.Run("ReportsKey", func(t *testing.T) {
+		h := checker.History{
+			{Process: 0, Type: checker.Invoke, F: "write", Key: 1, Value: 1},
+			{Process: 0, Type: checker.OK, F: "write", Key: 1, Value: 1},
+			{Process: 0, Type: checker.Invoke, F: "read", Key: 2},
+			{Process: 0, Type: checker.OK, F: "read", Key: 2, Value: 1},
+		}
+		res, err := checker.LinearizableKV(h)
+		if err != nil {
+			t.Fatal(err)
+		} else if res.Valid {
+			t.Fatal("expected invalid result")
+		} else if got, want := res.Key, 2; got != want {
+			t.Fatalf("Key=%v, want %v", got, want)
+		} else if !strings.Contains(res.String(), "not linearizable (key 2)") {
+			t.Fatalf("unexpected report: %s", res)
+		}
+	})
+}
+
+// Ensure histories recorded against a real linearizable register pass.
+func TestLinearizable_Random(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		reg   any
+		h     checker.History
+		hmu   sync.Mutex
+		wg    sync.WaitGroup
+		procs = 5
+	)
+	record := func(op checker.Op) {
+		hmu.Lock()
+		defer hmu.Unlock()
+		h = append(h, op)
+	}
+
+	for p := 0; p < procs; p++ {
+		p := p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(p)))
+			for i := 0; i < 20; i++ {
+				op := checker.Op{Process: p, Type: checker.Invoke}
+				switch rnd.Intn(3) {
+				case 0:
+					op.F = "read"
+				case 1:
+					op.F, op.Value = "write", rnd.Intn(5)
+				case 2:
+					op.F, op.Value = "cas", []any{rnd.Intn(5), rnd.Intn(5)}
+				}
+				record(op)
+				time.Sleep(time.Duration(rnd.Intn(100)) * time.Microsecond)
+
+				mu.Lock()
+				op.Type = checker.OK
+				switch op.F {
+				case "read":
+					op.Value = reg
+				case "write":
+					reg = op.Value
+				case "cas":
+					if v := op.Value.([]any); reg == v[0] {
+						reg = v[1]
+					} else {
+						op.Type = checker.Fail
+					}
+				}
+				mu.Unlock()
+
+				time.Sleep(time.Duration(rnd.Intn(100)) * time.Microsecond)
+				record(op)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mustLinearizable(t, h, true)
+}
+
+func mustLinearizable(tb testing.TB, h checker.History, valid bool) checker.LinearizableResult {
+	tb.Helper()
+	res, err := checker.Linearizable(h, checker.Register{})
+	if err != nil {
+		tb.Fatal(err)
+	} else if res.Valid != valid {
+		tb.Fatalf("Valid=%v, want %v\n%s", res.Valid, valid, res)
+	}
+	return res
+}
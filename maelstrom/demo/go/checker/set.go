@@ -0,0 +1,157 @@
+package checker
+
+import (
+	"sort"
+	"time"
+)
+
+// Set checks a history of a grow-only set, as in the broadcast and g-set
+// workloads. Elements are added by operations with function addF, whose Value
+// is the element. Reads have function "read", and their completion's Value is
+// the list of elements present.
+//
+// Every element whose addition was acknowledged must be present in every final
+// read, and no read may return an element that was never added. The result is
+// Unknown if there are no final reads.
+//
+// If operations have times, the result also reports how long elements took to
+// appear, as Maelstrom does: an element is stale if a read which began after
+// its addition was acknowledged did not include it, and its stable latency is
+// the time from the start of its addition to the start of the last read which
+// did not include it.
+func Set(h History, addF string) Result {
+	res := newResult()
+
+	attempted := make(map[string]any)
+	acknowledged := make(map[string]any)
+	for _, op := range h {
+		if op.F != addF {
+			continue
+		}
+		switch op.Type {
+		case Invoke:
+			attempted[jsonString(op.Value)] = op.Value
+		case OK:
+			acknowledged[jsonString(op.Value)] = op.Value
+		}
+	}
+
+	lost := make(map[string]any)
+	unexpected := make(map[string]any)
+	finalReads := 0
+	for _, op := range h {
+		if op.F != "read" || op.Type != OK {
+			continue
+		}
+		elements, _ := toSlice(op.Value)
+		read := make(map[string]bool, len(elements))
+		for _, e := range elements {
+			k := jsonString(e)
+			read[k] = true
+			if _, ok := attempted[k]; !ok {
+				unexpected[k] = e
+			}
+		}
+
+		if !op.Final {
+			continue
+		}
+		finalReads++
+		for k, e := range acknowledged {
+			if !read[k] {
+				lost[k] = e
+			}
+		}
+	}
+
+	res.Details["attempt-count"] = len(attempted)
+	res.Details["acknowledged-count"] = len(acknowledged)
+	res.Details["lost-count"] = len(lost)
+	res.Details["unexpected-count"] = len(unexpected)
+	if len(lost) > 0 {
+		res.errorf("lost %d acknowledged elements: %v", len(lost), sortedValues(lost))
+	}
+	if len(unexpected) > 0 {
+		res.errorf("read %d elements that were never added: %v", len(unexpected), sortedValues(unexpected))
+	}
+	if finalReads == 0 && res.Valid == Valid {
+		res.Valid = Unknown
+	}
+	if timed(h) {
+		stale, latencies := stableLatencies(h, addF, acknowledged)
+		res.Details["stale-count"] = stale
+		res.Details["stable-latencies"] = latencies
+	}
+	return res
+}
+
+// stableLatencies returns the number of stale elements among those
+// acknowledged, and the quantiles of their stable latencies.
+func stableLatencies(h History, addF string, acknowledged map[string]any) (int, Quantiles) {
+	pairs, err := h.Pairs()
+	if err != nil {
+		return 0, nil
+	}
+
+	type read struct {
+		start   int64
+		present map[string]bool
+	}
+	var reads []read
+	added := make(map[string]Pair)
+	for _, p := range pairs {
+		switch {
+		case p.Invoke.F == addF && p.Complete.Type == OK:
+			added[jsonString(p.Invoke.Value)] = p
+		case p.Invoke.F == "read" && p.Complete.Type == OK:
+			elements, _ := toSlice(p.Complete.Value)
+			r := read{start: p.Invoke.Time, present: make(map[string]bool, len(elements))}
+			for _, e := range elements {
+				r.present[jsonString(e)] = true
+			}
+			reads = append(reads, r)
+		}
+	}
+
+	stale := 0
+	var latencies []int64
+	for k := range acknowledged {
+		p, ok := added[k]
+		if !ok {
+			continue
+		}
+		var latency int64
+		isStale := false
+		for _, r := range reads {
+			if r.start < p.Invoke.Time || r.present[k] {
+				continue
+			}
+			if r.start-p.Invoke.Time > latency {
+				latency = r.start - p.Invoke.Time
+			}
+			if r.start > p.Complete.Time {
+				isStale = true
+			}
+		}
+		if isStale {
+			stale++
+		}
+		latencies = append(latencies, latency/int64(time.Millisecond))
+	}
+	return stale, newQuantiles(latencies)
+}
+
+// sortedValues returns the values of m, ordered by key.
+func sortedValues(m map[string]any) []any {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make([]any, len(keys))
+	for i, k := range keys {
+		values[i] = m[k]
+	}
+	return values
+}
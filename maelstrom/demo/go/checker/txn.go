@@ -0,0 +1,184 @@
+package checker
+
+// Transactions in the txn-rw-register and txn-list-append workloads have
+// function "txn", and their Value is a list of [f, k, v] micro-operations.
+
+// TxnRWRegister checks a history of the txn-rw-register workload. It looks
+// for reads of values that no transaction wrote (garbage reads), reads of
+// values written only by failed transactions (aborted reads), and reads
+// within a transaction which disagree with that transaction's own earlier
+// reads and writes (internal inconsistency).
+//
+// This is much weaker than Maelstrom's Elle-based checker, which infers
+// dependencies between transactions to detect cycles.
+func TxnRWRegister(h History) Result {
+	res := newResult()
+
+	pairs, err := h.Pairs()
+	if err != nil {
+		res.errorf("malformed history: %s", err)
+		return res
+	}
+
+	// Collect every value written, and whether any writer might have committed.
+	committed := make(map[string]bool) // "key value" -> possibly committed
+	for _, p := range pairs {
+		if p.Invoke.F != "txn" {
+			continue
+		}
+		for _, mop := range txnMops(p.Invoke.Value) {
+			if mop.f == "w" {
+				k := jsonString(mop.k) + " " + jsonString(mop.v)
+				committed[k] = committed[k] || p.Complete.Type != Fail
+			}
+		}
+	}
+
+	txns := 0
+	for _, p := range pairs {
+		if p.Invoke.F != "txn" || p.Complete.Type != OK {
+			continue
+		}
+		txns++
+
+		local := make(map[string]any) // key -> value this txn last read or wrote
+		for _, mop := range txnMops(p.Complete.Value) {
+			key := jsonString(mop.k)
+			switch mop.f {
+			case "w":
+				local[key] = mop.v
+			case "r":
+				if prev, ok := local[key]; ok && !equal(prev, mop.v) {
+					res.errorf("internal inconsistency: txn %v read %s of key %s, expected %s", p.Complete.Value, jsonString(mop.v), key, jsonString(prev))
+				} else if mop.v != nil {
+					if ok, known := committed[key+" "+jsonString(mop.v)]; !known {
+						res.errorf("garbage read: txn %v read %s of key %s, which was never written", p.Complete.Value, jsonString(mop.v), key)
+					} else if !ok {
+						res.errorf("aborted read: txn %v read %s of key %s, which was only written by failed transactions", p.Complete.Value, jsonString(mop.v), key)
+					}
+				}
+				local[key] = mop.v
+			}
+		}
+	}
+
+	res.Details["txn-count"] = txns
+	return res
+}
+
+// TxnListAppend checks a history of the txn-list-append workload. Every read
+// of a key must be a prefix of the longest read of that key (so that all
+// reads agree on the order of appends), must not contain duplicates, must
+// only contain elements appended by transactions that might have committed,
+// and must end with any appends the reading transaction itself made.
+//
+// This is much weaker than Maelstrom's Elle-based checker, which infers
+// dependencies between transactions to detect cycles.
+func TxnListAppend(h History) Result {
+	res := newResult()
+
+	pairs, err := h.Pairs()
+	if err != nil {
+		res.errorf("malformed history: %s", err)
+		return res
+	}
+
+	committed := make(map[string]bool) // "key element" -> possibly committed
+	for _, p := range pairs {
+		if p.Invoke.F != "txn" {
+			continue
+		}
+		for _, mop := range txnMops(p.Invoke.Value) {
+			if mop.f == "append" {
+				k := jsonString(mop.k) + " " + jsonString(mop.v)
+				committed[k] = committed[k] || p.Complete.Type != Fail
+			}
+		}
+	}
+
+	longest := make(map[string][]any)
+	txns := 0
+	for _, p := range pairs {
+		if p.Invoke.F != "txn" || p.Complete.Type != OK {
+			continue
+		}
+		txns++
+
+		appended := make(map[string][]any) // key -> elements this txn appended
+		for _, mop := range txnMops(p.Complete.Value) {
+			key := jsonString(mop.k)
+			if mop.f == "append" {
+				appended[key] = append(appended[key], mop.v)
+				continue
+			}
+
+			list, _ := toSlice(mop.v)
+			seen := make(map[string]bool)
+			for _, e := range list {
+				ek := jsonString(e)
+				if seen[ek] {
+					res.errorf("duplicate elements: txn %v read %s twice in key %s", p.Complete.Value, ek, key)
+				}
+				seen[ek] = true
+
+				if ok, known := committed[key+" "+ek]; !known {
+					res.errorf("garbage read: txn %v read %s in key %s, which was never appended", p.Complete.Value, ek, key)
+				} else if !ok {
+					res.errorf("aborted read: txn %v read %s in key %s, which was only appended by failed transactions", p.Complete.Value, ek, key)
+				}
+			}
+
+			if own := appended[key]; len(own) > 0 && (len(list) < len(own) || !equal(list[len(list)-len(own):], own)) {
+				res.errorf("internal inconsistency: txn %v read %s of key %s, which does not end with its own appends %s", p.Complete.Value, jsonString(list), key, jsonString(own))
+			}
+
+			// Both reads must be prefixes of one another.
+			prev := longest[key]
+			short, long := list, prev
+			if len(short) > len(long) {
+				short, long = long, short
+			}
+			if !isPrefix(short, long) {
+				res.errorf("incompatible order: key %s read as both %s and %s", key, jsonString(prev), jsonString(list))
+			} else {
+				longest[key] = long
+			}
+		}
+	}
+
+	res.Details["txn-count"] = txns
+	return res
+}
+
+// txnMop is a single micro-operation in a transaction.
+type txnMop struct {
+	f string
+	k any
+	v any
+}
+
+// txnMops parses a list of [f, k, v] micro-operations.
+func txnMops(v any) []txnMop {
+	list, _ := toSlice(v)
+	mops := make([]txnMop, 0, len(list))
+	for _, m := range list {
+		if m, ok := toSlice(m); ok && len(m) == 3 {
+			f, _ := m[0].(string)
+			mops = append(mops, txnMop{f: f, k: m[1], v: m[2]})
+		}
+	}
+	return mops
+}
+
+// isPrefix returns true if a is a prefix of b.
+func isPrefix(a, b []any) bool {
+	if len(a) > len(b) {
+		return false
+	}
+	for i := range a {
+		if !equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package checker
+
+// UniqueIDs checks a history of the unique-ids workload: every acknowledged
+// "generate" operation must have returned a distinct ID.
+func UniqueIDs(h History) Result {
+	res := newResult()
+
+	attempted := 0
+	seen := make(map[string]int)
+	for _, op := range h {
+		if op.F != "generate" {
+			continue
+		}
+		switch op.Type {
+		case Invoke:
+			attempted++
+		case OK:
+			seen[jsonString(op.Value)]++
+		}
+	}
+
+	acknowledged := 0
+	duplicated := make(map[string]int)
+	for id, n := range seen {
+		acknowledged += n
+		if n > 1 {
+			duplicated[id] = n
+		}
+	}
+
+	res.Details["attempted-count"] = attempted
+	res.Details["acknowledged-count"] = acknowledged
+	res.Details["duplicated-count"] = len(duplicated)
+	if len(duplicated) > 0 {
+		res.errorf("%d IDs were generated more than once: %v", len(duplicated), duplicated)
+	}
+	return res
+}
@@ -0,0 +1,225 @@
+// Command maelstrom-sim runs a Maelstrom workload against a cluster of node
+// binaries over a simulated network, without needing the JVM. It accepts a
+// subset of the flags of "maelstrom test", for example:
+//
+//	maelstrom-sim -w g-counter --bin ./main --node-count 3 --rate 100 --time-limit 10 --nemesis partition
+//
+// Nodes are started as subprocesses and exchange messages with each other,
+// with clients, and with simulated lin-kv, seq-kv and lww-kv services. Each
+// node's STDERR is written to a file in --log-dir, along with sim.log, which
+// holds the messages sent and received by clients and faults injected. The command exits with
+// status 0 if the run is valid, 1 if it is invalid, and 2 if the validity
+// is unknown.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+	"github.com/jepsen-io/maelstrom/demo/go/workload"
+)
+
+func main() {
+	var (
+		name         = flag.String("w", "", "workload to run: "+strings.Join(workload.Names, ", "))
+		bin          = flag.String("bin", "", "path to the node binary")
+		nodeCount    = flag.Int("node-count", 5, "number of nodes")
+		concurrency  = flag.String("concurrency", "1n", "number of clients, or a multiple of the node count such as 2n")
+		rate         = flag.Float64("rate", 5, "approximate number of requests per second")
+		timeLimit    = flag.Float64("time-limit", 10, "duration of the main phase, in seconds")
+		latency      = flag.Float64("latency", 0, "mean network latency, in milliseconds")
+		latencyDist  = flag.String("latency-dist", "constant", "distribution of network latency: constant, uniform or exponential")
+		nemesis      = flag.String("nemesis", "", "comma-separated faults to inject: "+strings.Join(workload.Nemeses, ", "))
+		interval     = flag.Float64("nemesis-interval", 10, "duration of each fault, in seconds")
+		topology     = flag.String("topology", "grid", "topology sent to nodes by the broadcast workload")
+		availability = flag.String("availability", "", `fraction of operations which must succeed, or "total"`)
+		seed         = flag.Int64("seed", time.Now().UnixNano(), "random seed for the workload")
+		logDir       = flag.String("log-dir", filepath.Join("store", "sim"), "directory for node logs")
+	)
+	flag.Parse()
+	log.SetFlags(log.Ltime | log.Lmicroseconds)
+
+	if *name == "" || *bin == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	w, err := workload.New(*name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if b, ok := w.(*workload.Broadcast); ok {
+		b.Topology = *topology
+	}
+
+	cfg := workload.Config{
+		Rate:            *rate,
+		TimeLimit:       seconds(*timeLimit),
+		NemesisInterval: seconds(*interval),
+		Seed:            *seed,
+	}
+	for i := 0; i < *nodeCount; i++ {
+		cfg.Nodes = append(cfg.Nodes, fmt.Sprintf("n%d", i))
+	}
+	if cfg.Concurrency, err = parseConcurrency(*concurrency, *nodeCount); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Availability, err = parseAvailability(*availability); err != nil {
+		log.Fatal(err)
+	}
+	if *nemesis != "" {
+		cfg.Nemesis = strings.Split(*nemesis, ",")
+	}
+
+	net := sim.NewNetwork()
+	net.Latency = time.Duration(*latency * float64(time.Millisecond))
+	if net.LatencyDist, err = parseDist(*latencyDist); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	res, err := run(ctx, net, w, cfg, *bin, *logDir)
+	if err != nil {
+		log.Printf("ERROR: %s", err)
+		os.Exit(255)
+	}
+
+	fmt.Print(res)
+	switch res.Valid {
+	case checker.Valid:
+		fmt.Println("\nEverything looks good! ヽ(‘ー`)ノ")
+	case checker.Invalid:
+		fmt.Println("\nAnalysis invalid! (ﾉಥ益ಥ）ﾉ ┻━┻")
+		os.Exit(1)
+	default:
+		fmt.Println("\nErrors occurred during analysis, but no anomalies found. ಠ~ಠ")
+		os.Exit(2)
+	}
+}
+
+// run starts the cluster on net, runs the workload, and shuts the cluster
+// down.
+func run(ctx context.Context, net *sim.Network, w workload.Workload, cfg workload.Config, bin, logDir string) (workload.Results, error) {
+	bin, err := filepath.Abs(bin)
+	if err != nil {
+		return workload.Results{}, err
+	}
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return workload.Results{}, err
+	}
+	simLog, err := os.Create(filepath.Join(logDir, "sim.log"))
+	if err != nil {
+		return workload.Results{}, err
+	}
+	defer simLog.Close()
+	log.SetOutput(simLog)
+	defer log.SetOutput(os.Stderr)
+
+	for _, typ := range []string{"lin-kv", "seq-kv", "lww-kv"} {
+		net.AddService(typ, sim.NewKV(typ).Node())
+	}
+
+	var procs []*exec.Cmd
+	defer func() {
+		net.Close()
+		for _, cmd := range procs {
+			wait(cmd, 5*time.Second)
+		}
+	}()
+
+	for _, id := range cfg.Nodes {
+		logFile, err := os.Create(filepath.Join(logDir, id+".log"))
+		if err != nil {
+			return workload.Results{}, err
+		}
+		defer logFile.Close()
+
+		cmd := exec.Command(bin)
+		cmd.Stderr = logFile
+		if err := net.StartProcess(id, cmd); err != nil {
+			return workload.Results{}, fmt.Errorf("start %s: %w", id, err)
+		}
+		procs = append(procs, cmd)
+	}
+	fmt.Fprintf(os.Stderr, "Started %d nodes, logging to %s\n", len(procs), logDir)
+
+	return workload.Run(ctx, net, w, cfg)
+}
+
+// wait waits for cmd to exit, killing it if it takes longer than timeout.
+func wait(cmd *exec.Cmd, timeout time.Duration) {
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			log.Printf("node exited: %s", exitErr)
+		}
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// parseConcurrency parses a client count such as "4", or a multiple of the
+// node count such as "2n".
+func parseConcurrency(s string, nodes int) (int, error) {
+	multiple := strings.HasSuffix(s, "n")
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "n"))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid concurrency %q", s)
+	}
+	if multiple {
+		n *= nodes
+	}
+	return n, nil
+}
+
+// parseAvailability parses an availability target: "total" requires every
+// operation to succeed, and a number is the fraction which must succeed.
+func parseAvailability(s string) (float64, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "total":
+		return 1, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("invalid availability %q", s)
+	}
+	return f, nil
+}
+
+// parseDist parses a latency distribution, named as in Maelstrom.
+func parseDist(s string) (sim.Dist, error) {
+	switch s {
+	case "constant":
+		return sim.Constant, nil
+	case "uniform":
+		return sim.Uniform, nil
+	case "exponential":
+		return sim.Exponential, nil
+	}
+	return 0, fmt.Errorf("invalid latency distribution %q", s)
+}
+
+// seconds converts a duration in seconds to a time.Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
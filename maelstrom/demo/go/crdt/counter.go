@@ -0,0 +1,112 @@
+package crdt
+
+import "encoding/json"
+
+// GCounter is a grow-only counter: each node counts its own increments, and
+// merging takes the larger count for each node. It is encoded as a JSON
+// object mapping node IDs to counts.
+type GCounter struct {
+	counts map[string]int
+}
+
+// NewGCounter returns a counter of zero.
+func NewGCounter() *GCounter {
+	return &GCounter{counts: make(map[string]int)}
+}
+
+// Inc adds n, which must not be negative, to node's count, and returns the
+// delta.
+func (c *GCounter) Inc(node string, n int) *GCounter {
+	c.counts[node] += n
+	delta := NewGCounter()
+	delta.counts[node] = c.counts[node]
+	return delta
+}
+
+// Value returns the sum of every node's count.
+func (c *GCounter) Value() int {
+	sum := 0
+	for _, n := range c.counts {
+		sum += n
+	}
+	return sum
+}
+
+// Count returns node's count.
+func (c *GCounter) Count(node string) int { return c.counts[node] }
+
+// Merge takes the larger of each node's counts.
+func (c *GCounter) Merge(other *GCounter) bool {
+	changed := false
+	for node, n := range other.counts {
+		if cur, ok := c.counts[node]; !ok || n > cur {
+			c.counts[node] = n
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Clone returns a copy of the counter.
+func (c *GCounter) Clone() *GCounter {
+	clone := NewGCounter()
+	clone.Merge(c)
+	return clone
+}
+
+func (c *GCounter) MarshalJSON() ([]byte, error) { return json.Marshal(c.counts) }
+
+func (c *GCounter) UnmarshalJSON(data []byte) error {
+	c.counts = make(map[string]int)
+	return json.Unmarshal(data, &c.counts)
+}
+
+// PNCounter is a counter which can also be decremented. It is a pair of
+// grow-only counters, one of increments and one of decrements, encoded as a
+// JSON object with keys "p" and "n".
+type PNCounter struct {
+	P *GCounter `json:"p"`
+	N *GCounter `json:"n"`
+}
+
+// NewPNCounter returns a counter of zero.
+func NewPNCounter() *PNCounter {
+	return &PNCounter{P: NewGCounter(), N: NewGCounter()}
+}
+
+// Add adds delta, which may be negative, to the counter on behalf of node,
+// and returns the delta.
+func (c *PNCounter) Add(node string, delta int) *PNCounter {
+	d := &PNCounter{P: NewGCounter(), N: NewGCounter()}
+	if delta >= 0 {
+		d.P = c.P.Inc(node, delta)
+	} else {
+		d.N = c.N.Inc(node, -delta)
+	}
+	return d
+}
+
+// Value returns the sum of increments less the sum of decrements.
+func (c *PNCounter) Value() int { return c.P.Value() - c.N.Value() }
+
+// Merge merges the increments and decrements separately.
+func (c *PNCounter) Merge(other *PNCounter) bool {
+	p := c.P.Merge(other.P)
+	n := c.N.Merge(other.N)
+	return p || n
+}
+
+// Clone returns a copy of the counter.
+func (c *PNCounter) Clone() *PNCounter {
+	return &PNCounter{P: c.P.Clone(), N: c.N.Clone()}
+}
+
+func (c *PNCounter) UnmarshalJSON(data []byte) error {
+	type pnCounter PNCounter
+	v := pnCounter{P: NewGCounter(), N: NewGCounter()}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = PNCounter(v)
+	return nil
+}
@@ -0,0 +1,53 @@
+// Package crdt provides state-based conflict-free replicated data types.
+//
+// Each type is a value which can be encoded as JSON and merged with another
+// value of the same type. Merging is commutative, associative and
+// idempotent, so replicas which have merged the same values converge,
+// whatever order they merged them in and however often.
+//
+// Mutators, such as GCounter.Inc, update a value in place and return a delta:
+// a small value of the same type holding just the change, which can be sent
+// to other replicas in place of the whole state. A Replica records these
+// deltas so that they can be gossiped with the gossip package.
+//
+// Values are not safe for concurrent use; wrap them in a Replica to share
+// them between goroutines.
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// CRDT is a state-based CRDT, where T is its own pointer type, e.g.
+// *GCounter.
+type CRDT[T any] interface {
+	// Merge joins other into the value, and reports whether it changed.
+	Merge(other T) bool
+
+	// Clone returns a copy of the value.
+	Clone() T
+}
+
+// sortByJSON sorts vs by their JSON encoding, so that sets of values are
+// encoded the same way whatever order they were added in.
+func sortByJSON[V any](vs []V) {
+	keys := make([][]byte, len(vs))
+	for i, v := range vs {
+		keys[i], _ = json.Marshal(v)
+	}
+	sort.Sort(byKey[V]{vs, keys})
+}
+
+type byKey[V any] struct {
+	vs   []V
+	keys [][]byte
+}
+
+func (b byKey[V]) Len() int           { return len(b.vs) }
+func (b byKey[V]) Less(i, j int) bool { return bytes.Compare(b.keys[i], b.keys[j]) < 0 }
+func (b byKey[V]) Swap(i, j int) {
+	b.vs[i], b.vs[j] = b.vs[j], b.vs[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
@@ -0,0 +1,248 @@
+package crdt_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/crdt"
+	"github.com/jepsen-io/maelstrom/demo/go/gossip"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+	"github.com/jepsen-io/maelstrom/demo/go/workload"
+)
+
+func TestMain(m *testing.M) {
+	// Nodes log every message they send and receive.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestLaws(t *testing.T) {
+	t.Run("GSet", func(t *testing.T) {
+		checkLaws(t, crdt.NewGSet[int], func(rnd *rand.Rand, node string, v *crdt.GSet[int]) *crdt.GSet[int] {
+			return v.Add(rnd.Intn(10))
+		})
+	})
+	t.Run("GCounter", func(t *testing.T) {
+		checkLaws(t, crdt.NewGCounter, func(rnd *rand.Rand, node string, v *crdt.GCounter) *crdt.GCounter {
+			return v.Inc(node, rnd.Intn(5))
+		})
+	})
+	t.Run("PNCounter", func(t *testing.T) {
+		checkLaws(t, crdt.NewPNCounter, func(rnd *rand.Rand, node string, v *crdt.PNCounter) *crdt.PNCounter {
+			return v.Add(node, rnd.Intn(11)-5)
+		})
+	})
+	t.Run("ORSet", func(t *testing.T) {
+		checkLaws(t, crdt.NewORSet[int], func(rnd *rand.Rand, node string, v *crdt.ORSet[int]) *crdt.ORSet[int] {
+			if rnd.Intn(3) == 0 {
+				return v.Remove(rnd.Intn(5))
+			}
+			return v.Add(node, rnd.Intn(5))
+		})
+	})
+	t.Run("LWWRegister", func(t *testing.T) {
+		checkLaws(t, crdt.NewLWWRegister[int], func(rnd *rand.Rand, node string, v *crdt.LWWRegister[int]) *crdt.LWWRegister[int] {
+			return v.Set(node, rnd.Intn(10))
+		})
+	})
+	t.Run("MVRegister", func(t *testing.T) {
+		checkLaws(t, crdt.NewMVRegister[int], func(rnd *rand.Rand, node string, v *crdt.MVRegister[int]) *crdt.MVRegister[int] {
+			return v.Set(node, rnd.Intn(10))
+		})
+	})
+}
+
+// checkLaws checks that merging values of a CRDT is commutative, associative
+// and idempotent, that it reports changes correctly, and that values survive
+// a round trip through JSON.
+//
+// Values are generated by applying random ops on three replicas, which
+// occasionally merge each other's values or deltas, so that they share some
+// history as real replicas do.
+func checkLaws[T crdt.CRDT[T]](t *testing.T, zero func() T, op func(rnd *rand.Rand, node string, v T) T) {
+	t.Helper()
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		vs := []T{zero(), zero(), zero()}
+		for j, n := 0, rnd.Intn(30); j < n; j++ {
+			k := rnd.Intn(len(vs))
+			switch delta := op(rnd, fmt.Sprintf("n%d", k), vs[k]); rnd.Intn(4) {
+			case 0:
+				vs[rnd.Intn(len(vs))].Merge(delta)
+			case 1:
+				vs[rnd.Intn(len(vs))].Merge(vs[k])
+			}
+		}
+		a, b, c := vs[0], vs[1], vs[2]
+
+		if got, want := encode(t, merge(a, b)), encode(t, merge(b, a)); got != want {
+			t.Fatalf("a+b=%s, b+a=%s\na=%s\nb=%s", got, want, encode(t, a), encode(t, b))
+		}
+		if got, want := encode(t, merge(merge(a, b), c)), encode(t, merge(a, merge(b, c))); got != want {
+			t.Fatalf("(a+b)+c=%s, a+(b+c)=%s", got, want)
+		}
+		if got, want := encode(t, merge(a, a)), encode(t, a); got != want {
+			t.Fatalf("a+a=%s, a=%s", got, want)
+		}
+
+		ab := a.Clone()
+		if got, want := ab.Merge(b), encode(t, ab) != encode(t, a); got != want {
+			t.Fatalf("a.Merge(b)=%v, want %v\na=%s\nb=%s", got, want, encode(t, a), encode(t, b))
+		}
+
+		var decoded T
+		if err := json.Unmarshal([]byte(encode(t, a)), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := encode(t, decoded), encode(t, a); got != want {
+			t.Fatalf("decoded=%s, want %s", got, want)
+		}
+	}
+}
+
+// merge returns a+b, leaving both unchanged.
+func merge[T crdt.CRDT[T]](a, b T) T {
+	c := a.Clone()
+	c.Merge(b)
+	return c
+}
+
+func encode(tb testing.TB, v any) string {
+	tb.Helper()
+	buf, err := json.Marshal(v)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestORSet(t *testing.T) {
+	a, b := crdt.NewORSet[string](), crdt.NewORSet[string]()
+	b.Merge(a.Add("n0", "x"))
+
+	// A remove only removes the adds it has observed, so the concurrent add
+	// wins.
+	removed := b.Remove("x")
+	added := a.Add("n0", "x")
+	a.Merge(removed)
+	b.Merge(added)
+	if !a.Contains("x") || !b.Contains("x") {
+		t.Fatalf("a=%v, b=%v, want [x]", a.Elements(), b.Elements())
+	}
+
+	a.Merge(b.Remove("x"))
+	if a.Contains("x") || b.Contains("x") {
+		t.Fatalf("a=%v, b=%v, want []", a.Elements(), b.Elements())
+	}
+}
+
+func TestMVRegister(t *testing.T) {
+	a, b := crdt.NewMVRegister[int](), crdt.NewMVRegister[int]()
+	da, db := a.Set("n0", 1), b.Set("n1", 2)
+	a.Merge(db)
+	b.Merge(da)
+	for _, r := range []*crdt.MVRegister[int]{a, b} {
+		values := r.Values()
+		sort.Ints(values)
+		if got, want := fmt.Sprint(values), "[1 2]"; got != want {
+			t.Fatalf("values=%v, want %v", got, want)
+		}
+	}
+
+	// A later write replaces both concurrent values.
+	b.Merge(a.Set("n0", 3))
+	if got, want := fmt.Sprint(b.Values()), "[3]"; got != want {
+		t.Fatalf("values=%v, want %v", got, want)
+	}
+}
+
+func TestLWWRegister(t *testing.T) {
+	a, b := crdt.NewLWWRegister[string](), crdt.NewLWWRegister[string]()
+	b.Merge(a.Set("n0", "x"))
+	a.Merge(b.Set("n1", "y"))
+	if got, want := a.Value, "y"; got != want {
+		t.Fatalf("value=%v, want %v", got, want)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	a := crdt.NewReplica(crdt.NewGCounter())
+	for i := 0; i < 5; i++ {
+		a.Update(func(v *crdt.GCounter) *crdt.GCounter { return v.Inc("n0", 1) })
+	}
+	b := crdt.NewReplica(crdt.NewGCounter())
+
+	// A batch of deltas is merged into one.
+	delta, version := a.Delta(0, 3)
+	if got, want := version, 3; got != want {
+		t.Fatalf("version=%v, want %v", got, want)
+	}
+	if _, _, err := b.Merge([]byte(encode(t, delta))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.Value().Value(), 3; got != want {
+		t.Fatalf("value=%v, want %v", got, want)
+	}
+
+	// Deltas which change nothing aren't recorded.
+	before, after, err := b.Merge([]byte(encode(t, delta)))
+	if err != nil {
+		t.Fatal(err)
+	} else if before != after {
+		t.Fatalf("version %v -> %v, want unchanged", before, after)
+	}
+}
+
+func TestServe(t *testing.T) {
+	cfg := gossip.Config{Interval: 50 * time.Millisecond}
+	for _, tt := range []struct {
+		workload string
+		serve    func(n *maelstrom.Node) interface{ Close() }
+	}{
+		{"g-set", func(n *maelstrom.Node) interface{ Close() } { return crdt.ServeGSet(n, cfg) }},
+		{"g-counter", func(n *maelstrom.Node) interface{ Close() } { return crdt.ServePNCounter(n, cfg) }},
+		{"pn-counter", func(n *maelstrom.Node) interface{ Close() } { return crdt.ServePNCounter(n, cfg) }},
+	} {
+		t.Run(tt.workload, func(t *testing.T) {
+			net := sim.NewNetwork()
+			defer net.Close()
+			var nodes []string
+			for i := 0; i < 3; i++ {
+				id := fmt.Sprintf("n%d", i)
+				n := maelstrom.NewNode()
+				defer tt.serve(n).Close()
+				net.AddNode(id, n)
+				nodes = append(nodes, id)
+			}
+
+			w, err := workload.New(tt.workload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := workload.Run(context.Background(), net, w, workload.Config{
+				Nodes:           nodes,
+				Rate:            100,
+				TimeLimit:       time.Second,
+				Nemesis:         []string{"partition"},
+				NemesisInterval: 300 * time.Millisecond,
+				Availability:    1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Valid != checker.Valid {
+				t.Fatalf("unexpected results:\n%s", res)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package crdt
+
+import "encoding/json"
+
+// GSet is a grow-only set. It is encoded as a JSON array.
+type GSet[E comparable] struct {
+	elements map[E]struct{}
+}
+
+// NewGSet returns an empty set.
+func NewGSet[E comparable]() *GSet[E] {
+	return &GSet[E]{elements: make(map[E]struct{})}
+}
+
+// Add adds e to the set, and returns the delta.
+func (s *GSet[E]) Add(e E) *GSet[E] {
+	s.elements[e] = struct{}{}
+	delta := NewGSet[E]()
+	delta.elements[e] = struct{}{}
+	return delta
+}
+
+// Contains reports whether e is in the set.
+func (s *GSet[E]) Contains(e E) bool {
+	_, ok := s.elements[e]
+	return ok
+}
+
+// Elements returns the elements of the set, in no particular order.
+func (s *GSet[E]) Elements() []E {
+	elements := make([]E, 0, len(s.elements))
+	for e := range s.elements {
+		elements = append(elements, e)
+	}
+	return elements
+}
+
+// Merge adds the elements of other to the set.
+func (s *GSet[E]) Merge(other *GSet[E]) bool {
+	changed := false
+	for e := range other.elements {
+		if _, ok := s.elements[e]; !ok {
+			s.elements[e] = struct{}{}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Clone returns a copy of the set.
+func (s *GSet[E]) Clone() *GSet[E] {
+	c := NewGSet[E]()
+	c.Merge(s)
+	return c
+}
+
+func (s *GSet[E]) MarshalJSON() ([]byte, error) {
+	elements := s.Elements()
+	sortByJSON(elements)
+	return json.Marshal(elements)
+}
+
+func (s *GSet[E]) UnmarshalJSON(data []byte) error {
+	var elements []E
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+	s.elements = make(map[E]struct{}, len(elements))
+	for _, e := range elements {
+		s.elements[e] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// ORSet is an observed-remove set, whose elements can be removed as well as
+// added. Each add tags the element with a unique dot, and a remove deletes
+// the dots its replica has observed, so an add concurrent with a remove wins.
+//
+// Removed dots are kept as tombstones, so the set grows with every add.
+type ORSet[E comparable] struct {
+	adds    map[E]map[Dot]struct{} // live dots of each element
+	removed map[Dot]struct{}
+	clock   map[string]int // highest sequence number of each node's dots
+}
+
+// Dot identifies a single add: the node which made it, and how many adds
+// that node had made.
+type Dot struct {
+	Node string `json:"node"`
+	Seq  int    `json:"seq"`
+}
+
+// NewORSet returns an empty set.
+func NewORSet[E comparable]() *ORSet[E] {
+	return &ORSet[E]{
+		adds:    make(map[E]map[Dot]struct{}),
+		removed: make(map[Dot]struct{}),
+		clock:   make(map[string]int),
+	}
+}
+
+// Add adds e to the set on behalf of node, and returns the delta.
+func (s *ORSet[E]) Add(node string, e E) *ORSet[E] {
+	s.clock[node]++
+	d := Dot{node, s.clock[node]}
+	s.addDot(e, d)
+
+	delta := NewORSet[E]()
+	delta.clock[node] = d.Seq
+	delta.addDot(e, d)
+	return delta
+}
+
+// Remove removes e from the set, and returns the delta.
+func (s *ORSet[E]) Remove(e E) *ORSet[E] {
+	delta := NewORSet[E]()
+	for d := range s.adds[e] {
+		s.removed[d] = struct{}{}
+		delta.removed[d] = struct{}{}
+	}
+	delete(s.adds, e)
+	return delta
+}
+
+func (s *ORSet[E]) addDot(e E, d Dot) {
+	dots, ok := s.adds[e]
+	if !ok {
+		dots = make(map[Dot]struct{})
+		s.adds[e] = dots
+	}
+	dots[d] = struct{}{}
+}
+
+// Contains reports whether e is in the set.
+func (s *ORSet[E]) Contains(e E) bool {
+	_, ok := s.adds[e]
+	return ok
+}
+
+// Elements returns the elements of the set, in no particular order.
+func (s *ORSet[E]) Elements() []E {
+	elements := make([]E, 0, len(s.adds))
+	for e := range s.adds {
+		elements = append(elements, e)
+	}
+	return elements
+}
+
+// Merge takes the union of both sets' dots, less the union of their
+// tombstones.
+func (s *ORSet[E]) Merge(other *ORSet[E]) bool {
+	changed := false
+	for d := range other.removed {
+		if _, ok := s.removed[d]; ok {
+			continue
+		}
+		s.removed[d] = struct{}{}
+		changed = true
+		for e, dots := range s.adds {
+			delete(dots, d)
+			if len(dots) == 0 {
+				delete(s.adds, e)
+			}
+		}
+	}
+	for e, dots := range other.adds {
+		for d := range dots {
+			if _, ok := s.removed[d]; ok {
+				continue
+			}
+			if _, ok := s.adds[e][d]; !ok {
+				s.addDot(e, d)
+				changed = true
+			}
+		}
+	}
+	for node, seq := range other.clock {
+		if seq > s.clock[node] {
+			s.clock[node] = seq
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Clone returns a copy of the set.
+func (s *ORSet[E]) Clone() *ORSet[E] {
+	c := NewORSet[E]()
+	c.Merge(s)
+	return c
+}
+
+// orSetJSON is the JSON encoding of an ORSet.
+type orSetJSON[E comparable] struct {
+	Adds    []orSetEntry[E] `json:"adds"`
+	Removed []Dot           `json:"removed"`
+	Clock   map[string]int  `json:"clock"`
+}
+
+type orSetEntry[E comparable] struct {
+	Element E     `json:"element"`
+	Dots    []Dot `json:"dots"`
+}
+
+func (s *ORSet[E]) MarshalJSON() ([]byte, error) {
+	v := orSetJSON[E]{
+		Adds:    make([]orSetEntry[E], 0, len(s.adds)),
+		Removed: sortedDots(s.removed),
+		Clock:   s.clock,
+	}
+	for e, dots := range s.adds {
+		v.Adds = append(v.Adds, orSetEntry[E]{e, sortedDots(dots)})
+	}
+	sortByJSON(v.Adds)
+	return json.Marshal(v)
+}
+
+func (s *ORSet[E]) UnmarshalJSON(data []byte) error {
+	var v orSetJSON[E]
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = *NewORSet[E]()
+	for _, entry := range v.Adds {
+		for _, d := range entry.Dots {
+			s.addDot(entry.Element, d)
+		}
+	}
+	for _, d := range v.Removed {
+		s.removed[d] = struct{}{}
+	}
+	for node, seq := range v.Clock {
+		s.clock[node] = seq
+	}
+	return nil
+}
+
+func sortedDots(dots map[Dot]struct{}) []Dot {
+	sorted := make([]Dot, 0, len(dots))
+	for d := range dots {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Node != sorted[j].Node {
+			return sorted[i].Node < sorted[j].Node
+		}
+		return sorted[i].Seq < sorted[j].Seq
+	})
+	return sorted
+}
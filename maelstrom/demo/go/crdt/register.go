@@ -0,0 +1,150 @@
+package crdt
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// LWWRegister is a last-writer-wins register: merging keeps the value with
+// the later timestamp, breaking ties by node ID. Timestamps come from the
+// local clock, but always advance past the register's current one, so a
+// write always replaces the value it observed.
+type LWWRegister[V any] struct {
+	Value V      `json:"value"`
+	Time  int64  `json:"time,string"` // Unix nanoseconds
+	Node  string `json:"node"`
+}
+
+// NewLWWRegister returns a register holding the zero value, which any write
+// replaces.
+func NewLWWRegister[V any]() *LWWRegister[V] { return &LWWRegister[V]{} }
+
+// Set writes v on behalf of node, and returns the delta.
+func (r *LWWRegister[V]) Set(node string, v V) *LWWRegister[V] {
+	t := time.Now().UnixNano()
+	if t <= r.Time {
+		t = r.Time + 1
+	}
+	*r = LWWRegister[V]{Value: v, Time: t, Node: node}
+	return r.Clone()
+}
+
+// Merge keeps the later of the two writes.
+func (r *LWWRegister[V]) Merge(other *LWWRegister[V]) bool {
+	if other.Time > r.Time || other.Time == r.Time && other.Node > r.Node {
+		*r = *other
+		return true
+	}
+	return false
+}
+
+// Clone returns a copy of the register.
+func (r *LWWRegister[V]) Clone() *LWWRegister[V] {
+	c := *r
+	return &c
+}
+
+// MVRegister is a multi-value register: each write is tagged with a version
+// vector which covers every write it replaced, and merging keeps every write
+// not covered by another, so concurrent writes are all kept until a later
+// write replaces them. It is encoded as a JSON array of writes.
+type MVRegister[V any] struct {
+	writes []mvWrite[V]
+}
+
+type mvWrite[V any] struct {
+	Value V              `json:"value"`
+	Clock map[string]int `json:"clock"`
+}
+
+// NewMVRegister returns a register with no values.
+func NewMVRegister[V any]() *MVRegister[V] { return &MVRegister[V]{} }
+
+// Set replaces every value in the register with v, on behalf of node, and
+// returns the delta.
+func (r *MVRegister[V]) Set(node string, v V) *MVRegister[V] {
+	clock := make(map[string]int)
+	for _, w := range r.writes {
+		for n, seq := range w.Clock {
+			if seq > clock[n] {
+				clock[n] = seq
+			}
+		}
+	}
+	clock[node]++
+	r.writes = []mvWrite[V]{{v, clock}}
+	return r.Clone()
+}
+
+// Values returns the concurrently written values.
+func (r *MVRegister[V]) Values() []V {
+	values := make([]V, len(r.writes))
+	for i, w := range r.writes {
+		values[i] = w.Value
+	}
+	return values
+}
+
+// Merge keeps the writes from either register which no other write covers.
+func (r *MVRegister[V]) Merge(other *MVRegister[V]) bool {
+	all := append(append([]mvWrite[V]{}, r.writes...), other.writes...)
+	var writes []mvWrite[V]
+	changed := false
+	for i, w := range all {
+		keep := true
+		for j, o := range all {
+			// Drop writes covered by another, and all but the first copy of
+			// a write which is in both registers.
+			if i != j && (covers(o.Clock, w.Clock) && !covers(w.Clock, o.Clock) ||
+				j < i && equalClocks(o.Clock, w.Clock)) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			writes = append(writes, w)
+			changed = changed || i >= len(r.writes)
+		} else {
+			changed = changed || i < len(r.writes)
+		}
+	}
+	sortByJSON(writes)
+	r.writes = writes
+	return changed
+}
+
+// Clone returns a copy of the register. Clocks are never modified once
+// written, so they are shared.
+func (r *MVRegister[V]) Clone() *MVRegister[V] {
+	return &MVRegister[V]{writes: append([]mvWrite[V]{}, r.writes...)}
+}
+
+func (r *MVRegister[V]) MarshalJSON() ([]byte, error) {
+	if r.writes == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(r.writes)
+}
+
+func (r *MVRegister[V]) UnmarshalJSON(data []byte) error {
+	r.writes = nil
+	if err := json.Unmarshal(data, &r.writes); err != nil {
+		return err
+	}
+	sortByJSON(r.writes)
+	return nil
+}
+
+// covers reports whether clock a has seen every write that b has.
+func covers(a, b map[string]int) bool {
+	for n, seq := range b {
+		if a[n] < seq {
+			return false
+		}
+	}
+	return true
+}
+
+func equalClocks(a, b map[string]int) bool {
+	return covers(a, b) && covers(b, a)
+}
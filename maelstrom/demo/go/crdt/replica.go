@@ -0,0 +1,92 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// Replica is a CRDT value which is safe for concurrent use, and which
+// implements gossip.State so that it can be replicated by gossip.
+//
+// Its version is the number of deltas it has recorded: those returned by
+// Update, and those merged from peers which changed it. Peers are sent the
+// deltas they haven't acknowledged, merged into one value. Deltas are kept
+// for as long as the replica lives.
+type Replica[T CRDT[T]] struct {
+	mu     sync.RWMutex
+	value  T
+	deltas []T
+}
+
+// NewReplica returns a replica holding value.
+func NewReplica[T CRDT[T]](value T) *Replica[T] {
+	return &Replica[T]{value: value}
+}
+
+// Update calls f with the value, which f may update in place, and records
+// the delta f returns.
+func (r *Replica[T]) Update(f func(v T) (delta T)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deltas = append(r.deltas, f(r.value))
+}
+
+// Read calls f with the value, which f must not modify or retain.
+func (r *Replica[T]) Read(f func(v T)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f(r.value)
+}
+
+// Value returns a copy of the value.
+func (r *Replica[T]) Value() T {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.value.Clone()
+}
+
+// Version returns the number of deltas recorded.
+func (r *Replica[T]) Version() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.deltas)
+}
+
+// Delta returns the deltas recorded after the first since, merged into one.
+func (r *Replica[T]) Delta(since, limit int) (any, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	end := len(r.deltas)
+	if limit > 0 && since+limit < end {
+		end = since + limit
+	}
+	if since >= end {
+		return nil, end
+	}
+	delta := r.deltas[since].Clone()
+	for _, d := range r.deltas[since+1 : end] {
+		delta.Merge(d)
+	}
+	return delta, end
+}
+
+// Merge merges a delta from a peer, and records it if it changed the value.
+func (r *Replica[T]) Merge(delta json.RawMessage) (int, int, error) {
+	if bytes.Equal(delta, []byte("null")) {
+		v := r.Version()
+		return v, v, nil
+	}
+	var d T
+	if err := json.Unmarshal(delta, &d); err != nil {
+		return 0, 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	before := len(r.deltas)
+	if r.value.Merge(d) {
+		r.deltas = append(r.deltas, d)
+	}
+	return before, len(r.deltas), nil
+}
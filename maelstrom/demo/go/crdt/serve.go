@@ -0,0 +1,70 @@
+package crdt
+
+import (
+	"encoding/json"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/gossip"
+)
+
+// Server serves one of Maelstrom's CRDT workloads from a Replica, which it
+// gossips to the node's peers.
+type Server[T CRDT[T]] struct {
+	*Replica[T]
+	node *maelstrom.Node
+	sync *gossip.Gossip
+}
+
+func newServer[T CRDT[T]](n *maelstrom.Node, value T, cfg gossip.Config) *Server[T] {
+	r := NewReplica(value)
+	return &Server[T]{Replica: r, node: n, sync: gossip.New(n, r, cfg)}
+}
+
+// Close stops gossiping.
+func (s *Server[T]) Close() { s.sync.Close() }
+
+// ServeGSet handles the g-set workload's messages on n, with a GSet of
+// integers.
+func ServeGSet(n *maelstrom.Node, cfg gossip.Config) *Server[*GSet[int]] {
+	s := newServer(n, NewGSet[int](), cfg)
+	n.Handle("add", func(msg maelstrom.Message) error {
+		var body struct {
+			Element int `json:"element"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		s.Update(func(v *GSet[int]) *GSet[int] { return v.Add(body.Element) })
+		s.sync.Kick()
+		return n.Reply(msg, map[string]any{"type": "add_ok"})
+	})
+	n.Handle("read", func(msg maelstrom.Message) error {
+		var elements []int
+		s.Read(func(v *GSet[int]) { elements = v.Elements() })
+		return n.Reply(msg, map[string]any{"type": "read_ok", "value": elements})
+	})
+	return s
+}
+
+// ServePNCounter handles the pn-counter workload's messages on n, with a
+// PNCounter. It also serves the g-counter workload.
+func ServePNCounter(n *maelstrom.Node, cfg gossip.Config) *Server[*PNCounter] {
+	s := newServer(n, NewPNCounter(), cfg)
+	n.Handle("add", func(msg maelstrom.Message) error {
+		var body struct {
+			Delta int `json:"delta"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		s.Update(func(v *PNCounter) *PNCounter { return v.Add(n.ID(), body.Delta) })
+		s.sync.Kick()
+		return n.Reply(msg, map[string]any{"type": "add_ok"})
+	})
+	n.Handle("read", func(msg maelstrom.Message) error {
+		var value int
+		s.Read(func(v *PNCounter) { value = v.Value() })
+		return n.Reply(msg, map[string]any{"type": "read_ok", "value": value})
+	})
+	return s
+}
@@ -0,0 +1,67 @@
+package gossip
+
+import "math"
+
+// Bloom is a Bloom filter: a compact summary of a set, which may report that
+// it contains an element it does not (a false positive), but never the
+// reverse. See Config.SummaryInterval.
+//
+// Elements are hashed by their JSON encoding, mixed with a salt, so filters
+// built with different salts have independent false positives.
+type Bloom struct {
+	Bits []byte `json:"bits"`
+	K    int    `json:"k"`
+	Salt uint64 `json:"salt,string"`
+}
+
+// NewBloom returns an empty filter sized to hold n elements with the given
+// false positive rate.
+func NewBloom(n int, p float64, salt uint64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	// The optimal number of bits is -n ln p / (ln 2)^2, and of hashes is
+	// (m/n) ln 2.
+	m := int(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{Bits: make([]byte, (m+7)/8), K: k, Salt: salt}
+}
+
+// Add adds v to the filter.
+func (b *Bloom) Add(v any) {
+	h1, h2 := b.hash(v)
+	m := uint64(len(b.Bits)) * 8
+	for i := 0; i < b.K; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		b.Bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Contains reports whether v may be in the filter.
+func (b *Bloom) Contains(v any) bool {
+	m := uint64(len(b.Bits)) * 8
+	if m == 0 {
+		return false
+	}
+	h1, h2 := b.hash(v)
+	for i := 0; i < b.K; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		if b.Bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hash returns the two hashes of v from which the filter's k bit positions
+// are derived, by double hashing.
+func (b *Bloom) hash(v any) (uint64, uint64) {
+	x := merkleHash(v) ^ b.Salt
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return x & 0xffffffff, x>>32 | 1
+}
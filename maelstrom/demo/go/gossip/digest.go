@@ -0,0 +1,223 @@
+package gossip
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// DigestConfig controls a Digest.
+type DigestConfig struct {
+	// Type is the message type used for digests. Replies are of type
+	// Type + "_ok", and elements are pushed with Type + "_push". Defaults to
+	// "digest".
+	Type string
+
+	// Interval is the time between rounds. Defaults to 500ms.
+	Interval time.Duration
+
+	// RetryAfter is how long to wait for a reconciliation with a peer to
+	// finish before starting another, in case a message was lost. Defaults
+	// to twice Interval.
+	RetryAfter time.Duration
+
+	// Fanout is the maximum number of peers to reconcile with in each round,
+	// chosen at random. Unlimited if zero.
+	Fanout int
+
+	// MaxNodes is the maximum number of hashes to compare in one request.
+	// Larger differences are split over several requests, which keeps
+	// replies, which hold the hashes of every child, within the size of a
+	// line the node can read. Defaults to 32.
+	MaxNodes int
+}
+
+// Digest reconciles a MerkleSet with a node's peers by comparing hashes.
+//
+// In each round, a node sends each peer the root hash of its tree. Where the
+// peer's hashes differ, it replies with its hashes of the children, and the
+// two descend the tree together until they reach the differing leaves. The
+// peer then sends its elements in those leaves, and the node pushes back any
+// elements the peer lacks. When the sets are equal, a round costs a single
+// hash per peer, and otherwise the cost is proportional to the number of
+// differences rather than the size of the set.
+type Digest[T comparable] struct {
+	*rounds
+	set *MerkleSet[T]
+	cfg DigestConfig
+
+	mu     sync.Mutex
+	active map[string]*digestSync
+}
+
+// digestSync tracks a reconciliation with a peer, which may involve several
+// requests in flight at once.
+type digestSync struct {
+	started time.Time
+	pending int
+}
+
+// digestMessage is a request or response in the descent of the tree.
+type digestMessage[T comparable] struct {
+	Type string `json:"type"`
+
+	// Depth and Nodes are the hashes to compare.
+	Depth int          `json:"depth"`
+	Nodes []merkleNode `json:"nodes,omitempty"`
+
+	// Leaves and Elements are the differing leaves, and their contents.
+	Leaves   []int `json:"leaves,omitempty"`
+	Elements []T   `json:"elements,omitempty"`
+}
+
+// NewDigest returns a Digest which reconciles set between n and its peers,
+// and registers handlers for digest messages on n. Rounds begin immediately,
+// and continue until Close is called. Until SetPeers is called, every other
+// node in the cluster is a peer.
+func NewDigest[T comparable](n *maelstrom.Node, set *MerkleSet[T], cfg DigestConfig) *Digest[T] {
+	if cfg.Type == "" {
+		cfg.Type = "digest"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 500 * time.Millisecond
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = 2 * cfg.Interval
+	}
+	if cfg.MaxNodes <= 0 {
+		cfg.MaxNodes = 32
+	}
+
+	d := &Digest[T]{
+		rounds: newRounds(n, cfg.Interval),
+		set:    set,
+		cfg:    cfg,
+		active: make(map[string]*digestSync),
+	}
+	n.Handle(cfg.Type, d.handle)
+	n.Handle(cfg.Type+"_push", d.handlePush)
+	go d.run(d.round)
+	return d
+}
+
+// round starts reconciling with some peers. A peer is skipped if the last
+// reconciliation with it is still in progress, unless it has taken longer
+// than RetryAfter.
+func (d *Digest[T]) round(peers []string) {
+	d.mu.Lock()
+	var idle []string
+	for _, peer := range peers {
+		if s, ok := d.active[peer]; !ok || time.Since(s.started) > d.cfg.RetryAfter {
+			idle = append(idle, peer)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, peer := range d.sample(idle, d.cfg.Fanout) {
+		s := &digestSync{started: time.Now()}
+		d.mu.Lock()
+		d.active[peer] = s
+		d.mu.Unlock()
+		d.send(peer, s, 0, []merkleNode{{0, d.set.Root()}})
+	}
+}
+
+// send asks peer to compare nodes at depth with its own, as part of sync s.
+func (d *Digest[T]) send(peer string, s *digestSync, depth int, nodes []merkleNode) {
+	d.mu.Lock()
+	s.pending++
+	d.mu.Unlock()
+
+	req := digestMessage[T]{Type: d.cfg.Type, Depth: depth, Nodes: nodes}
+	d.node.RPC(peer, req, func(msg maelstrom.Message) error {
+		defer d.done(peer, s)
+		if msg.RPCError() != nil {
+			return nil // retried next round
+		}
+		var resp digestMessage[T]
+		if err := json.Unmarshal(msg.Body, &resp); err != nil {
+			return err
+		}
+		d.descend(peer, s, resp)
+		return nil
+	})
+}
+
+// done records the completion of a request in sync s.
+func (d *Digest[T]) done(peer string, s *digestSync) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s.pending--; s.pending == 0 && d.active[peer] == s {
+		delete(d.active, peer)
+	}
+}
+
+// descend handles a peer's response to a digest: either its hashes of the
+// next level down, or its elements in the leaves which differ.
+func (d *Digest[T]) descend(peer string, s *digestSync, resp digestMessage[T]) {
+	if len(resp.Leaves) > 0 {
+		theirs := make(map[T]struct{}, len(resp.Elements))
+		for _, v := range resp.Elements {
+			theirs[v] = struct{}{}
+		}
+		if d.set.Merge(resp.Elements) > 0 {
+			d.Kick()
+		}
+
+		var missing []T
+		for _, v := range d.set.leafElements(resp.Leaves) {
+			if _, ok := theirs[v]; !ok {
+				missing = append(missing, v)
+			}
+		}
+		if len(missing) > 0 {
+			d.node.RPC(peer, digestMessage[T]{Type: d.cfg.Type + "_push", Elements: missing}, func(maelstrom.Message) error { return nil })
+		}
+		return
+	}
+
+	diff := d.set.diff(resp.Depth, resp.Nodes)
+	for len(diff) > 0 {
+		n := len(diff)
+		if n > d.cfg.MaxNodes {
+			n = d.cfg.MaxNodes
+		}
+		d.send(peer, s, resp.Depth, d.set.hashes(resp.Depth, diff[:n]))
+		diff = diff[n:]
+	}
+}
+
+// handle compares a peer's hashes with our own.
+func (d *Digest[T]) handle(msg maelstrom.Message) error {
+	var req digestMessage[T]
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+	if req.Depth < 0 || req.Depth > merkleDepth {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, "invalid depth")
+	}
+
+	resp := digestMessage[T]{Type: d.cfg.Type + "_ok"}
+	switch diff := d.set.diff(req.Depth, req.Nodes); {
+	case len(diff) == 0:
+	case req.Depth == merkleDepth:
+		resp.Leaves, resp.Elements = diff, d.set.leafElements(diff)
+	default:
+		resp.Depth, resp.Nodes = req.Depth+1, d.set.children(req.Depth, diff)
+	}
+	return d.node.Reply(msg, resp)
+}
+
+// handlePush merges elements a peer found we were missing.
+func (d *Digest[T]) handlePush(msg maelstrom.Message) error {
+	var req digestMessage[T]
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+	if d.set.Merge(req.Elements) > 0 {
+		d.Kick()
+	}
+	return d.node.Reply(msg, map[string]any{"type": d.cfg.Type + "_push_ok"})
+}
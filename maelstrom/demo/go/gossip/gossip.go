@@ -0,0 +1,287 @@
+// Package gossip replicates state between nodes by anti-entropy gossip.
+//
+// Each node holds a State which records every update it applies with an
+// increasing version number. On each round, a node sends every peer the
+// updates the peer has not yet acknowledged, at most one message per peer at
+// a time. Peers acknowledge each message once they have merged it, so lost
+// messages are simply resent on a later round, and updates are never sent to
+// a peer which already has them.
+//
+// A peer can only acknowledge updates it was sent, so a peer which learned
+// of updates from others, e.g. while partitioned from this node, would be
+// sent them all again. States which implement Summarizer avoid this by
+// periodically sending their peers Bloom filters of their contents.
+package gossip
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// State is replicated state, such as a set or a CRDT. Implementations must be
+// safe for concurrent use.
+type State interface {
+	// Version returns the number of updates applied to the state.
+	Version() int
+
+	// Delta returns up to limit of the updates after version since, in a form
+	// which can be encoded as JSON and passed to Merge on another node, and
+	// the version that the delta brings a peer up to. A limit of zero means
+	// no limit.
+	Delta(since, limit int) (delta any, version int)
+
+	// Merge applies a delta received from a peer. It returns the state's
+	// version before and after the merge, which must not include any other
+	// updates.
+	Merge(delta json.RawMessage) (before, after int, err error)
+}
+
+// Summarizer is implemented by states whose updates can be summarized in a
+// Bloom filter, so that updates a peer already has need not be sent.
+type Summarizer interface {
+	// Summarize adds every update in the state to f.
+	Summarize(f *Bloom)
+
+	// DeltaExcept is like Delta, but omits the updates which f contains, and
+	// also returns the number of updates in the delta. The limit applies to
+	// the updates returned, not those skipped.
+	DeltaExcept(since, limit int, f *Bloom) (delta any, n, version int)
+}
+
+// Config controls a Gossip.
+type Config struct {
+	// Type is the message type used for gossip, so that a node can gossip
+	// several states. Replies are of type Type + "_ok". Defaults to "gossip".
+	Type string
+
+	// Interval is the time between rounds. Defaults to 500ms.
+	Interval time.Duration
+
+	// RetryAfter is how long to wait for an acknowledgement before resending
+	// updates to a peer. Defaults to twice Interval.
+	RetryAfter time.Duration
+
+	// MaxBatch is the maximum number of updates in a single message.
+	// Unlimited if zero.
+	MaxBatch int
+
+	// Fanout is the maximum number of peers to send updates to in each
+	// round, chosen at random from those which are behind. Unlimited if zero.
+	Fanout int
+
+	// SummaryInterval is the time between sending each peer a Bloom filter
+	// of the state, if it implements Summarizer. Updates are not sent to a
+	// peer whose latest filter contains them, and no updates are sent to a
+	// peer until its first filter arrives. Disabled if zero.
+	SummaryInterval time.Duration
+
+	// FalsePositiveRate is the rate at which a filter wrongly contains an
+	// update. Defaults to 1%. Each filter is built with a new salt, so
+	// updates skipped because of a false positive are checked again against
+	// the peer's next filter, and sent unless it contains them too.
+	FalsePositiveRate float64
+}
+
+// Gossip replicates a State to a node's peers.
+type Gossip struct {
+	*rounds
+	state      State
+	summarizer Summarizer // nil unless summaries are enabled
+	cfg        Config
+
+	mu         sync.Mutex
+	acked      map[string]int
+	inflight   map[string]time.Time
+	summaries  map[string]*Bloom // latest filter from each peer
+	skipped    map[string]int    // first version skipped by each peer's filter
+	rewinds    map[string]int    // number of times each peer was rewound
+	summarized time.Time         // last time we sent our filter
+}
+
+// gossipMessage carries a delta to a peer.
+type gossipMessage struct {
+	Type    string          `json:"type"`
+	Delta   json.RawMessage `json:"delta"`
+	Version int             `json:"version"`
+}
+
+// summaryMessage carries a Bloom filter of a node's state to a peer.
+type summaryMessage struct {
+	Type   string `json:"type"`
+	Filter *Bloom `json:"filter"`
+}
+
+// New returns a Gossip which replicates state between n and its peers, and
+// registers a handler for gossip messages on n. Rounds begin immediately, and
+// continue until Close is called. Until SetPeers is called, every other node
+// in the cluster is a peer.
+func New(n *maelstrom.Node, state State, cfg Config) *Gossip {
+	if cfg.Type == "" {
+		cfg.Type = "gossip"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 500 * time.Millisecond
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = 2 * cfg.Interval
+	}
+	if cfg.FalsePositiveRate <= 0 {
+		cfg.FalsePositiveRate = 0.01
+	}
+
+	g := &Gossip{
+		rounds:    newRounds(n, cfg.Interval),
+		state:     state,
+		cfg:       cfg,
+		acked:     make(map[string]int),
+		inflight:  make(map[string]time.Time),
+		summaries: make(map[string]*Bloom),
+		skipped:   make(map[string]int),
+		rewinds:   make(map[string]int),
+	}
+	if cfg.SummaryInterval > 0 {
+		g.summarizer, _ = state.(Summarizer)
+	}
+	n.Handle(cfg.Type, g.handle)
+	n.Handle(cfg.Type+"_summary", g.handleSummary)
+	go g.run(g.round)
+	return g
+}
+
+// round sends updates to peers which are behind.
+func (g *Gossip) round(peers []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.summarizer != nil {
+		g.summarize(peers)
+	}
+
+	version := g.state.Version()
+	var behind []string
+	for _, peer := range peers {
+		if g.acked[peer] >= version {
+			continue
+		}
+		if g.summarizer != nil && g.summaries[peer] == nil {
+			continue
+		}
+		if sent, ok := g.inflight[peer]; ok && time.Since(sent) < g.cfg.RetryAfter {
+			continue
+		}
+		behind = append(behind, peer)
+	}
+	for _, peer := range g.sample(behind, g.cfg.Fanout) {
+		g.send(peer)
+	}
+}
+
+// summarize sends peers a filter of our state, if one is due. Must be called
+// with the lock held.
+func (g *Gossip) summarize(peers []string) {
+	if time.Since(g.summarized) < g.cfg.SummaryInterval {
+		return
+	}
+	g.summarized = time.Now()
+	f := NewBloom(g.state.Version(), g.cfg.FalsePositiveRate, rand.Uint64())
+	g.summarizer.Summarize(f)
+	for _, peer := range peers {
+		g.node.Send(peer, summaryMessage{Type: g.cfg.Type + "_summary", Filter: f})
+	}
+}
+
+// send sends a peer the updates it has not acknowledged. Must be called with
+// the lock held.
+func (g *Gossip) send(peer string) {
+	since, rewinds := g.acked[peer], g.rewinds[peer]
+	var delta any
+	var version int
+	if f := g.summaries[peer]; f != nil {
+		var n int
+		delta, n, version = g.summarizer.DeltaExcept(since, g.cfg.MaxBatch, f)
+		if _, ok := g.skipped[peer]; !ok && n < version-since {
+			g.skipped[peer] = since
+		}
+		if n == 0 {
+			// The peer very likely has everything it hasn't acknowledged.
+			g.acked[peer] = version
+			return
+		}
+	} else {
+		delta, version = g.state.Delta(since, g.cfg.MaxBatch)
+	}
+	buf, err := json.Marshal(delta)
+	if err != nil {
+		return
+	}
+
+	g.inflight[peer] = time.Now()
+	g.node.RPC(peer, gossipMessage{Type: g.cfg.Type, Delta: buf, Version: version}, func(msg maelstrom.Message) error {
+		if msg.RPCError() != nil {
+			return nil // retried after RetryAfter
+		}
+		g.mu.Lock()
+		// Keep any rewind by a filter which arrived while we were waiting.
+		if g.rewinds[peer] == rewinds && version > g.acked[peer] {
+			g.acked[peer] = version
+		}
+		delete(g.inflight, peer)
+		behind := g.acked[peer] < g.state.Version()
+		g.mu.Unlock()
+
+		// Send anything that arrived while we were waiting right away.
+		if behind {
+			g.Kick()
+		}
+		return nil
+	})
+}
+
+// handle merges a delta from a peer.
+func (g *Gossip) handle(msg maelstrom.Message) error {
+	var body gossipMessage
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	before, after, err := g.state.Merge(body.Delta)
+	if err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	// If the sender had everything we had before, it also has everything we
+	// just learned from it, so there's no need to send it back.
+	if g.acked[msg.Src] >= before {
+		g.acked[msg.Src] = after
+	}
+	g.mu.Unlock()
+
+	return g.node.Reply(msg, map[string]any{"type": g.cfg.Type + "_ok"})
+}
+
+// handleSummary records a peer's latest filter, and rewinds to the first
+// update skipped by the previous one, so that any false positives are sent.
+func (g *Gossip) handleSummary(msg maelstrom.Message) error {
+	var body summaryMessage
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	if g.summarizer == nil || body.Filter == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.summaries[msg.Src] = body.Filter
+	if since, ok := g.skipped[msg.Src]; ok {
+		g.acked[msg.Src] = since
+		g.rewinds[msg.Src]++
+		delete(g.skipped, msg.Src)
+	}
+	return nil
+}
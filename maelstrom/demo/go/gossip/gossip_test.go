@@ -0,0 +1,591 @@
+package gossip_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/gossip"
+	"github.com/jepsen-io/maelstrom/demo/go/membership"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+	"github.com/jepsen-io/maelstrom/demo/go/topology"
+)
+
+func TestMain(m *testing.M) {
+	// Nodes log every message they send and receive.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestGossip_Set(t *testing.T) {
+	t.Run("Converges", func(t *testing.T) {
+		c := newCluster(t, 5, gossipSet(gossip.Config{Interval: 10 * time.Millisecond}))
+		for i := 0; i < 20; i++ {
+			c.sets()[i%5].Add(i)
+		}
+		c.waitSets(t, 20)
+	})
+
+	t.Run("Batched", func(t *testing.T) {
+		c := newCluster(t, 5, gossipSet(gossip.Config{Interval: 10 * time.Millisecond, MaxBatch: 3}))
+		for i := 0; i < 50; i++ {
+			c.sets()[0].Add(i)
+		}
+		c.waitSets(t, 50)
+	})
+
+	t.Run("Fanout", func(t *testing.T) {
+		c := newCluster(t, 5, gossipSet(gossip.Config{Interval: 10 * time.Millisecond, Fanout: 1}))
+		c.setPeers(topology.Total(c.ids))
+		for i := 0; i < 20; i++ {
+			c.sets()[i%5].Add(i)
+		}
+		c.waitSets(t, 20)
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		c := newCluster(t, 5, gossipSet(gossip.Config{Interval: 10 * time.Millisecond, RetryAfter: 20 * time.Millisecond}))
+		c.net.Partition(c.ids[:2], c.ids[2:])
+		for i := 0; i < 20; i++ {
+			c.sets()[i%5].Add(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := c.sets()[0].Len(); n == 20 {
+			t.Fatalf("converged during partition")
+		}
+
+		c.net.Heal()
+		c.waitSets(t, 20)
+	})
+
+	t.Run("Routed", func(t *testing.T) {
+		// Isolating n2 cuts the line in two, unless the nodes next to it gossip
+		// with others in its place.
+		c := newCluster(t, 5, func(n *maelstrom.Node) (any, syncer) {
+			s := gossip.NewSet[int]()
+			g := gossip.New(n, s, gossip.Config{Interval: 10 * time.Millisecond, RetryAfter: 20 * time.Millisecond})
+			m := membership.New(n, membership.Config{
+				Interval:         20 * time.Millisecond,
+				Timeout:          8 * time.Millisecond,
+				SuspicionTimeout: 60 * time.Millisecond,
+			})
+			g.SetRouter(m)
+			return s, routed{g, m}
+		})
+		c.net.Partition([]string{"n2"}, []string{"n0", "n1", "n3", "n4"})
+		for i := 0; i < 20; i++ {
+			c.sets()[[]int{0, 4}[i%2]].Add(i)
+		}
+
+		waitFor(t, func() bool {
+			for i, s := range c.sets() {
+				if i != 2 && s.Len() != 20 {
+					return false
+				}
+			}
+			return true
+		})
+		c.net.Heal()
+		c.waitSets(t, 20)
+	})
+
+	t.Run("Summaries", func(t *testing.T) {
+		// Partition n0 from n1, so that n1 learns everything through n2, and
+		// count the elements received once the partition heals.
+		resent := func(cfg gossip.Config) int64 {
+			c := newCluster(t, 3, countingSet(cfg))
+			c.setPeers(topology.Total(c.ids))
+			c.net.Partition([]string{"n0"}, []string{"n1"})
+			for i := 0; i < 200; i++ {
+				c.sets()[0].Add(i)
+			}
+			c.waitSets(t, 200)
+			before := c.quiesce()
+
+			c.net.Heal()
+			return c.quiesce() - before
+		}
+
+		cfg := gossip.Config{Interval: 10 * time.Millisecond, RetryAfter: 20 * time.Millisecond}
+		if got := resent(cfg); got < 200 {
+			t.Fatalf("resent %d elements without summaries, want at least 200", got)
+		}
+		cfg.SummaryInterval = 10 * time.Millisecond
+		if got := resent(cfg); got > 20 {
+			t.Fatalf("resent %d elements with summaries, want at most 20", got)
+		}
+	})
+
+	t.Run("FalsePositives", func(t *testing.T) {
+		// Half the elements a peer lacks are skipped at first, and must be
+		// checked against later filters.
+		c := newCluster(t, 3, gossipSet(gossip.Config{
+			Interval:          10 * time.Millisecond,
+			SummaryInterval:   10 * time.Millisecond,
+			FalsePositiveRate: 0.5,
+		}))
+		for i := 0; i < 300; i++ {
+			c.sets()[i%3].Add(i)
+			if i%30 == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		c.waitSets(t, 300)
+	})
+
+	t.Run("Quiescent", func(t *testing.T) {
+		c := newCluster(t, 5, gossipSet(gossip.Config{Interval: 10 * time.Millisecond}))
+		for i := 0; i < 20; i++ {
+			c.sets()[i%5].Add(i)
+		}
+		c.waitSets(t, 20)
+
+		// Once every peer has acknowledged everything, rounds send nothing.
+		time.Sleep(50 * time.Millisecond)
+		before := c.net.Stats().Servers
+		time.Sleep(50 * time.Millisecond)
+		if got := c.net.Stats().Servers; got != before {
+			t.Fatalf("sent %d messages after converging", got-before)
+		}
+	})
+}
+
+func TestGossip_Map(t *testing.T) {
+	c := newCluster(t, 3, func(n *maelstrom.Node) (any, syncer) {
+		m := gossip.NewMap[string, int](gossip.Max[int])
+		return m, gossip.New(n, m, gossip.Config{Interval: 10 * time.Millisecond})
+	})
+
+	// Each node counts its own increments, as in a grow-only counter.
+	for i, state := range c.states {
+		m := state.(*gossip.Map[string, int])
+		for j := 1; j <= 10; j++ {
+			m.Update(c.ids[i], j*(i+1))
+		}
+	}
+
+	waitFor(t, func() bool {
+		for _, state := range c.states {
+			values := state.(*gossip.Map[string, int]).Values()
+			if values["n0"] != 10 || values["n1"] != 20 || values["n2"] != 30 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestDigest(t *testing.T) {
+	digestSet := func(n *maelstrom.Node) (any, syncer) {
+		s := gossip.NewMerkleSet[int]()
+		return s, gossip.NewDigest(n, s, gossip.DigestConfig{Interval: 10 * time.Millisecond, RetryAfter: time.Second})
+	}
+
+	t.Run("Converges", func(t *testing.T) {
+		c := newCluster(t, 5, digestSet)
+		for i := 0; i < 200; i++ {
+			c.sets()[i%5].Add(i)
+		}
+		c.waitSets(t, 200)
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		c := newCluster(t, 5, digestSet)
+		c.net.Partition(c.ids[:2], c.ids[2:])
+		for i := 0; i < 20; i++ {
+			c.sets()[i%5].Add(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := c.sets()[0].Len(); n == 20 {
+			t.Fatalf("converged during partition")
+		}
+
+		c.net.Heal()
+		c.waitSets(t, 20)
+	})
+
+	t.Run("Quiescent", func(t *testing.T) {
+		c := newCluster(t, 2, digestSet)
+		for i := 0; i < 1000; i++ {
+			c.sets()[i%2].Add(i)
+		}
+		c.waitSets(t, 1000)
+
+		// Once the sets are equal, each round is a single root hash and reply
+		// per peer, in each direction. There are at most ten rounds in 100ms,
+		// plus any kicked by the last merges.
+		time.Sleep(50 * time.Millisecond)
+		before := c.net.Stats().Servers
+		time.Sleep(100 * time.Millisecond)
+		if n := c.net.Stats().Servers - before; n > 4*15 {
+			t.Fatalf("sent %d messages in ten rounds", n)
+		}
+	})
+}
+
+func TestPlumtree(t *testing.T) {
+	plumtreeSet := func(n *maelstrom.Node) (any, syncer) {
+		s := gossip.NewSet[int]()
+		return s, gossip.NewPlumtree(n, s, gossip.PlumtreeConfig{Interval: 10 * time.Millisecond, GraftTimeout: 20 * time.Millisecond})
+	}
+
+	t.Run("Converges", func(t *testing.T) {
+		c := newCluster(t, 5, plumtreeSet)
+		c.setPeers(topology.Total(c.ids))
+		for i := 0; i < 20; i++ {
+			c.sets()[i%5].Add(i)
+			c.syncers[i%5].(*gossip.Plumtree[int]).Kick()
+		}
+		c.waitSets(t, 20)
+	})
+
+	t.Run("Tree", func(t *testing.T) {
+		c := newCluster(t, 8, plumtreeSet)
+		c.setPeers(topology.Total(c.ids))
+		p := c.syncers[0].(*gossip.Plumtree[int])
+
+		// Every link to a node which received an element twice is pruned,
+		// leaving a tree: 7 links, each eager in both directions. Slow
+		// pushes may cause grafts, which add links back, so keep
+		// broadcasting until they're pruned again.
+		i := 0
+		waitFor(t, func() bool {
+			c.sets()[0].Add(i)
+			p.Kick()
+			i++
+			c.waitSets(t, i)
+
+			links := 0
+			for _, s := range c.syncers {
+				links += len(s.(*gossip.Plumtree[int]).Eager("n0"))
+			}
+			return links == 2*7
+		})
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		c := newCluster(t, 5, plumtreeSet)
+		c.net.Partition(c.ids[:2], c.ids[2:])
+		for i := 0; i < 20; i++ {
+			c.sets()[i%5].Add(i)
+			c.syncers[i%5].(*gossip.Plumtree[int]).Kick()
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := c.sets()[0].Len(); n == 20 {
+			t.Fatalf("converged during partition")
+		}
+
+		c.net.Heal()
+		c.waitSets(t, 20)
+	})
+
+	t.Run("Quiescent", func(t *testing.T) {
+		c := newCluster(t, 5, plumtreeSet)
+		for i := 0; i < 20; i++ {
+			c.sets()[i%5].Add(i)
+			c.syncers[i%5].(*gossip.Plumtree[int]).Kick()
+		}
+		c.waitSets(t, 20)
+
+		// Once every announcement is acknowledged, rounds send nothing.
+		time.Sleep(50 * time.Millisecond)
+		before := c.net.Stats().Servers
+		time.Sleep(50 * time.Millisecond)
+		if got := c.net.Stats().Servers; got != before {
+			t.Fatalf("sent %d messages after converging", got-before)
+		}
+	})
+}
+
+func TestBloom(t *testing.T) {
+	f := gossip.NewBloom(1000, 0.01, 1)
+	for i := 0; i < 1000; i++ {
+		f.Add(i)
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Contains(i) {
+			t.Fatalf("missing %d", i)
+		}
+	}
+
+	// Allow for some variance around the 1% target.
+	var fp int
+	for i := 1000; i < 11000; i++ {
+		if f.Contains(i) {
+			fp++
+		}
+	}
+	if fp > 200 {
+		t.Fatalf("false positives=%d of 10000, want about 100", fp)
+	}
+}
+
+func TestMerkleSet(t *testing.T) {
+	a, b := gossip.NewMerkleSet[int](), gossip.NewMerkleSet[int]()
+	for i := 0; i < 100; i++ {
+		a.Add(i)
+		b.Add(99 - i)
+	}
+	if a.Root() != b.Root() {
+		t.Fatal("equal sets have different roots")
+	}
+
+	b.Add(100)
+	if a.Root() == b.Root() {
+		t.Fatal("different sets have equal roots")
+	}
+	if got, want := a.Merge([]int{1, 100, 101}), 2; got != want {
+		t.Fatalf("merged=%v, want %v", got, want)
+	}
+}
+
+func TestMap(t *testing.T) {
+	m := gossip.NewMap[string, int](gossip.Max[int])
+	if !m.Update("a", 1) || !m.Update("b", 1) || !m.Update("a", 2) {
+		t.Fatal("expected updates")
+	}
+	if m.Update("a", 1) {
+		t.Fatal("expected no-op update")
+	}
+	if got, want := m.Version(), 3; got != want {
+		t.Fatalf("version=%v, want %v", got, want)
+	}
+
+	// Only the latest value of each key is sent, in order of last change.
+	delta, version := m.Delta(0, 0)
+	if got, want := fmt.Sprint(delta), "[{b 1} {a 2}]"; got != want {
+		t.Fatalf("delta=%v, want %v", got, want)
+	} else if version != 3 {
+		t.Fatalf("version=%v, want %v", version, 3)
+	}
+
+	delta, version = m.Delta(0, 1)
+	if got, want := fmt.Sprint(delta), "[{b 1}]"; got != want {
+		t.Fatalf("delta=%v, want %v", got, want)
+	} else if version != 2 {
+		t.Fatalf("version=%v, want %v", version, 2)
+	}
+
+	before, after, err := m.Merge([]byte(`[{"key":"a","value":1},{"key":"c","value":5}]`))
+	if err != nil {
+		t.Fatal(err)
+	} else if before != 3 || after != 4 {
+		t.Fatalf("merge=%v..%v, want 3..4", before, after)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := gossip.NewSet[int]()
+	s.Add(1)
+	s.Add(2)
+	if s.Add(1) {
+		t.Fatal("expected duplicate add to fail")
+	}
+
+	before, after, err := s.Merge([]byte(`[2, 3, 4]`))
+	if err != nil {
+		t.Fatal(err)
+	} else if before != 2 || after != 4 {
+		t.Fatalf("merge=%v..%v, want 2..4", before, after)
+	}
+
+	delta, version := s.Delta(1, 2)
+	if got, want := fmt.Sprint(delta), "[2 3]"; got != want {
+		t.Fatalf("delta=%v, want %v", got, want)
+	} else if version != 3 {
+		t.Fatalf("version=%v, want %v", version, 3)
+	}
+
+	// Skipped elements don't count towards the limit.
+	f := gossip.NewBloom(2, 0.01, 1)
+	f.Add(2)
+	f.Add(3)
+	delta, n, version := s.DeltaExcept(0, 1, f)
+	if got, want := fmt.Sprint(delta), "[1]"; got != want {
+		t.Fatalf("delta=%v, want %v", got, want)
+	} else if n != 1 || version != 1 {
+		t.Fatalf("n=%v version=%v, want 1, 1", n, version)
+	}
+	delta, n, version = s.DeltaExcept(1, 1, f)
+	if got, want := fmt.Sprint(delta), "[4]"; got != want {
+		t.Fatalf("delta=%v, want %v", got, want)
+	} else if n != 1 || version != 4 {
+		t.Fatalf("n=%v version=%v, want 1, 4", n, version)
+	}
+}
+
+// syncer replicates state between nodes.
+type syncer interface {
+	SetPeers(peers []string)
+	Close()
+}
+
+// cluster is a set of nodes on a simulated network, each replicating its
+// state with a syncer.
+type cluster struct {
+	net     *sim.Network
+	ids     []string
+	states  []any
+	syncers []syncer
+}
+
+// gossipSet returns a setup function for newCluster which gossips a Set.
+func gossipSet(cfg gossip.Config) func(n *maelstrom.Node) (any, syncer) {
+	return func(n *maelstrom.Node) (any, syncer) {
+		s := gossip.NewSet[int]()
+		return s, gossip.New(n, s, cfg)
+	}
+}
+
+// routed is a syncer which routes around nodes its Membership finds
+// unreachable.
+type routed struct {
+	*gossip.Gossip
+	m *membership.Membership
+}
+
+func (r routed) Close() {
+	r.Gossip.Close()
+	r.m.Close()
+}
+
+// countedSet is a Set which counts the elements in the deltas it merges.
+type countedSet struct {
+	*gossip.Set[int]
+	received atomic.Int64
+}
+
+func (s *countedSet) Merge(delta json.RawMessage) (int, int, error) {
+	var elements []int
+	if err := json.Unmarshal(delta, &elements); err != nil {
+		return 0, 0, err
+	}
+	s.received.Add(int64(len(elements)))
+	return s.Set.Merge(delta)
+}
+
+// countingSet returns a setup function for newCluster which gossips a
+// countedSet.
+func countingSet(cfg gossip.Config) func(n *maelstrom.Node) (any, syncer) {
+	return func(n *maelstrom.Node) (any, syncer) {
+		s := &countedSet{Set: gossip.NewSet[int]()}
+		return s, gossip.New(n, s, cfg)
+	}
+}
+
+// received returns the number of elements received by every countedSet.
+func (c *cluster) received() int64 {
+	var n int64
+	for _, state := range c.states {
+		n += state.(*countedSet).received.Load()
+	}
+	return n
+}
+
+// quiesce waits until no countedSet has received anything for a while, and
+// returns the number of elements received.
+func (c *cluster) quiesce() int64 {
+	n := c.received()
+	for {
+		time.Sleep(50 * time.Millisecond)
+		if m := c.received(); m != n {
+			n = m
+			continue
+		}
+		return n
+	}
+}
+
+// newCluster starts n initialized nodes, connected in a line.
+func newCluster(tb testing.TB, n int, setup func(n *maelstrom.Node) (any, syncer)) *cluster {
+	tb.Helper()
+
+	c := &cluster{net: sim.NewNetwork()}
+	tb.Cleanup(func() { c.net.Close() })
+	for i := 0; i < n; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("n%d", i))
+	}
+
+	for _, id := range c.ids {
+		node := maelstrom.NewNode()
+		state, s := setup(node)
+		tb.Cleanup(s.Close)
+		c.net.AddNode(id, node)
+		c.states = append(c.states, state)
+		c.syncers = append(c.syncers, s)
+	}
+	c.setPeers(topology.Line(c.ids))
+
+	client := c.net.AddClient("c0")
+	for _, id := range c.ids {
+		if _, err := client.SyncRPC(context.Background(), id, maelstrom.InitMessageBody{
+			MessageBody: maelstrom.MessageBody{Type: "init"},
+			NodeID:      id,
+			NodeIDs:     c.ids,
+		}); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return c
+}
+
+func (c *cluster) setPeers(top topology.Topology) {
+	for i, s := range c.syncers {
+		s.SetPeers(top[c.ids[i]])
+	}
+}
+
+// intSet is implemented by Set[int] and MerkleSet[int].
+type intSet interface {
+	Add(v int) bool
+	Len() int
+}
+
+func (c *cluster) sets() []intSet {
+	sets := make([]intSet, len(c.states))
+	for i, state := range c.states {
+		sets[i] = state.(intSet)
+	}
+	return sets
+}
+
+// waitSets waits until every node's set has n elements.
+func (c *cluster) waitSets(tb testing.TB, n int) {
+	tb.Helper()
+	waitFor(tb, func() bool {
+		for _, s := range c.sets() {
+			if s.Len() != n {
+				return false
+			}
+		}
+		return true
+	}, func() string {
+		var lens []int
+		for _, s := range c.sets() {
+			lens = append(lens, s.Len())
+		}
+		return fmt.Sprintf("set sizes %v, want %d", lens, n)
+	})
+}
+
+// waitFor waits up to five seconds for cond to hold. If it does not, the test
+// fails with the result of status, if given.
+func waitFor(tb testing.TB, cond func() bool, status ...func() string) {
+	tb.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	for _, s := range status {
+		tb.Fatalf("timed out: %s", s())
+	}
+	tb.Fatal("timed out")
+}
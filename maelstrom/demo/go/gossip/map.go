@@ -0,0 +1,133 @@
+package gossip
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// Map is a map whose values are merged with a join function, such as max for
+// a grow-only counter with one entry per node. It is replicated by gossiping
+// the current value of each key which changed.
+type Map[K comparable, V any] struct {
+	join func(a, b V) (V, bool)
+
+	mu       sync.RWMutex
+	values   map[K]V
+	versions map[K]int // version of each key's last change
+	version  int
+}
+
+// NewMap returns an empty map. join combines an existing value with an
+// update, and reports whether the result differs from the existing value.
+// It must be commutative, associative and idempotent.
+func NewMap[K comparable, V any](join func(a, b V) (V, bool)) *Map[K, V] {
+	return &Map[K, V]{
+		join:     join,
+		values:   make(map[K]V),
+		versions: make(map[K]int),
+	}
+}
+
+// Update joins v into the value of k. Returns false if the value was
+// unchanged.
+func (m *Map[K, V]) Update(k K, v V) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.update(k, v)
+}
+
+func (m *Map[K, V]) update(k K, v V) bool {
+	if old, ok := m.values[k]; ok {
+		var changed bool
+		if v, changed = m.join(old, v); !changed {
+			return false
+		}
+	}
+	m.version++
+	m.values[k] = v
+	m.versions[k] = m.version
+	return true
+}
+
+// Get returns the value of k.
+func (m *Map[K, V]) Get(k K) (V, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.values[k]
+	return v, ok
+}
+
+// Values returns a copy of the map.
+func (m *Map[K, V]) Values() map[K]V {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	values := make(map[K]V, len(m.values))
+	for k, v := range m.values {
+		values[k] = v
+	}
+	return values
+}
+
+// Version returns the number of changes made to the map.
+func (m *Map[K, V]) Version() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.version
+}
+
+// mapEntry is a single key and value in a delta.
+type mapEntry[K comparable, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+// Delta returns the keys which changed after version since, in the order of
+// their last change, with their current values.
+func (m *Map[K, V]) Delta(since, limit int) (any, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []K
+	for k, v := range m.versions {
+		if v > since {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return m.versions[keys[i]] < m.versions[keys[j]] })
+
+	version := m.version
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		version = m.versions[keys[limit-1]]
+	}
+	entries := make([]mapEntry[K, V], len(keys))
+	for i, k := range keys {
+		entries[i] = mapEntry[K, V]{k, m.values[k]}
+	}
+	return entries, version
+}
+
+// Merge joins the entries in a delta into the map.
+func (m *Map[K, V]) Merge(delta json.RawMessage) (int, int, error) {
+	var entries []mapEntry[K, V]
+	if err := json.Unmarshal(delta, &entries); err != nil {
+		return 0, 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := m.version
+	for _, e := range entries {
+		m.update(e.Key, e.Value)
+	}
+	return before, m.version, nil
+}
+
+// Max joins two ordered values by taking the larger, for use with NewMap.
+func Max[V int | int64 | float64](a, b V) (V, bool) {
+	if b > a {
+		return b, true
+	}
+	return a, false
+}
@@ -0,0 +1,187 @@
+package gossip
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+)
+
+// Shape of the Merkle tree: each internal node has merkleFanout children, and
+// leaves are merkleDepth levels below the root.
+const (
+	merkleFanout = 16
+	merkleBits   = 4 // log2(merkleFanout)
+	merkleDepth  = 2
+)
+
+// MerkleSet is a grow-only set which maintains a Merkle tree over its
+// elements, so that two replicas can find their differences by exchanging
+// hashes rather than elements. See Digest.
+//
+// Elements are placed in leaves by the high bits of their hash, and each node
+// in the tree stores the sum of the hashes of the elements below it, so the
+// tree can be updated in place as elements are added.
+type MerkleSet[T comparable] struct {
+	mu       sync.RWMutex
+	elements map[T]struct{}
+	log      []T
+	leaves   map[int][]T
+	levels   [merkleDepth + 1][]uint64
+}
+
+// NewMerkleSet returns an empty set.
+func NewMerkleSet[T comparable]() *MerkleSet[T] {
+	s := &MerkleSet[T]{
+		elements: make(map[T]struct{}),
+		leaves:   make(map[int][]T),
+	}
+	for d := range s.levels {
+		s.levels[d] = make([]uint64, 1<<(merkleBits*d))
+	}
+	return s
+}
+
+// Add adds v to the set. Returns false if it was already present.
+func (s *MerkleSet[T]) Add(v T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(v)
+}
+
+func (s *MerkleSet[T]) add(v T) bool {
+	if _, ok := s.elements[v]; ok {
+		return false
+	}
+	s.elements[v] = struct{}{}
+	s.log = append(s.log, v)
+
+	h := merkleHash(v)
+	leaf := merkleIndex(h, merkleDepth)
+	s.leaves[leaf] = append(s.leaves[leaf], v)
+	for d := range s.levels {
+		s.levels[d][merkleIndex(h, d)] += h
+	}
+	return true
+}
+
+// Merge adds elements to the set, and returns the number which were new.
+func (s *MerkleSet[T]) Merge(elements []T) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := 0
+	for _, v := range elements {
+		if s.add(v) {
+			added++
+		}
+	}
+	return added
+}
+
+// Contains reports whether v is in the set.
+func (s *MerkleSet[T]) Contains(v T) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.elements[v]
+	return ok
+}
+
+// Values returns the elements of the set, in the order they were added.
+func (s *MerkleSet[T]) Values() []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]T{}, s.log...)
+}
+
+// Len returns the number of elements in the set.
+func (s *MerkleSet[T]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.log)
+}
+
+// Root returns the hash of the whole set.
+func (s *MerkleSet[T]) Root() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.levels[0][0]
+}
+
+// merkleNode is the hash of a node in the tree, identified by its depth and
+// its index within that level.
+type merkleNode struct {
+	Index int    `json:"index"`
+	Hash  uint64 `json:"hash,string"`
+}
+
+// diff returns the nodes at the given depth which differ from ours.
+func (s *MerkleSet[T]) diff(depth int, nodes []merkleNode) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var diff []int
+	for _, node := range nodes {
+		if node.Index < 0 || node.Index >= len(s.levels[depth]) {
+			continue
+		}
+		if s.levels[depth][node.Index] != node.Hash {
+			diff = append(diff, node.Index)
+		}
+	}
+	return diff
+}
+
+// hashes returns our hashes of the given nodes at depth.
+func (s *MerkleSet[T]) hashes(depth int, indexes []int) []merkleNode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	nodes := make([]merkleNode, len(indexes))
+	for i, index := range indexes {
+		nodes[i] = merkleNode{index, s.levels[depth][index]}
+	}
+	return nodes
+}
+
+// children returns our hashes of the children of the given nodes.
+func (s *MerkleSet[T]) children(depth int, indexes []int) []merkleNode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var children []merkleNode
+	for _, i := range indexes {
+		for c := i * merkleFanout; c < (i+1)*merkleFanout; c++ {
+			children = append(children, merkleNode{c, s.levels[depth+1][c]})
+		}
+	}
+	return children
+}
+
+// leafElements returns our elements in the given leaves.
+func (s *MerkleSet[T]) leafElements(leaves []int) []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	elements := []T{}
+	for _, i := range leaves {
+		elements = append(elements, s.leaves[i]...)
+	}
+	return elements
+}
+
+// merkleHash hashes an element by its JSON encoding.
+func merkleHash(v any) uint64 {
+	buf, _ := json.Marshal(v)
+	h := fnv.New64a()
+	h.Write(buf)
+
+	// FNV's high bits are poorly distributed for short inputs, so finish with
+	// a mixing step (from SplitMix64) before using them to choose a leaf.
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// merkleIndex returns the index of the node at depth containing hash h.
+func merkleIndex(h uint64, depth int) int {
+	if depth == 0 {
+		return 0
+	}
+	return int(h >> (64 - merkleBits*depth))
+}
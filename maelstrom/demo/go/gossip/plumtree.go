@@ -0,0 +1,392 @@
+package gossip
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// PlumtreeConfig controls a Plumtree.
+type PlumtreeConfig struct {
+	// Type is the message type used to push elements. Announcements, grafts
+	// and prunes are of type Type + "_ihave", Type + "_graft" and
+	// Type + "_prune". Defaults to "plumtree".
+	Type string
+
+	// Interval is the time between announcements to each peer. Defaults to
+	// 500ms.
+	Interval time.Duration
+
+	// GraftTimeout is how long to wait for an announced element to arrive
+	// along the tree before asking the peer which announced it to send it.
+	// Defaults to 250ms.
+	GraftTimeout time.Duration
+
+	// RetryAfter is how long to wait for an acknowledgement before resending
+	// an announcement. Defaults to twice Interval.
+	RetryAfter time.Duration
+
+	// MaxBatch is the maximum number of elements in a single announcement.
+	// Unlimited if zero.
+	MaxBatch int
+}
+
+// Plumtree replicates a Set along spanning trees of a node's peers, using the
+// epidemic broadcast trees of Leitão, Pereira and Rodrigues.
+//
+// Elements are pushed along a separate tree for each root, the node which
+// first added them, as in riak_core's implementation: a single shared tree
+// is torn apart when several nodes broadcast at once. For each root, each
+// peer is either eager, and is pushed new elements as soon as they arrive,
+// or lazy. Every peer starts eager. When a node is pushed elements it already
+// has, there must be more than one eager path to it from the root, so it
+// prunes the link to the sender, making each lazy for the other. What remains
+// is the tree of the fastest paths from the root, over which each element
+// costs one message per node.
+//
+// Pushes are not acknowledged. Instead, each node periodically announces the
+// elements it has to every peer, eager or lazy, until they are acknowledged.
+// When an element is announced which doesn't arrive along the tree in time,
+// the tree has been broken, e.g. by a partition or a lost message, so the
+// node grafts the link to the peer which announced it back into the tree, and
+// asks it for the element.
+type Plumtree[T comparable] struct {
+	*rounds
+	set *Set[T]
+	cfg PlumtreeConfig
+
+	mu      sync.Mutex
+	pushed  int // the version of the set pushed to eager peers
+	peers   map[string]*plumtreePeer
+	origin  map[T]string // the peer each element was first received from
+	root    map[T]string // the node which first added each element
+	missing map[T]*plumtreeMissing
+}
+
+// plumtreePeer is the state of the link to a peer.
+type plumtreePeer struct {
+	lazy map[string]bool // by root
+
+	// acked is the version of the set announced to the peer and
+	// acknowledged.
+	acked     int
+	announced time.Time
+	inflight  bool
+}
+
+// plumtreeMissing is an element which a peer announced, but which has not
+// arrived.
+type plumtreeMissing struct {
+	root  string
+	from  string
+	since time.Time
+}
+
+// plumtreeMessage is a message between peers. Elements are grouped by root.
+type plumtreeMessage[T comparable] struct {
+	Type     string         `json:"type"`
+	Elements map[string][]T `json:"elements,omitempty"`
+	Roots    []string       `json:"roots,omitempty"`
+}
+
+// NewPlumtree returns a Plumtree which replicates set between n and its
+// peers, and registers handlers for its messages on n. Rounds begin
+// immediately, and continue until Close is called. Until SetPeers is called,
+// every other node in the cluster is a peer.
+func NewPlumtree[T comparable](n *maelstrom.Node, set *Set[T], cfg PlumtreeConfig) *Plumtree[T] {
+	if cfg.Type == "" {
+		cfg.Type = "plumtree"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 500 * time.Millisecond
+	}
+	if cfg.GraftTimeout <= 0 {
+		cfg.GraftTimeout = 250 * time.Millisecond
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = 2 * cfg.Interval
+	}
+
+	p := &Plumtree[T]{
+		rounds:  newRounds(n, cfg.Interval),
+		set:     set,
+		cfg:     cfg,
+		peers:   make(map[string]*plumtreePeer),
+		origin:  make(map[T]string),
+		root:    make(map[T]string),
+		missing: make(map[T]*plumtreeMissing),
+	}
+	n.Handle(cfg.Type, p.handlePush)
+	n.Handle(cfg.Type+"_ihave", p.handleIHave)
+	n.Handle(cfg.Type+"_graft", p.handleGraft)
+	n.Handle(cfg.Type+"_prune", p.handlePrune)
+	go p.run(p.round)
+	return p
+}
+
+// Eager returns the peers which are currently pushed elements added by root,
+// i.e. the node's links in root's tree.
+func (p *Plumtree[T]) Eager(root string) []string {
+	peers := p.peerList()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var eager []string
+	for _, id := range peers {
+		if !p.peer(id).lazy[root] {
+			eager = append(eager, id)
+		}
+	}
+	return eager
+}
+
+// round pushes new elements to eager peers, grafts links for missing
+// elements, and announces elements to peers which are due an announcement.
+// Rounds are kicked whenever elements are added, so pushes are immediate.
+func (p *Plumtree[T]) round(peers []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.push(peers)
+
+	now := time.Now()
+	grafts := make(map[string]map[string][]T)
+	for v, m := range p.missing {
+		if now.Sub(m.since) < p.cfg.GraftTimeout {
+			continue
+		}
+		if grafts[m.from] == nil {
+			grafts[m.from] = make(map[string][]T)
+		}
+		grafts[m.from][m.root] = append(grafts[m.from][m.root], v)
+		m.since = now
+	}
+	for peer, elements := range grafts {
+		p.graft(peer, elements)
+	}
+
+	for _, id := range peers {
+		ps := p.peer(id)
+		wait := p.cfg.Interval
+		if ps.inflight {
+			wait = p.cfg.RetryAfter
+		}
+		if now.Sub(ps.announced) >= wait {
+			p.announce(id, ps)
+		}
+	}
+}
+
+// push sends elements added since the last push to the peers which are
+// eager for their roots, except the peer each came from. Must be called with
+// the lock held.
+func (p *Plumtree[T]) push(peers []string) {
+	elements := p.set.since(p.pushed)
+	p.pushed += len(elements)
+	for _, v := range elements {
+		if _, ok := p.root[v]; !ok {
+			p.root[v] = p.node.ID() // added locally
+		}
+	}
+
+	for _, id := range peers {
+		ps := p.peer(id)
+		batch := make(map[string][]T)
+		for _, v := range elements {
+			if root := p.root[v]; p.origin[v] != id && !ps.lazy[root] {
+				batch[root] = append(batch[root], v)
+			}
+		}
+		if len(batch) > 0 {
+			p.node.Send(id, plumtreeMessage[T]{Type: p.cfg.Type, Elements: batch})
+		}
+	}
+}
+
+// announce sends a peer the elements it hasn't acknowledged, except those it
+// sent us. Must be called with the lock held.
+func (p *Plumtree[T]) announce(peer string, ps *plumtreePeer) {
+	from := ps.acked
+	elements := p.set.since(from)
+	if p.cfg.MaxBatch > 0 && len(elements) > p.cfg.MaxBatch {
+		elements = elements[:p.cfg.MaxBatch]
+	}
+	end := from + len(elements)
+
+	ihave := make(map[string][]T)
+	for _, v := range elements {
+		if p.origin[v] != peer {
+			ihave[p.rootOf(v)] = append(ihave[p.rootOf(v)], v)
+		}
+	}
+	if len(ihave) == 0 {
+		ps.acked = end
+		return
+	}
+
+	ps.announced, ps.inflight = time.Now(), true
+	p.node.RPC(peer, plumtreeMessage[T]{Type: p.cfg.Type + "_ihave", Elements: ihave}, func(msg maelstrom.Message) error {
+		if msg.RPCError() != nil {
+			return nil // resent after RetryAfter
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		ps := p.peer(peer)
+		if end > ps.acked {
+			ps.acked = end
+		}
+		ps.inflight = false
+		return nil
+	})
+}
+
+// graft makes the link to a peer eager for the roots of missing elements, and
+// asks it for them. Must be called with the lock held.
+func (p *Plumtree[T]) graft(peer string, elements map[string][]T) {
+	ps := p.peer(peer)
+	for root := range elements {
+		delete(ps.lazy, root)
+	}
+	p.node.RPC(peer, plumtreeMessage[T]{Type: p.cfg.Type + "_graft", Elements: elements}, func(msg maelstrom.Message) error {
+		if msg.RPCError() != nil {
+			return nil // grafted again after GraftTimeout
+		}
+		var resp plumtreeMessage[T]
+		if err := json.Unmarshal(msg.Body, &resp); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.receive(peer, resp.Elements)
+		return nil
+	})
+}
+
+// receive merges elements from a peer, and returns the roots for which none
+// were new. Must be called with the lock held.
+func (p *Plumtree[T]) receive(src string, elements map[string][]T) []string {
+	var stale []string
+	for root, elements := range elements {
+		added := p.set.merge(elements)
+		for _, v := range added {
+			p.origin[v] = src
+			p.root[v] = root
+			delete(p.missing, v)
+		}
+		if len(added) > 0 {
+			p.Kick()
+		} else {
+			stale = append(stale, root)
+		}
+	}
+	return stale
+}
+
+// rootOf returns the root of an element. Must be called with the lock held.
+func (p *Plumtree[T]) rootOf(v T) string {
+	if root, ok := p.root[v]; ok {
+		return root
+	}
+	return p.node.ID()
+}
+
+// handlePush merges elements pushed by a peer. For each root of which none of
+// the elements are new, the link to the peer is pruned.
+func (p *Plumtree[T]) handlePush(msg maelstrom.Message) error {
+	var req plumtreeMessage[T]
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stale := p.receive(msg.Src, req.Elements)
+	if len(stale) == 0 {
+		return nil
+	}
+	ps := p.peer(msg.Src)
+	for _, root := range stale {
+		ps.lazy[root] = true
+	}
+	return p.node.Send(msg.Src, plumtreeMessage[T]{Type: p.cfg.Type + "_prune", Roots: stale})
+}
+
+// handleIHave notes elements a peer has announced, so that they can be
+// requested from it if they don't arrive.
+func (p *Plumtree[T]) handleIHave(msg maelstrom.Message) error {
+	var req plumtreeMessage[T]
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	for root, elements := range req.Elements {
+		for _, v := range elements {
+			if p.set.Contains(v) {
+				continue
+			}
+			if m, ok := p.missing[v]; ok {
+				// Ask whoever announced it last, in case the first is
+				// unreachable.
+				m.from = msg.Src
+				continue
+			}
+			p.missing[v] = &plumtreeMissing{root: root, from: msg.Src, since: time.Now()}
+		}
+	}
+	p.mu.Unlock()
+
+	return p.node.Reply(msg, map[string]any{"type": p.cfg.Type + "_ihave_ok"})
+}
+
+// handleGraft makes the link to a peer eager for the roots of the elements
+// it asks for, and replies with those elements.
+func (p *Plumtree[T]) handleGraft(msg maelstrom.Message) error {
+	var req plumtreeMessage[T]
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+
+	resp := plumtreeMessage[T]{Type: p.cfg.Type + "_graft_ok", Elements: make(map[string][]T)}
+	p.mu.Lock()
+	ps := p.peer(msg.Src)
+	for root, elements := range req.Elements {
+		delete(ps.lazy, root)
+		for _, v := range elements {
+			if p.set.Contains(v) {
+				resp.Elements[root] = append(resp.Elements[root], v)
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	return p.node.Reply(msg, resp)
+}
+
+// handlePrune makes the link to a peer lazy for the given roots.
+func (p *Plumtree[T]) handlePrune(msg maelstrom.Message) error {
+	var req plumtreeMessage[T]
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ps := p.peer(msg.Src)
+	for _, root := range req.Roots {
+		ps.lazy[root] = true
+	}
+	return nil
+}
+
+// peer returns the state of the link to a peer. Must be called with the lock
+// held.
+func (p *Plumtree[T]) peer(id string) *plumtreePeer {
+	ps, ok := p.peers[id]
+	if !ok {
+		ps = &plumtreePeer{lazy: make(map[string]bool)}
+		p.peers[id] = ps
+	}
+	return ps
+}
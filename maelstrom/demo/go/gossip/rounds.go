@@ -0,0 +1,118 @@
+package gossip
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Router chooses the peers to contact in each round, given those set by
+// SetPeers, e.g. to route around unreachable nodes. *membership.Membership
+// is a Router.
+type Router interface {
+	Route(peers []string) []string
+}
+
+// rounds calls a function periodically, or on demand, with a set of peers to
+// contact. It is shared by the replication strategies in this package.
+type rounds struct {
+	node     *maelstrom.Node
+	interval time.Duration
+
+	mu     sync.Mutex
+	peers  []string
+	router Router
+	rand   *rand.Rand
+
+	kick chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newRounds(n *maelstrom.Node, interval time.Duration) *rounds {
+	return &rounds{
+		node:     n,
+		interval: interval,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// SetPeers sets the nodes to gossip with, e.g. from a topology message.
+func (r *rounds) SetPeers(peers []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = append([]string(nil), peers...)
+}
+
+// SetRouter sets a Router to choose the peers for each round.
+func (r *rounds) SetRouter(router Router) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.router = router
+}
+
+// Kick starts a round immediately, e.g. after a local update.
+func (r *rounds) Kick() {
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops gossiping.
+func (r *rounds) Close() {
+	r.once.Do(func() { close(r.done) })
+}
+
+// run calls round every interval, and whenever kicked, until closed. Rounds
+// are skipped until the node is initialized.
+func (r *rounds) run(round func(peers []string)) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.kick:
+		case <-ticker.C:
+		}
+		if r.node.ID() != "" {
+			round(r.peerList())
+		}
+	}
+}
+
+// peerList returns the peers set by SetPeers, or every other node if none
+// were set, as routed by the Router, if any.
+func (r *rounds) peerList() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	peers := r.peers
+	if peers == nil {
+		for _, id := range r.node.NodeIDs() {
+			if id != r.node.ID() {
+				peers = append(peers, id)
+			}
+		}
+	}
+	if r.router != nil {
+		peers = r.router.Route(peers)
+	}
+	return peers
+}
+
+// sample returns up to n of peers at random, or all of them if n is zero.
+func (r *rounds) sample(peers []string, n int) []string {
+	if n <= 0 || len(peers) <= n {
+		return peers
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	peers = append([]string(nil), peers...)
+	r.rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	return peers[:n]
+}
@@ -0,0 +1,131 @@
+package gossip
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Set is a grow-only set, replicated by gossiping newly added elements. Its
+// version is the number of elements it contains.
+type Set[T comparable] struct {
+	mu       sync.RWMutex
+	elements map[T]struct{}
+	log      []T // elements in the order they were added
+}
+
+// NewSet returns an empty set.
+func NewSet[T comparable]() *Set[T] {
+	return &Set[T]{elements: make(map[T]struct{})}
+}
+
+// Add adds v to the set. Returns false if it was already present.
+func (s *Set[T]) Add(v T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(v)
+}
+
+func (s *Set[T]) add(v T) bool {
+	if _, ok := s.elements[v]; ok {
+		return false
+	}
+	s.elements[v] = struct{}{}
+	s.log = append(s.log, v)
+	return true
+}
+
+// Contains reports whether v is in the set.
+func (s *Set[T]) Contains(v T) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.elements[v]
+	return ok
+}
+
+// Values returns the elements of the set, in the order they were added.
+func (s *Set[T]) Values() []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]T{}, s.log...)
+}
+
+// Len returns the number of elements in the set.
+func (s *Set[T]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.log)
+}
+
+// Version returns the number of elements in the set.
+func (s *Set[T]) Version() int { return s.Len() }
+
+// Delta returns the elements added after the first since.
+func (s *Set[T]) Delta(since, limit int) (any, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	end := len(s.log)
+	if limit > 0 && since+limit < end {
+		end = since + limit
+	}
+	return append([]T{}, s.log[since:end]...), end
+}
+
+// Summarize adds every element of the set to f.
+func (s *Set[T]) Summarize(f *Bloom) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.log {
+		f.Add(v)
+	}
+}
+
+// DeltaExcept is like Delta, but skips the elements which f contains, and
+// limits the number of elements returned rather than examined.
+func (s *Set[T]) DeltaExcept(since, limit int, f *Bloom) (any, int, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	elements := []T{}
+	end := since
+	for ; end < len(s.log) && (limit <= 0 || len(elements) < limit); end++ {
+		if !f.Contains(s.log[end]) {
+			elements = append(elements, s.log[end])
+		}
+	}
+	return elements, len(elements), end
+}
+
+// since returns the elements added after the first i.
+func (s *Set[T]) since(i int) []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]T{}, s.log[i:]...)
+}
+
+// merge adds elements, and returns those which were not already present.
+func (s *Set[T]) merge(elements []T) []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var added []T
+	for _, v := range elements {
+		if s.add(v) {
+			added = append(added, v)
+		}
+	}
+	return added
+}
+
+// Merge adds the elements in a delta.
+func (s *Set[T]) Merge(delta json.RawMessage) (int, int, error) {
+	var elements []T
+	if err := json.Unmarshal(delta, &elements); err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.log)
+	for _, v := range elements {
+		s.add(v)
+	}
+	return before, len(s.log), nil
+}
@@ -3,6 +3,9 @@ package maelstrom
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
 )
 
 // Types of key/value stores.
@@ -16,6 +19,10 @@ const (
 type KV struct {
 	typ  string
 	node *Node
+
+	syncMu    sync.Mutex
+	syncToken string // last token written by Sync, if known
+	syncs     int
 }
 
 // NewKV returns a new instance a KV client for a node.
@@ -94,6 +101,67 @@ func (kv *KV) CompareAndSwap(ctx context.Context, key string, from, to any, crea
 	return err
 }
 
+// ReadFresh is like Read, but calls Sync first, so that it returns a value at
+// least as recent as any write which completed before it was called.
+func (kv *KV) ReadFresh(ctx context.Context, key string) (any, error) {
+	if err := kv.Sync(ctx); err != nil {
+		return nil, err
+	}
+	return kv.Read(ctx, key)
+}
+
+// ReadFreshInt is like ReadInt, but calls Sync first.
+func (kv *KV) ReadFreshInt(ctx context.Context, key string) (int, error) {
+	if err := kv.Sync(ctx); err != nil {
+		return 0, err
+	}
+	return kv.ReadInt(ctx, key)
+}
+
+// Sync waits until the store will serve this node's reads from a state at
+// least as recent as every write which completed before Sync was called.
+//
+// seq-kv only promises that each node sees the store's operations in some
+// order consistent with its own, so it may serve arbitrarily old reads to a
+// node which hasn't written anything recently. Sync replaces the value of a
+// key only this node writes, "sync-" followed by the node's ID, with a new
+// unique token, using a compare-and-swap from the last token it wrote. The
+// compare-and-swap must observe the latest value of the key, so it is ordered
+// after every write which had completed, and so are the node's later reads.
+//
+// If the key doesn't hold the expected token, because an earlier Sync timed
+// out after it was applied or the node restarted, Sync reads the current
+// token and tries again. Concurrent calls are serialized.
+//
+// Every read from lin-kv is already fresh, so Sync does nothing there.
+func (kv *KV) Sync(ctx context.Context) error {
+	if kv.typ == LinKV {
+		return nil
+	}
+
+	kv.syncMu.Lock()
+	defer kv.syncMu.Unlock()
+	key := "sync-" + kv.node.ID()
+	for {
+		kv.syncs++
+		token := fmt.Sprintf("%s-%d-%x", kv.node.ID(), kv.syncs, rand.Uint32())
+		err := kv.CompareAndSwap(ctx, key, kv.syncToken, token, true)
+		if err == nil {
+			kv.syncToken = token
+			return nil
+		} else if ErrorCode(err) != PreconditionFailed {
+			return err
+		}
+
+		// The read may be stale, in which case the next attempt fails too.
+		cur, err := kv.Read(ctx, key)
+		if err != nil && ErrorCode(err) != KeyDoesNotExist {
+			return err
+		}
+		kv.syncToken, _ = cur.(string)
+	}
+}
+
 // kvReadMessageBody represents the body for the KV "read" message.
 type kvReadMessageBody struct {
 	MessageBody
@@ -0,0 +1,75 @@
+package maelstrom_test
+
+import (
+	"context"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+)
+
+func TestKV_Sync(t *testing.T) {
+	t.Run("Fresh", func(t *testing.T) {
+		net := newKVNetwork(t)
+		writer, reader := newKVClient(net, "n1"), newKVClient(net, "n2")
+		ctx := context.Background()
+
+		// The simulated seq-kv serves half of all reads from an older
+		// version, but never after a sync.
+		for i := 1; i <= 100; i++ {
+			if err := writer.Write(ctx, "x", i); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := reader.ReadFreshInt(ctx, "x"); err != nil {
+				t.Fatal(err)
+			} else if got, want := v, i; got != want {
+				t.Fatalf("x=%d, want %d", got, want)
+			}
+		}
+	})
+
+	t.Run("LostToken", func(t *testing.T) {
+		// A second client on the same node doesn't know the first's token,
+		// as if the node had restarted.
+		net := newKVNetwork(t)
+		n := newKVNode(net, "n1")
+		before, after := maelstrom.NewSeqKV(n), maelstrom.NewSeqKV(n)
+		ctx := context.Background()
+		for i := 0; i < 10; i++ {
+			if err := before.Sync(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		writer := newKVClient(net, "n2")
+		if err := writer.Write(ctx, "x", 1); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := after.ReadFreshInt(ctx, "x"); err != nil {
+			t.Fatal(err)
+		} else if got, want := v, 1; got != want {
+			t.Fatalf("x=%d, want %d", got, want)
+		}
+	})
+}
+
+// newKVNetwork returns a network with a seq-kv service.
+func newKVNetwork(tb testing.TB) *sim.Network {
+	net := sim.NewNetwork()
+	tb.Cleanup(func() { net.Close() })
+	net.AddService(maelstrom.SeqKV, sim.NewKV(maelstrom.SeqKV).Node())
+	return net
+}
+
+// newKVNode adds an initialized node to net.
+func newKVNode(net *sim.Network, id string) *maelstrom.Node {
+	n := maelstrom.NewNode()
+	n.Init(id, nil)
+	net.AddNode(id, n)
+	return n
+}
+
+// newKVClient returns a seq-kv client for a new node.
+func newKVClient(net *sim.Network, id string) *maelstrom.KV {
+	return maelstrom.NewSeqKV(newKVNode(net, id))
+}
@@ -0,0 +1,527 @@
+// Package membership tracks which nodes in a cluster are reachable, using the
+// SWIM protocol of Das, Gupta and Motivala.
+//
+// Each protocol period, a node pings one other node, chosen round robin in a
+// random order. If the node doesn't answer in time, several others are asked
+// to ping it too (a ping-req), which tells a failed node apart from a lost
+// message or a single broken link. If none of them hear back by the end of
+// the period, the node is suspected, and if it doesn't refute the suspicion
+// in time, it is declared dead.
+//
+// Changes in status are not broadcast, but piggybacked on pings and their
+// replies. Each node numbers its own changes with an incarnation: a node
+// which hears that it is suspected or dead increments its incarnation and
+// announces that it is alive, which overrides the older claims. Maelstrom's
+// partitions heal, so unlike in the paper, dead nodes are still pinged, and
+// come back to life by refuting their deaths.
+package membership
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Status is the state of a node, as far as this node knows.
+type Status int
+
+// Node statuses.
+const (
+	Alive Status = iota
+	Suspect
+	Dead
+)
+
+var statusNames = [...]string{"alive", "suspect", "dead"}
+
+func (s Status) String() string {
+	if s < 0 || int(s) >= len(statusNames) {
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+	return statusNames[s]
+}
+
+// MarshalText encodes a status by name.
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a status by name.
+func (s *Status) UnmarshalText(b []byte) error {
+	for i, name := range statusNames {
+		if string(b) == name {
+			*s = Status(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown status %q", b)
+}
+
+// Event is a change in a node's status. Events are also the updates
+// piggybacked on pings.
+type Event struct {
+	Node        string `json:"node"`
+	Status      Status `json:"status"`
+	Incarnation int    `json:"incarnation"`
+}
+
+// Config controls a Membership.
+type Config struct {
+	// Type is the prefix of the message types used: pings are of type
+	// Type + "_ping" and ping-reqs of type Type + "_ping_req", and replies
+	// add "_ok". Defaults to "swim".
+	Type string
+
+	// Interval is the protocol period, in which one node is probed.
+	// Defaults to 1s.
+	Interval time.Duration
+
+	// Timeout is how long to wait for a reply to a ping before asking other
+	// nodes to ping the node as well. It should exceed the round trip time,
+	// and leave time in the period for two more. Defaults to 40% of
+	// Interval.
+	Timeout time.Duration
+
+	// IndirectChecks is the number of nodes asked to ping a node which
+	// didn't answer. Defaults to 3.
+	IndirectChecks int
+
+	// SuspicionTimeout is how long a node may be suspected before it is
+	// declared dead. Defaults to five times Interval.
+	SuspicionTimeout time.Duration
+
+	// Retransmits is the number of messages each update is piggybacked on.
+	// Defaults to three times the log of the cluster size.
+	Retransmits int
+}
+
+// Membership detects which of a node's peers are reachable.
+type Membership struct {
+	node *maelstrom.Node
+	cfg  Config
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	self      member             // this node, whose status is always alive
+	members   map[string]*member // every other node
+	order     []string           // the order in which to probe nodes
+	next      int
+	changes   []Event // not yet passed to listeners
+	listeners []func(Event)
+
+	emitMu sync.Mutex // serializes calls to listeners
+	done   chan struct{}
+	once   sync.Once
+}
+
+// member is what this node knows of another.
+type member struct {
+	status      Status
+	incarnation int
+	suspected   time.Time
+	sent        int // number of messages the latest update was piggybacked on
+}
+
+// message is a ping or ping-req, or a reply to one.
+type message struct {
+	Type    string  `json:"type"`
+	Target  string  `json:"target,omitempty"`
+	Updates []Event `json:"updates,omitempty"`
+}
+
+// New returns a Membership which probes the other nodes in n's cluster, and
+// registers handlers for its messages on n. Probing begins once n is
+// initialized, and continues until Close is called.
+func New(n *maelstrom.Node, cfg Config) *Membership {
+	if cfg.Type == "" {
+		cfg.Type = "swim"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cfg.Interval * 2 / 5
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = 3
+	}
+	if cfg.SuspicionTimeout <= 0 {
+		cfg.SuspicionTimeout = 5 * cfg.Interval
+	}
+
+	m := &Membership{
+		node: n,
+		cfg:  cfg,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		done: make(chan struct{}),
+	}
+	n.Handle(cfg.Type+"_ping", m.handlePing)
+	n.Handle(cfg.Type+"_ping_req", m.handlePingReq)
+	go m.run()
+	return m
+}
+
+// Status returns the status of the node with the given ID. Nodes are alive
+// until shown otherwise, and this node is always alive.
+func (m *Membership) Status(id string) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mem := m.member(id); mem != nil {
+		return mem.status
+	}
+	return Alive
+}
+
+// OnChange registers a function to call with each change in a node's
+// status, in order. It must not block.
+func (m *Membership) OnChange(fn func(Event)) {
+	m.emitMu.Lock()
+	defer m.emitMu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Route returns peers, with each one that isn't alive replaced by the first
+// alive node after it in the cluster, if any, which is neither this node nor
+// already a peer. Gossiping with the result keeps a sparse topology connected
+// when some of its nodes are unreachable.
+func (m *Membership) Route(peers []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := m.node.NodeIDs()
+	chosen := map[string]bool{m.node.ID(): true}
+	for _, id := range peers {
+		chosen[id] = true
+	}
+
+	routed := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if m.alive(peer) {
+			routed = append(routed, peer)
+			continue
+		}
+		start := indexOf(ids, peer)
+		for i := 1; i < len(ids); i++ {
+			id := ids[(start+i)%len(ids)]
+			if !chosen[id] && m.alive(id) {
+				chosen[id] = true
+				routed = append(routed, id)
+				break
+			}
+		}
+	}
+	return routed
+}
+
+// Close stops probing.
+func (m *Membership) Close() {
+	m.once.Do(func() { close(m.done) })
+}
+
+// run probes a node every period until closed.
+func (m *Membership) run() {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		if m.node.ID() == "" {
+			continue
+		}
+
+		m.mu.Lock()
+		m.expire()
+		target := m.nextTarget()
+		m.mu.Unlock()
+		m.emit()
+
+		if target != "" {
+			m.probe(target)
+		}
+	}
+}
+
+// probe pings target, and if it doesn't answer, asks other nodes to ping it.
+// If none of them hear back within the period, target is suspected.
+func (m *Membership) probe(target string) {
+	acks := make(chan struct{}, 1+m.cfg.IndirectChecks)
+	m.send(target, message{Type: m.cfg.Type + "_ping"}, acks)
+	if m.wait(acks, m.cfg.Timeout) {
+		return
+	}
+
+	m.mu.Lock()
+	helpers := m.helpers(target)
+	m.mu.Unlock()
+	for _, id := range helpers {
+		m.send(id, message{Type: m.cfg.Type + "_ping_req", Target: target}, acks)
+	}
+	if m.wait(acks, m.cfg.Interval-m.cfg.Timeout) {
+		return
+	}
+
+	m.mu.Lock()
+	if mem := m.member(target); mem != nil && mem.status == Alive {
+		m.set(Event{Node: target, Status: Suspect, Incarnation: mem.incarnation})
+	}
+	m.mu.Unlock()
+	m.emit()
+}
+
+// send sends a request with piggybacked updates to dest, and signals acks if
+// it is answered.
+func (m *Membership) send(dest string, body message, acks chan<- struct{}) {
+	body.Updates = m.piggyback(dest)
+	m.node.RPC(dest, body, func(msg maelstrom.Message) error {
+		if msg.RPCError() != nil {
+			return nil
+		}
+		if _, err := m.receive(msg); err != nil {
+			return err
+		}
+		select {
+		case acks <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+}
+
+// wait waits up to timeout for an ack, and reports whether one arrived.
+func (m *Membership) wait(acks <-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-acks:
+		return true
+	case <-timer.C:
+		return false
+	case <-m.done:
+		return true
+	}
+}
+
+// handlePing applies the updates on a ping and answers it.
+func (m *Membership) handlePing(msg maelstrom.Message) error {
+	if _, err := m.receive(msg); err != nil {
+		return err
+	}
+	return m.node.Reply(msg, message{Type: m.cfg.Type + "_ping_ok", Updates: m.piggyback(msg.Src)})
+}
+
+// handlePingReq pings a node on behalf of the sender, and answers only if the
+// node does.
+func (m *Membership) handlePingReq(msg maelstrom.Message) error {
+	body, err := m.receive(msg)
+	if err != nil {
+		return err
+	}
+
+	acks := make(chan struct{}, 1)
+	m.send(body.Target, message{Type: m.cfg.Type + "_ping"}, acks)
+	if !m.wait(acks, m.cfg.Timeout) {
+		return nil
+	}
+	return m.node.Reply(msg, message{Type: m.cfg.Type + "_ping_req_ok", Updates: m.piggyback(msg.Src)})
+}
+
+// receive applies the updates piggybacked on a message.
+func (m *Membership) receive(msg maelstrom.Message) (message, error) {
+	var body message
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return body, err
+	}
+
+	m.mu.Lock()
+	for _, u := range body.Updates {
+		m.apply(u)
+	}
+	m.mu.Unlock()
+	m.emit()
+	return body, nil
+}
+
+// apply applies an update from another node, if it is newer than what we
+// know. Must be called with the lock held.
+func (m *Membership) apply(u Event) {
+	if u.Node == m.node.ID() {
+		// Refute any claim that we're not alive.
+		if u.Status != Alive && u.Incarnation >= m.self.incarnation {
+			m.self.incarnation = u.Incarnation + 1
+			m.self.sent = 0
+		}
+		return
+	}
+
+	mem := m.member(u.Node)
+	if mem == nil {
+		return
+	}
+	var newer bool
+	switch u.Status {
+	case Alive:
+		newer = u.Incarnation > mem.incarnation
+	case Suspect:
+		newer = u.Incarnation > mem.incarnation || u.Incarnation == mem.incarnation && mem.status == Alive
+	case Dead:
+		newer = u.Incarnation > mem.incarnation || u.Incarnation == mem.incarnation && mem.status != Dead
+	}
+	if newer {
+		m.set(u)
+	}
+}
+
+// set changes the status of another node, and queues the change to be
+// piggybacked and passed to listeners. Must be called with the lock held.
+func (m *Membership) set(e Event) {
+	mem := m.member(e.Node)
+	mem.status, mem.incarnation, mem.sent = e.Status, e.Incarnation, 0
+	if e.Status == Suspect {
+		mem.suspected = time.Now()
+	}
+	m.changes = append(m.changes, e)
+}
+
+// member returns what we know of the node with the given ID, or nil if it's
+// this node or not in the cluster. Must be called with the lock held.
+func (m *Membership) member(id string) *member {
+	m.initMembers()
+	return m.members[id]
+}
+
+// initMembers starts out with every other node alive, once the node is
+// initialized. Must be called with the lock held.
+func (m *Membership) initMembers() {
+	if m.members != nil || m.node.ID() == "" {
+		return
+	}
+	// Nothing needs to be piggybacked until something changes.
+	m.members = make(map[string]*member)
+	m.self.sent = m.retransmits()
+	for _, id := range m.node.NodeIDs() {
+		if id != m.node.ID() {
+			m.members[id] = &member{sent: m.self.sent}
+		}
+	}
+}
+
+// alive reports whether the node with the given ID is alive. Must be called
+// with the lock held.
+func (m *Membership) alive(id string) bool {
+	mem := m.member(id)
+	return mem == nil || mem.status == Alive
+}
+
+// retransmits returns the number of messages to piggyback each update on.
+func (m *Membership) retransmits() int {
+	if m.cfg.Retransmits > 0 {
+		return m.cfg.Retransmits
+	}
+	return 3 * int(math.Ceil(math.Log2(float64(len(m.node.NodeIDs())+1))))
+}
+
+// expire declares suspects dead once they have had long enough to refute the
+// suspicion. Must be called with the lock held.
+func (m *Membership) expire() {
+	m.initMembers()
+	for id, mem := range m.members {
+		if mem.status == Suspect && time.Since(mem.suspected) >= m.cfg.SuspicionTimeout {
+			m.set(Event{Node: id, Status: Dead, Incarnation: mem.incarnation})
+		}
+	}
+}
+
+// nextTarget returns the next node to probe, visiting every other node in a
+// random order before starting again in a new one. Must be called with the
+// lock held.
+func (m *Membership) nextTarget() string {
+	if m.next >= len(m.order) {
+		m.order = m.order[:0]
+		for _, id := range m.node.NodeIDs() {
+			if id != m.node.ID() {
+				m.order = append(m.order, id)
+			}
+		}
+		m.rand.Shuffle(len(m.order), func(i, j int) { m.order[i], m.order[j] = m.order[j], m.order[i] })
+		m.next = 0
+	}
+	if len(m.order) == 0 {
+		return ""
+	}
+	m.next++
+	return m.order[m.next-1]
+}
+
+// helpers returns up to IndirectChecks random alive nodes, other than target,
+// to ask to ping target. Must be called with the lock held.
+func (m *Membership) helpers(target string) []string {
+	var ids []string
+	for id, mem := range m.members {
+		if id != target && mem.status == Alive {
+			ids = append(ids, id)
+		}
+	}
+	m.rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	if len(ids) > m.cfg.IndirectChecks {
+		ids = ids[:m.cfg.IndirectChecks]
+	}
+	return ids
+}
+
+// piggyback returns the updates to send to dest: those which have not yet been
+// sent enough times, and what we know of dest if it isn't alive, so that it
+// can refute it.
+func (m *Membership) piggyback(dest string) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.initMembers()
+	limit := m.retransmits()
+	var updates []Event
+	if m.self.sent < limit {
+		m.self.sent++
+		updates = append(updates, Event{Node: m.node.ID(), Status: Alive, Incarnation: m.self.incarnation})
+	}
+	for id, mem := range m.members {
+		if mem.sent < limit || id == dest && mem.status != Alive {
+			mem.sent++
+			updates = append(updates, Event{Node: id, Status: mem.status, Incarnation: mem.incarnation})
+		}
+	}
+	return updates
+}
+
+// emit passes queued changes to listeners.
+func (m *Membership) emit() {
+	m.emitMu.Lock()
+	defer m.emitMu.Unlock()
+
+	m.mu.Lock()
+	changes := m.changes
+	m.changes = nil
+	m.mu.Unlock()
+
+	for _, e := range changes {
+		for _, fn := range m.listeners {
+			fn(e)
+		}
+	}
+}
+
+// indexOf returns the index of s in ss, or -1.
+func indexOf(ss []string, s string) int {
+	for i, v := range ss {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
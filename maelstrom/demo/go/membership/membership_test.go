@@ -0,0 +1,170 @@
+package membership_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/membership"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+)
+
+func TestMain(m *testing.M) {
+	// Nodes log every message they send and receive.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+var testConfig = membership.Config{
+	Interval:         20 * time.Millisecond,
+	Timeout:          8 * time.Millisecond,
+	SuspicionTimeout: 60 * time.Millisecond,
+}
+
+func TestMembership(t *testing.T) {
+	t.Run("Dead", func(t *testing.T) {
+		c := newCluster(t, 5)
+		c.net.Partition([]string{"n0"}, []string{"n1", "n2", "n3", "n4"})
+		c.waitStatus(t, "n0", membership.Dead, c.ids[1:]...)
+		c.waitStatus(t, "n1", membership.Dead, "n0")
+
+		// Once the partition heals, n0 refutes its death.
+		c.net.Heal()
+		c.waitStatus(t, "n0", membership.Alive, c.ids[1:]...)
+		c.waitStatus(t, "n1", membership.Alive, "n0")
+	})
+
+	t.Run("Indirect", func(t *testing.T) {
+		// n1 can't reach n0, but others can ping it on n1's behalf.
+		c := newCluster(t, 5)
+		c.net.Partition([]string{"n0"}, []string{"n1"})
+		time.Sleep(20 * testConfig.Interval)
+		if got := c.members[1].Status("n0"); got != membership.Alive {
+			t.Fatalf("status=%v, want %v", got, membership.Alive)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		c := newCluster(t, 3)
+		var mu sync.Mutex
+		var events []string
+		c.members[1].OnChange(func(e membership.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Node == "n0" {
+				events = append(events, e.Status.String())
+			}
+		})
+
+		c.net.Partition([]string{"n0"}, []string{"n1", "n2"})
+		c.waitStatus(t, "n0", membership.Dead, "n1")
+		c.net.Heal()
+		c.waitStatus(t, "n0", membership.Alive, "n1")
+
+		mu.Lock()
+		defer mu.Unlock()
+		if got, want := fmt.Sprint(events), "[suspect dead alive]"; got != want {
+			t.Fatalf("events=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("Route", func(t *testing.T) {
+		c := newCluster(t, 5)
+		c.net.Partition([]string{"n0", "n1"}, []string{"n2", "n3", "n4"})
+		c.waitStatus(t, "n1", membership.Dead, "n2")
+		c.waitStatus(t, "n0", membership.Dead, "n2")
+
+		// n1 and n0 are replaced by the next alive nodes after them.
+		if got, want := fmt.Sprint(c.members[2].Route([]string{"n1", "n3"})), "[n4 n3]"; got != want {
+			t.Fatalf("route=%v, want %v", got, want)
+		}
+		if got, want := fmt.Sprint(c.members[2].Route([]string{"n0", "n1"})), "[n3 n4]"; got != want {
+			t.Fatalf("route=%v, want %v", got, want)
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+	for _, s := range []membership.Status{membership.Alive, membership.Suspect, membership.Dead} {
+		text, _ := s.MarshalText()
+		var got membership.Status
+		if err := got.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		} else if got != s {
+			t.Fatalf("status=%v, want %v", got, s)
+		}
+	}
+}
+
+// cluster is a set of nodes on a simulated network, each with a Membership.
+type cluster struct {
+	net     *sim.Network
+	ids     []string
+	members []*membership.Membership
+}
+
+// newCluster starts n initialized nodes.
+func newCluster(tb testing.TB, n int) *cluster {
+	tb.Helper()
+
+	c := &cluster{net: sim.NewNetwork()}
+	tb.Cleanup(func() { c.net.Close() })
+	for i := 0; i < n; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("n%d", i))
+	}
+
+	for _, id := range c.ids {
+		node := maelstrom.NewNode()
+		m := membership.New(node, testConfig)
+		tb.Cleanup(m.Close)
+		c.net.AddNode(id, node)
+		c.members = append(c.members, m)
+	}
+
+	client := c.net.AddClient("c0")
+	for _, id := range c.ids {
+		if _, err := client.SyncRPC(context.Background(), id, maelstrom.InitMessageBody{
+			MessageBody: maelstrom.MessageBody{Type: "init"},
+			NodeID:      id,
+			NodeIDs:     c.ids,
+		}); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return c
+}
+
+// waitStatus waits up to five seconds until each of observers sees node with
+// the given status.
+func (c *cluster) waitStatus(tb testing.TB, node string, want membership.Status, observers ...string) {
+	tb.Helper()
+	var statuses []membership.Status
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		statuses = statuses[:0]
+		ok := true
+		for _, id := range observers {
+			s := c.members[indexOf(c.ids, id)].Status(node)
+			statuses = append(statuses, s)
+			ok = ok && s == want
+		}
+		if ok {
+			return
+		}
+	}
+	tb.Fatalf("timed out: %s seen as %v by %v, want %v", node, statuses, observers, want)
+}
+
+func indexOf(ss []string, s string) int {
+	for i, v := range ss {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
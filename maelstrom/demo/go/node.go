@@ -16,8 +16,10 @@ type Node struct {
 	mu sync.Mutex
 	wg sync.WaitGroup
 
-	id        string
-	nodeIDs   []string
+	idMu    sync.RWMutex // guards id and nodeIDs, separately from writes
+	id      string
+	nodeIDs []string
+
 	nextMsgID int
 
 	handlers  map[string]HandlerFunc
@@ -45,6 +47,8 @@ func NewNode() *Node {
 // receiving an "init" message but it can also be called manually when
 // initializing unit tests.
 func (n *Node) Init(id string, nodeIDs []string) {
+	n.idMu.Lock()
+	defer n.idMu.Unlock()
 	n.id = id
 	n.nodeIDs = nodeIDs
 }
@@ -52,6 +56,8 @@ func (n *Node) Init(id string, nodeIDs []string) {
 // ID returns the identifier for this node.
 // Only valid after "init" message has been received.
 func (n *Node) ID() string {
+	n.idMu.RLock()
+	defer n.idMu.RUnlock()
 	return n.id
 }
 
@@ -59,6 +65,8 @@ func (n *Node) ID() string {
 // local node ID and is the same order across all nodes. Only valid after "init"
 // message has been received.
 func (n *Node) NodeIDs() []string {
+	n.idMu.RLock()
+	defer n.idMu.RUnlock()
 	return n.nodeIDs
 }
 
@@ -178,7 +186,7 @@ func (n *Node) handleInitMessage(msg Message) error {
 	}
 
 	// Send back a response that the node has been initialized.
-	log.Printf("Node %s initialized", n.id)
+	log.Printf("Node %s initialized", n.ID())
 	return n.Reply(msg, MessageBody{Type: "init_ok"})
 }
 
@@ -210,7 +218,7 @@ func (n *Node) Send(dest string, body any) error {
 	}
 
 	buf, err := json.Marshal(Message{
-		Src:  n.id,
+		Src:  n.ID(),
 		Dest: dest,
 		Body: bodyJSON,
 	})
@@ -0,0 +1,171 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// KV is an in-memory implementation of Maelstrom's key/value services.
+//
+// A lin-kv store always serves the latest value. A seq-kv store may serve
+// reads from any version at least as recent as the last one the calling node
+// observed, so each node sees a sequentially consistent view. An lww-kv store
+// may serve reads from any recent version at all. Writes and
+// compare-and-swaps always apply to the latest version.
+type KV struct {
+	// Stale is the probability that a read on a seq-kv or lww-kv store is
+	// served from an older version than the latest.
+	Stale float64
+
+	typ string
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	version int
+	keys    map[string][]kvVersion
+	floors  map[string]int // seq-kv: node -> last version observed
+}
+
+// kvVersion is a value written to a key at a particular version.
+type kvVersion struct {
+	version int
+	value   any
+}
+
+// NewKV returns a new store of the given type: maelstrom.LinKV,
+// maelstrom.SeqKV, or maelstrom.LWWKV.
+func NewKV(typ string) *KV {
+	return &KV{
+		Stale:  0.5,
+		typ:    typ,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		keys:   make(map[string][]kvVersion),
+		floors: make(map[string]int),
+	}
+}
+
+// Node returns a node which serves the store's RPCs. Attach it to a network
+// with Network.AddService, using the store's type as the ID.
+func (kv *KV) Node() *maelstrom.Node {
+	n := maelstrom.NewNode()
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		var body kvRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		v, ok := kv.read(msg.Src, body.Key)
+		if !ok {
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		return n.Reply(msg, map[string]any{"type": "read_ok", "value": v})
+	})
+
+	n.Handle("write", func(msg maelstrom.Message) error {
+		var body kvRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		kv.write(msg.Src, body.Key, body.Value)
+		return n.Reply(msg, map[string]any{"type": "write_ok"})
+	})
+
+	n.Handle("cas", func(msg maelstrom.Message) error {
+		var body kvRequest
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		if err := kv.cas(msg.Src, body.Key, body.From, body.To, body.CreateIfNotExists); err != nil {
+			return err
+		}
+		return n.Reply(msg, map[string]any{"type": "cas_ok"})
+	})
+
+	return n
+}
+
+// kvRequest is the union of the read, write, and cas request bodies.
+type kvRequest struct {
+	Key               any  `json:"key"`
+	Value             any  `json:"value"`
+	From              any  `json:"from"`
+	To                any  `json:"to"`
+	CreateIfNotExists bool `json:"create_if_not_exists"`
+}
+
+// read returns the value of key as seen by node src.
+func (kv *KV) read(src string, key any) (any, bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	// Choose the version this read observes.
+	at := kv.version
+	if kv.typ != maelstrom.LinKV && kv.rand.Float64() < kv.Stale {
+		floor := 0
+		if kv.typ == maelstrom.SeqKV {
+			floor = kv.floors[src]
+		}
+		at = floor + kv.rand.Intn(kv.version-floor+1)
+	}
+	if kv.typ == maelstrom.SeqKV {
+		kv.floors[src] = at
+	}
+
+	versions := kv.keys[kvKey(key)]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].version <= at {
+			return versions[i].value, true
+		}
+	}
+	return nil, false
+}
+
+// write sets the latest value of key.
+func (kv *KV) write(src string, key, value any) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.put(src, key, value)
+}
+
+// cas sets the latest value of key to `to` if it is currently `from`.
+func (kv *KV) cas(src string, key, from, to any, create bool) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	versions := kv.keys[kvKey(key)]
+	if len(versions) == 0 {
+		if !create {
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+	} else if cur := versions[len(versions)-1].value; kvKey(cur) != kvKey(from) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("expected %s, but had %s", kvKey(from), kvKey(cur)))
+	}
+
+	kv.put(src, key, to)
+	return nil
+}
+
+// put appends a new latest version of key. Must be called with mu held.
+func (kv *KV) put(src string, key, value any) {
+	kv.version++
+	k := kvKey(key)
+	if kv.typ == maelstrom.LinKV {
+		// Old versions are never read, so don't keep them around.
+		kv.keys[k] = kv.keys[k][:0]
+	}
+	kv.keys[k] = append(kv.keys[k], kvVersion{version: kv.version, value: value})
+	if kv.typ == maelstrom.SeqKV {
+		kv.floors[src] = kv.version
+	}
+}
+
+// kvKey returns the canonical JSON form of a key or value.
+func kvKey(v any) string {
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
@@ -0,0 +1,88 @@
+package sim_test
+
+import (
+	"context"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+)
+
+func TestKV(t *testing.T) {
+	t.Run("LinKV", func(t *testing.T) {
+		kv := newKV(t, maelstrom.LinKV)
+		ctx := context.Background()
+
+		if _, err := kv.Read(ctx, "x"); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := kv.CompareAndSwap(ctx, "x", 0, 1, false); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := kv.CompareAndSwap(ctx, "x", 0, 1, true); err != nil {
+			t.Fatal(err)
+		} else if err := kv.CompareAndSwap(ctx, "x", 0, 2, true); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := kv.Write(ctx, "x", 3); err != nil {
+			t.Fatal(err)
+		} else if v, err := kv.ReadInt(ctx, "x"); err != nil {
+			t.Fatal(err)
+		} else if got, want := v, 3; got != want {
+			t.Fatalf("x=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("SeqKVStaleReads", func(t *testing.T) {
+		writer := newKV(t, maelstrom.SeqKV)
+		ctx := context.Background()
+
+		// Another node may observe older versions, but never goes backwards.
+		reader := newClientKV(writer.net, maelstrom.SeqKV, "n2")
+		stale, last := 0, 0
+		for i := 1; i <= 100; i++ {
+			if err := writer.Write(ctx, "x", i); err != nil {
+				t.Fatal(err)
+			}
+			v, err := reader.ReadInt(ctx, "x")
+			if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+				t.Fatal(err)
+			}
+			if v < last {
+				t.Fatalf("read %d after %d", v, last)
+			} else if v < i {
+				stale++
+			}
+			last = v
+		}
+		if stale == 0 {
+			t.Fatal("expected some stale reads")
+		}
+
+		// The writer always observes its own writes.
+		if v, err := writer.ReadInt(ctx, "x"); err != nil {
+			t.Fatal(err)
+		} else if got, want := v, 100; got != want {
+			t.Fatalf("x=%d, want %d", got, want)
+		}
+	})
+}
+
+// testKV is a KV client attached to a network.
+type testKV struct {
+	*maelstrom.KV
+	net *sim.Network
+}
+
+// newKV returns a client for a new store of the given type, on a new network.
+func newKV(tb testing.TB, typ string) testKV {
+	net := sim.NewNetwork()
+	tb.Cleanup(func() { net.Close() })
+	net.AddService(typ, sim.NewKV(typ).Node())
+	return testKV{KV: newClientKV(net, typ, "n1"), net: net}
+}
+
+// newClientKV returns a client of the store typ for a new node with the given ID.
+func newClientKV(net *sim.Network, typ, id string) *maelstrom.KV {
+	n := maelstrom.NewNode()
+	n.Init(id, nil)
+	net.AddNode(id, n)
+	return maelstrom.NewKV(typ, n)
+}
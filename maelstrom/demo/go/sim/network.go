@@ -0,0 +1,309 @@
+// Package sim simulates a Maelstrom network in-process, so that nodes can be
+// exercised from Go tests and tools without running Maelstrom itself.
+//
+// Every endpoint on the network, whether a node under test, a client, or a
+// service such as lin-kv, exchanges newline-delimited JSON messages over a
+// reader and writer, exactly as a Maelstrom binary does over STDIN/STDOUT.
+package sim
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Kind classifies an endpoint on the network.
+type Kind int
+
+// Endpoint kinds.
+const (
+	Server Kind = iota
+	Client
+	Service
+)
+
+// Dist is a distribution of message delays, as in Maelstrom's --latency-dist.
+type Dist int
+
+// Delay distributions.
+const (
+	// Exponential delays have a mean of the network's latency.
+	Exponential Dist = iota
+
+	// Constant delays are always the network's latency.
+	Constant
+
+	// Uniform delays are between zero and twice the network's latency.
+	Uniform
+)
+
+// Stats counts the messages sent over a network.
+type Stats struct {
+	// All is the total number of messages sent.
+	All int
+
+	// Servers is the number of messages sent between two servers.
+	Servers int
+
+	// ServerBytes is the total size of the messages sent between two
+	// servers.
+	ServerBytes int
+
+	// Clients is the number of messages sent to or from clients.
+	Clients int
+
+	// Services is the number of messages sent to or from services.
+	Services int
+
+	// Dropped is the number of messages lost to partitions, or dropped by
+	// Network.Drop.
+	Dropped int
+}
+
+// Network routes messages between endpoints.
+type Network struct {
+	// Latency is the mean delay before a message is delivered. Messages are
+	// delivered immediately if zero.
+	Latency time.Duration
+
+	// LatencyDist is the distribution of delays. Defaults to Exponential.
+	LatencyDist Dist
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	endpoints map[string]*endpoint
+	blocked   map[link]bool
+	drop      func(msg maelstrom.Message) bool
+	stats     Stats
+	closed    bool
+}
+
+// endpoint is a single participant on the network.
+type endpoint struct {
+	id   string
+	kind Kind
+
+	mu sync.Mutex // serializes writes to w
+	w  io.Writer
+}
+
+// link is a directed pair of endpoint IDs.
+type link struct {
+	src, dest string
+}
+
+// NewNetwork returns a new, empty network.
+func NewNetwork() *Network {
+	return &Network{
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		endpoints: make(map[string]*endpoint),
+		blocked:   make(map[link]bool),
+	}
+}
+
+// Connect attaches an endpoint to the network. Messages sent by the endpoint
+// are read line by line from r, and messages addressed to it are written to w.
+func (net *Network) Connect(id string, kind Kind, r io.Reader, w io.Writer) {
+	net.mu.Lock()
+	net.endpoints[id] = &endpoint{id: id, kind: kind, w: w}
+	net.mu.Unlock()
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 16<<20)
+		for scanner.Scan() {
+			// The scanner reuses its buffer, so copy the line before routing it.
+			line := append([]byte(nil), scanner.Bytes()...)
+			net.route(id, line)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("%s: read error: %s", id, err)
+		}
+	}()
+}
+
+// AddNode runs n as a server with the given ID. The node must still be sent
+// an "init" message before it knows its ID.
+func (net *Network) AddNode(id string, n *maelstrom.Node) {
+	net.run(id, Server, n)
+}
+
+// AddService runs n as a service, such as a key/value store, at the given ID.
+func (net *Network) AddService(id string, n *maelstrom.Node) {
+	n.Init(id, nil)
+	net.run(id, Service, n)
+}
+
+// AddClient returns a new client endpoint with the given ID. Use its RPC
+// methods to send requests to servers.
+func (net *Network) AddClient(id string) *maelstrom.Node {
+	n := maelstrom.NewNode()
+	n.Init(id, nil)
+	net.run(id, Client, n)
+	return n
+}
+
+// run connects n to the network and starts its message loop.
+func (net *Network) run(id string, kind Kind, n *maelstrom.Node) {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	n.Stdin = inr
+	n.Stdout = outw
+
+	net.Connect(id, kind, outr, inw)
+	go func() {
+		if err := n.Run(); err != nil {
+			log.Printf("%s: run error: %s", id, err)
+		}
+		// Fail any further deliveries rather than blocking forever.
+		inr.Close()
+	}()
+}
+
+// Partition splits the network into groups of endpoints. Messages between
+// endpoints in different groups are dropped until Heal is called. Endpoints
+// not listed in any group can still reach everyone.
+func (net *Network) Partition(groups ...[]string) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	net.blocked = make(map[link]bool)
+	for i, a := range groups {
+		for j, b := range groups {
+			if i == j {
+				continue
+			}
+			for _, src := range a {
+				for _, dest := range b {
+					net.blocked[link{src, dest}] = true
+				}
+			}
+		}
+	}
+}
+
+// Heal removes all partitions.
+func (net *Network) Heal() {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.blocked = make(map[link]bool)
+}
+
+// Drop drops every message for which f returns true, as well as those lost
+// to partitions, until Drop is called again. A nil f drops nothing. Use it to
+// inject faults which partitions can't express, such as losing some replies
+// but not others. f is called with the network locked, so it must not call
+// the network's methods.
+func (net *Network) Drop(f func(msg maelstrom.Message) bool) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.drop = f
+}
+
+// Stats returns a snapshot of the network's message counts.
+func (net *Network) Stats() Stats {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	return net.stats
+}
+
+// Close stops delivering messages and closes the input of every endpoint
+// whose writer is an io.Closer, which causes in-process nodes to exit once
+// their in-flight handlers complete.
+func (net *Network) Close() error {
+	net.mu.Lock()
+	net.closed = true
+	endpoints := make([]*endpoint, 0, len(net.endpoints))
+	for _, ep := range net.endpoints {
+		endpoints = append(endpoints, ep)
+	}
+	net.mu.Unlock()
+
+	for _, ep := range endpoints {
+		if c, ok := ep.w.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+// route schedules delivery of a message emitted by the endpoint src.
+func (net *Network) route(src string, line []byte) {
+	var msg maelstrom.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		log.Printf("%s: malformed message: %s", src, line)
+		return
+	}
+
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	if net.closed {
+		return
+	}
+
+	from, to := net.endpoints[msg.Src], net.endpoints[msg.Dest]
+	if to == nil {
+		log.Printf("%s: no such destination %q", src, msg.Dest)
+		return
+	}
+
+	net.stats.All++
+	switch {
+	case from != nil && from.kind == Server && to.kind == Server:
+		net.stats.Servers++
+		net.stats.ServerBytes += len(line)
+	case from != nil && from.kind == Client || to.kind == Client:
+		net.stats.Clients++
+	default:
+		net.stats.Services++
+	}
+
+	if net.blocked[link{msg.Src, msg.Dest}] || net.drop != nil && net.drop(msg) {
+		net.stats.Dropped++
+		return
+	}
+
+	delay := net.delay()
+	time.AfterFunc(delay, func() { to.deliver(line) })
+}
+
+// delay returns a random delay for a message. Must be called with the lock
+// held.
+func (net *Network) delay() time.Duration {
+	switch net.LatencyDist {
+	case Constant:
+		return net.Latency
+	case Uniform:
+		return time.Duration(net.rand.Float64() * 2 * float64(net.Latency))
+	default:
+		return time.Duration(net.rand.ExpFloat64() * float64(net.Latency))
+	}
+}
+
+// deliver writes a message line to the endpoint.
+func (ep *endpoint) deliver(line []byte) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	buf := make([]byte, 0, len(line)+1)
+	buf = append(append(buf, line...), '\n')
+	if _, err := ep.w.Write(buf); err != nil && !closedErr(err) {
+		log.Printf("%s: write error: %s", ep.id, err)
+	}
+}
+
+// closedErr reports whether err is the result of writing to an endpoint which
+// has shut down, either because the network closed it or because its process
+// exited.
+func closedErr(err error) bool {
+	return errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) || errors.Is(err, syscall.EPIPE)
+}
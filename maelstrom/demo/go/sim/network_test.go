@@ -0,0 +1,110 @@
+package sim_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+)
+
+func TestMain(m *testing.M) {
+	// Nodes log every message they send and receive.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestNetwork(t *testing.T) {
+	t.Run("RPC", func(t *testing.T) {
+		net := newNetwork(t)
+		c := net.AddClient("c1")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := c.SyncRPC(ctx, "n1", map[string]any{"type": "echo"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := net.Stats(), (sim.Stats{All: 2, Clients: 2}); got != want {
+			t.Fatalf("stats=%+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		net := newNetwork(t)
+		net.Latency = 20 * time.Millisecond
+		c := net.AddClient("c1")
+
+		// Exponentially distributed delays have a long tail, so measure the
+		// mean round trip across many requests.
+		start := time.Now()
+		for i := 0; i < 20; i++ {
+			if _, err := c.SyncRPC(context.Background(), "n1", map[string]any{"type": "echo"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if mean := time.Since(start) / 20; mean < 20*time.Millisecond {
+			t.Fatalf("mean round trip %s, want at least 20ms", mean)
+		}
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		net := newNetwork(t)
+		c := net.AddClient("c1")
+		net.Partition([]string{"c1"}, []string{"n1"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := c.SyncRPC(ctx, "n1", map[string]any{"type": "echo"}); err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := net.Stats().Dropped, 1; got != want {
+			t.Fatalf("dropped=%d, want %d", got, want)
+		}
+
+		net.Heal()
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := c.SyncRPC(ctx, "n1", map[string]any{"type": "echo"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		net := newNetwork(t)
+		c := net.AddClient("c1")
+		// Lose replies, but not requests.
+		net.Drop(func(msg maelstrom.Message) bool { return msg.Src == "n1" })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := c.SyncRPC(ctx, "n1", map[string]any{"type": "echo"}); err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := net.Stats(), (sim.Stats{All: 2, Clients: 2, Dropped: 1}); got != want {
+			t.Fatalf("stats=%+v, want %+v", got, want)
+		}
+
+		net.Drop(nil)
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := c.SyncRPC(ctx, "n1", map[string]any{"type": "echo"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// newNetwork returns a network with a single echo server, "n1".
+func newNetwork(tb testing.TB) *sim.Network {
+	net := sim.NewNetwork()
+	tb.Cleanup(func() { net.Close() })
+
+	n := maelstrom.NewNode()
+	n.Handle("echo", func(msg maelstrom.Message) error {
+		return n.Reply(msg, map[string]any{"type": "echo_ok"})
+	})
+	n.Init("n1", []string{"n1"})
+	net.AddNode("n1", n)
+	return net
+}
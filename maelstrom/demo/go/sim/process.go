@@ -0,0 +1,25 @@
+package sim
+
+import (
+	"os/exec"
+)
+
+// StartProcess starts cmd as a server with the given ID, connected to the
+// network over its STDIN and STDOUT. The caller may set cmd.Stderr to capture
+// the node's logs, and should call cmd.Wait once the network is closed.
+func (net *Network) StartProcess(id string, cmd *exec.Cmd) error {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	net.Connect(id, Server, stdout, stdin)
+	return nil
+}
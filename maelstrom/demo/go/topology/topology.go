@@ -0,0 +1,297 @@
+// Package topology generates network topologies for the broadcast workload.
+// A topology maps each node ID to the IDs of its neighbors, as in the body of
+// Maelstrom's "topology" message. Every generated topology is undirected: if
+// a is a neighbor of b, then b is a neighbor of a.
+package topology
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Topology maps each node ID to its neighbors.
+type Topology map[string][]string
+
+// New returns the named topology over nodes. Names are those accepted by
+// Maelstrom's --topology flag, plus a few more:
+//
+//	line     each node connected to the next
+//	ring     a line whose ends are connected
+//	grid     a square grid, row by row
+//	total    every node connected to every other
+//	star     every node connected to the first
+//	treeN    a tree where each node has up to N children, e.g. tree4
+//	randomN  a random N-regular graph, e.g. random3
+//
+// Random topologies are seeded by the node IDs, so that every node computing
+// the same topology agrees on it.
+func New(name string, nodes []string) (Topology, error) {
+	switch name {
+	case "line":
+		return Line(nodes), nil
+	case "ring":
+		return Ring(nodes), nil
+	case "grid":
+		return Grid(nodes), nil
+	case "total":
+		return Total(nodes), nil
+	case "star":
+		return Star(nodes), nil
+	}
+
+	switch {
+	case strings.HasPrefix(name, "tree"):
+		fanout, err := suffix(name, "tree")
+		if err != nil {
+			return nil, err
+		}
+		return Tree(nodes, fanout), nil
+	case strings.HasPrefix(name, "random"):
+		k, err := suffix(name, "random")
+		if err != nil {
+			return nil, err
+		}
+		sorted := append([]string(nil), nodes...)
+		sort.Strings(sorted)
+		return RandomRegular(sorted, k, rand.New(rand.NewSource(seed(sorted))))
+	}
+	return nil, fmt.Errorf("unknown topology %q", name)
+}
+
+// suffix parses the positive integer following prefix in a topology name.
+func suffix(name, prefix string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid topology %q", name)
+	}
+	return n, nil
+}
+
+// Line connects each node to the next.
+func Line(nodes []string) Topology {
+	t := empty(nodes)
+	for i := 1; i < len(nodes); i++ {
+		t.connect(nodes[i-1], nodes[i])
+	}
+	return t
+}
+
+// Ring connects each node to the next, and the last node to the first.
+func Ring(nodes []string) Topology {
+	t := Line(nodes)
+	if len(nodes) > 2 {
+		t.connect(nodes[len(nodes)-1], nodes[0])
+	}
+	return t
+}
+
+// Grid arranges nodes in a square grid, row by row, with each node connected
+// to the nodes above, below, left and right of it. This is Maelstrom's default.
+func Grid(nodes []string) Topology {
+	width := int(math.Ceil(math.Sqrt(float64(len(nodes)))))
+	t := empty(nodes)
+	for i := range nodes {
+		if i%width < width-1 && i+1 < len(nodes) {
+			t.connect(nodes[i], nodes[i+1])
+		}
+		if i+width < len(nodes) {
+			t.connect(nodes[i], nodes[i+width])
+		}
+	}
+	return t
+}
+
+// Total connects every node to every other node.
+func Total(nodes []string) Topology {
+	t := empty(nodes)
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			t.connect(nodes[i], nodes[j])
+		}
+	}
+	return t
+}
+
+// Star connects every node to the first node.
+func Star(nodes []string) Topology {
+	t := empty(nodes)
+	for i := 1; i < len(nodes); i++ {
+		t.connect(nodes[0], nodes[i])
+	}
+	return t
+}
+
+// Tree arranges nodes in a tree rooted at the first node, in breadth-first
+// order, where each node has up to fanout children.
+func Tree(nodes []string, fanout int) Topology {
+	t := empty(nodes)
+	for i := 1; i < len(nodes); i++ {
+		t.connect(nodes[(i-1)/fanout], nodes[i])
+	}
+	return t
+}
+
+// RandomRegular returns a random graph in which every node has exactly k
+// neighbors. It requires k < len(nodes) and an even len(nodes)*k.
+func RandomRegular(nodes []string, k int, rnd *rand.Rand) (Topology, error) {
+	n := len(nodes)
+	if k >= n || n*k%2 != 0 {
+		return nil, fmt.Errorf("no %d-regular graph on %d nodes", k, n)
+	}
+
+	// Pair up k "stubs" per node at random, retrying whenever a pairing
+	// would create a self-loop or a duplicate edge.
+	for attempt := 0; attempt < 1000; attempt++ {
+		if t, ok := randomPairing(nodes, k, rnd); ok {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to generate a %d-regular graph on %d nodes", k, n)
+}
+
+// randomPairing makes a single attempt at a random k-regular graph.
+func randomPairing(nodes []string, k int, rnd *rand.Rand) (Topology, bool) {
+	stubs := make([]int, 0, len(nodes)*k)
+	for i := range nodes {
+		for j := 0; j < k; j++ {
+			stubs = append(stubs, i)
+		}
+	}
+
+	t := empty(nodes)
+	for len(stubs) > 0 {
+		// Pick a suitable partner for the last stub among the rest.
+		a := stubs[len(stubs)-1]
+		stubs = stubs[:len(stubs)-1]
+		found := false
+		for _, j := range rnd.Perm(len(stubs)) {
+			b := stubs[j]
+			if b == a || t.connected(nodes[a], nodes[b]) {
+				continue
+			}
+			t.connect(nodes[a], nodes[b])
+			stubs[j] = stubs[len(stubs)-1]
+			stubs = stubs[:len(stubs)-1]
+			found = true
+			break
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return t, true
+}
+
+// Stats describes the shape of a topology.
+type Stats struct {
+	Nodes int
+	Edges int
+
+	MinDegree  int
+	MaxDegree  int
+	MeanDegree float64
+
+	// Diameter is the greatest number of hops between any two nodes, or -1
+	// if the topology is not connected.
+	Diameter int
+}
+
+// String formats the stats on a single line.
+func (s Stats) String() string {
+	return fmt.Sprintf("nodes=%d edges=%d degree=%d..%d (mean %.2f) diameter=%d",
+		s.Nodes, s.Edges, s.MinDegree, s.MaxDegree, s.MeanDegree, s.Diameter)
+}
+
+// Stats computes the degree and diameter of t.
+func (t Topology) Stats() Stats {
+	s := Stats{Nodes: len(t), MinDegree: -1}
+	for _, neighbors := range t {
+		d := len(neighbors)
+		s.Edges += d
+		if s.MinDegree < 0 || d < s.MinDegree {
+			s.MinDegree = d
+		}
+		if d > s.MaxDegree {
+			s.MaxDegree = d
+		}
+	}
+	if s.MinDegree < 0 {
+		s.MinDegree = 0
+	}
+	if s.Nodes > 0 {
+		s.MeanDegree = float64(s.Edges) / float64(s.Nodes)
+	}
+	s.Edges /= 2
+
+	for id := range t {
+		dists := t.distances(id)
+		if len(dists) < len(t) {
+			s.Diameter = -1
+			break
+		}
+		for _, d := range dists {
+			if d > s.Diameter {
+				s.Diameter = d
+			}
+		}
+	}
+	return s
+}
+
+// distances returns the number of hops from src to every node reachable from
+// it, by breadth-first search.
+func (t Topology) distances(src string) map[string]int {
+	dists := map[string]int{src: 0}
+	queue := []string{src}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, neighbor := range t[id] {
+			if _, ok := dists[neighbor]; !ok {
+				dists[neighbor] = dists[id] + 1
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	return dists
+}
+
+// empty returns a topology of nodes with no edges.
+func empty(nodes []string) Topology {
+	t := make(Topology, len(nodes))
+	for _, id := range nodes {
+		t[id] = []string{}
+	}
+	return t
+}
+
+// connect adds an undirected edge between a and b.
+func (t Topology) connect(a, b string) {
+	t[a] = append(t[a], b)
+	t[b] = append(t[b], a)
+}
+
+// connected reports whether a and b are neighbors.
+func (t Topology) connected(a, b string) bool {
+	for _, id := range t[a] {
+		if id == b {
+			return true
+		}
+	}
+	return false
+}
+
+// seed derives a random seed from a list of node IDs.
+func seed(nodes []string) int64 {
+	h := fnv.New64a()
+	for _, id := range nodes {
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+	}
+	return int64(h.Sum64())
+}
@@ -0,0 +1,155 @@
+package topology_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/jepsen-io/maelstrom/demo/go/topology"
+)
+
+func TestNew(t *testing.T) {
+	nodes := ids(25)
+	for _, tt := range []struct {
+		name     string
+		edges    int
+		degree   [2]int
+		diameter int
+	}{
+		{"line", 24, [2]int{1, 2}, 24},
+		{"ring", 25, [2]int{2, 2}, 12},
+		{"grid", 40, [2]int{2, 4}, 8},
+		{"total", 300, [2]int{24, 24}, 1},
+		{"star", 24, [2]int{1, 24}, 2},
+		{"tree4", 24, [2]int{1, 5}, 5},
+		{"random4", 50, [2]int{4, 4}, -2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			top, err := topology.New(tt.name, nodes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkUndirected(t, top)
+
+			s := top.Stats()
+			if got, want := s.Nodes, len(nodes); got != want {
+				t.Fatalf("nodes=%v, want %v", got, want)
+			} else if got, want := s.Edges, tt.edges; got != want {
+				t.Fatalf("edges=%v, want %v", got, want)
+			} else if got, want := [2]int{s.MinDegree, s.MaxDegree}, tt.degree; got != want {
+				t.Fatalf("degree=%v, want %v", got, want)
+			}
+
+			// The diameter of a random graph varies, but it must be connected.
+			if tt.diameter == -2 {
+				if s.Diameter < 0 {
+					t.Fatalf("disconnected: %s", s)
+				}
+			} else if got, want := s.Diameter, tt.diameter; got != want {
+				t.Fatalf("diameter=%v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, name := range []string{"", "mesh", "tree", "tree0", "random", "random3"} {
+		if _, err := topology.New(name, ids(25)); err == nil {
+			t.Fatalf("expected error for %q", name)
+		}
+	}
+}
+
+func TestNew_Deterministic(t *testing.T) {
+	nodes := ids(10)
+	a, err := topology.New("random3", nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nodes must agree on a random topology regardless of the order in which
+	// they list their peers.
+	shuffled := append([]string(nil), nodes...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	b, err := topology.New("random3", shuffled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range nodes {
+		if got, want := sorted(b[id]), sorted(a[id]); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: neighbors=%v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestRandomRegular(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for n := 2; n <= 30; n++ {
+		for k := 1; k < n && k <= 6; k++ {
+			top, err := topology.RandomRegular(ids(n), k, rnd)
+			if n*k%2 != 0 {
+				if err == nil {
+					t.Fatalf("n=%d k=%d: expected error", n, k)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("n=%d k=%d: %s", n, k, err)
+			}
+			checkUndirected(t, top)
+			if s := top.Stats(); s.MinDegree != k || s.MaxDegree != k {
+				t.Fatalf("n=%d k=%d: %s", n, k, s)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		if got, want := (topology.Topology{}).Stats(), (topology.Stats{}); got != want {
+			t.Fatalf("stats=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("Disconnected", func(t *testing.T) {
+		top := topology.Topology{"n0": {"n1"}, "n1": {"n0"}, "n2": {}}
+		if got, want := top.Stats().Diameter, -1; got != want {
+			t.Fatalf("diameter=%v, want %v", got, want)
+		}
+	})
+}
+
+// checkUndirected fails if any edge in top is not reciprocated, or if any node
+// is its own neighbor.
+func checkUndirected(tb testing.TB, top topology.Topology) {
+	tb.Helper()
+	for id, neighbors := range top {
+		for _, neighbor := range neighbors {
+			if neighbor == id {
+				tb.Fatalf("%s is its own neighbor", id)
+			}
+			found := false
+			for _, back := range top[neighbor] {
+				found = found || back == id
+			}
+			if !found {
+				tb.Fatalf("%s -> %s is not reciprocated", id, neighbor)
+			}
+		}
+	}
+}
+
+func ids(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("n%d", i)
+	}
+	return nodes
+}
+
+func sorted(s []string) []string {
+	s = append([]string(nil), s...)
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,77 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/topology"
+)
+
+// Broadcast is the broadcast workload: clients broadcast unique integers to
+// any node, and every acknowledged message must eventually be present in
+// reads from every node.
+type Broadcast struct {
+	// Topology names the topology sent to nodes, as accepted by topology.New.
+	// Defaults to "grid", as in Maelstrom.
+	Topology string
+
+	values uniqueValues
+}
+
+// Setup sends each node its neighbors in the configured topology.
+func (b *Broadcast) Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error {
+	name := b.Topology
+	if name == "" {
+		name = "grid"
+	}
+	top, err := topology.New(name, nodes)
+	if err != nil {
+		return err
+	}
+	for _, id := range nodes {
+		if _, err := client.SyncRPC(ctx, id, map[string]any{"type": "topology", "topology": top}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generate returns an even mix of broadcasts and reads.
+func (b *Broadcast) Generate(rnd *rand.Rand, process int) checker.Op {
+	if rnd.Intn(2) == 0 {
+		return checker.Op{F: "read"}
+	}
+	return checker.Op{F: "broadcast", Value: b.values.take()}
+}
+
+// Request returns a "broadcast" or "read" message.
+func (*Broadcast) Request(op checker.Op) any {
+	if op.F == "read" {
+		return map[string]any{"type": "read"}
+	}
+	return map[string]any{"type": "broadcast", "message": op.Value}
+}
+
+// Complete records the messages returned by a read.
+func (*Broadcast) Complete(op checker.Op, body json.RawMessage) (checker.Op, error) {
+	if op.F != "read" {
+		return op, nil
+	}
+	var resp struct {
+		Messages []any `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return op, err
+	}
+	op.Value = resp.Messages
+	return op, nil
+}
+
+// Final reads every node.
+func (*Broadcast) Final() []checker.Op { return []checker.Op{{F: "read"}} }
+
+// Check verifies that no acknowledged broadcast was lost.
+func (*Broadcast) Check(h checker.History) checker.Result { return checker.Set(h, "broadcast") }
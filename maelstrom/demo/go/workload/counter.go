@@ -0,0 +1,64 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+// Counter is the g-counter workload, or the pn-counter workload if Negative
+// is set: clients add deltas to a counter, and final reads must reflect every
+// acknowledged delta.
+type Counter struct {
+	// Negative allows negative deltas, as in the pn-counter workload.
+	Negative bool
+}
+
+// Setup does nothing.
+func (*Counter) Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error {
+	return nil
+}
+
+// Generate returns an even mix of adds and reads.
+func (c *Counter) Generate(rnd *rand.Rand, process int) checker.Op {
+	if rnd.Intn(2) == 0 {
+		return checker.Op{F: "read"}
+	}
+	delta := rnd.Intn(5)
+	if c.Negative {
+		delta = rnd.Intn(11) - 5
+	}
+	return checker.Op{F: "add", Value: delta}
+}
+
+// Request returns an "add" or "read" message.
+func (*Counter) Request(op checker.Op) any {
+	if op.F == "read" {
+		return map[string]any{"type": "read"}
+	}
+	return map[string]any{"type": "add", "delta": op.Value}
+}
+
+// Complete records the value returned by a read.
+func (*Counter) Complete(op checker.Op, body json.RawMessage) (checker.Op, error) {
+	if op.F != "read" {
+		return op, nil
+	}
+	var resp struct {
+		Value int `json:"value"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return op, err
+	}
+	op.Value = resp.Value
+	return op, nil
+}
+
+// Final reads every node.
+func (*Counter) Final() []checker.Op { return []checker.Op{{F: "read"}} }
+
+// Check verifies that final reads fall within the bounds of possible deltas.
+func (*Counter) Check(h checker.History) checker.Result { return checker.Counter(h) }
@@ -0,0 +1,46 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+// Echo is the echo workload: clients send arbitrary payloads and expect them
+// back unchanged.
+type Echo struct{}
+
+// Setup does nothing.
+func (*Echo) Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error { return nil }
+
+// Generate returns an echo of a random payload.
+func (*Echo) Generate(rnd *rand.Rand, process int) checker.Op {
+	return checker.Op{F: "echo", Value: fmt.Sprintf("Please echo %d", rnd.Intn(128))}
+}
+
+// Request returns an "echo" message.
+func (*Echo) Request(op checker.Op) any {
+	return map[string]any{"type": "echo", "echo": op.Value}
+}
+
+// Complete records the echoed payload.
+func (*Echo) Complete(op checker.Op, body json.RawMessage) (checker.Op, error) {
+	var resp struct {
+		Echo any `json:"echo"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return op, err
+	}
+	op.Value = resp.Echo
+	return op, nil
+}
+
+// Final returns no operations.
+func (*Echo) Final() []checker.Op { return nil }
+
+// Check verifies that every payload was echoed unchanged.
+func (*Echo) Check(h checker.History) checker.Result { return checker.Echo(h) }
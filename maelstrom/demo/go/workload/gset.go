@@ -0,0 +1,57 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+// GSet is the g-set workload: clients add unique elements to a grow-only set,
+// and every acknowledged element must eventually be present in reads from
+// every node.
+type GSet struct {
+	values uniqueValues
+}
+
+// Setup does nothing.
+func (*GSet) Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error { return nil }
+
+// Generate returns an even mix of adds and reads.
+func (s *GSet) Generate(rnd *rand.Rand, process int) checker.Op {
+	if rnd.Intn(2) == 0 {
+		return checker.Op{F: "read"}
+	}
+	return checker.Op{F: "add", Value: s.values.take()}
+}
+
+// Request returns an "add" or "read" message.
+func (*GSet) Request(op checker.Op) any {
+	if op.F == "read" {
+		return map[string]any{"type": "read"}
+	}
+	return map[string]any{"type": "add", "element": op.Value}
+}
+
+// Complete records the elements returned by a read.
+func (*GSet) Complete(op checker.Op, body json.RawMessage) (checker.Op, error) {
+	if op.F != "read" {
+		return op, nil
+	}
+	var resp struct {
+		Value []any `json:"value"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return op, err
+	}
+	op.Value = resp.Value
+	return op, nil
+}
+
+// Final reads every node.
+func (*GSet) Final() []checker.Op { return []checker.Op{{F: "read"}} }
+
+// Check verifies that no acknowledged element was lost.
+func (*GSet) Check(h checker.History) checker.Result { return checker.Set(h, "add") }
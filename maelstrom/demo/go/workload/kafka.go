@@ -0,0 +1,137 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+// Kafka is the kafka workload: clients send unique messages to a handful of
+// logs, poll the logs from the offsets they have consumed so far, and commit
+// and list committed offsets.
+type Kafka struct {
+	// Keys is the number of distinct logs. Defaults to 5.
+	Keys int
+
+	values uniqueValues
+
+	mu      sync.Mutex
+	offsets map[int]map[string]int // process -> key -> next offset to poll
+}
+
+// NewKafka returns a new kafka workload.
+func NewKafka() *Kafka {
+	return &Kafka{offsets: make(map[int]map[string]int)}
+}
+
+// Generate returns a random send, poll, commit_offsets, or
+// list_committed_offsets operation.
+func (w *Kafka) Generate(rnd *rand.Rand, process int) checker.Op {
+	keys := w.Keys
+	if keys <= 0 {
+		keys = 5
+	}
+	key := func() string { return fmt.Sprintf("k%d", rnd.Intn(keys)) }
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	consumed := w.offsets[process]
+
+	switch n := rnd.Intn(10); {
+	case n < 5:
+		return checker.Op{F: "send", Key: key(), Value: w.values.take()}
+
+	case n < 8 || len(consumed) == 0:
+		offsets := make(map[string]int)
+		for i := 0; i < 2; i++ {
+			k := key()
+			offsets[k] = consumed[k]
+		}
+		return checker.Op{F: "poll", Value: offsets}
+
+	case n < 9:
+		offsets := make(map[string]int, len(consumed))
+		for k, next := range consumed {
+			offsets[k] = next - 1
+		}
+		return checker.Op{F: "commit_offsets", Value: offsets}
+
+	default:
+		return checker.Op{F: "list_committed_offsets", Value: []string{key(), key()}}
+	}
+}
+
+// Setup does nothing.
+func (*Kafka) Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error { return nil }
+
+// Request returns the message for an operation.
+func (*Kafka) Request(op checker.Op) any {
+	switch op.F {
+	case "send":
+		return map[string]any{"type": "send", "key": op.Key, "msg": op.Value}
+	case "poll":
+		return map[string]any{"type": "poll", "offsets": op.Value}
+	case "commit_offsets":
+		return map[string]any{"type": "commit_offsets", "offsets": op.Value}
+	default:
+		return map[string]any{"type": "list_committed_offsets", "keys": op.Value}
+	}
+}
+
+// Complete records the results of an operation, and advances the process's
+// offsets after a poll.
+func (w *Kafka) Complete(op checker.Op, body json.RawMessage) (checker.Op, error) {
+	var resp struct {
+		Offset  int                `json:"offset"`
+		Msgs    map[string][][]any `json:"msgs"`
+		Offsets map[string]any     `json:"offsets"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return op, err
+	}
+
+	switch op.F {
+	case "send":
+		op.Value = []any{resp.Offset, op.Value}
+
+	case "poll":
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		consumed := w.offsets[op.Process]
+		if consumed == nil {
+			consumed = make(map[string]int)
+			w.offsets[op.Process] = consumed
+		}
+
+		msgs := make(map[string]any, len(resp.Msgs))
+		for k, records := range resp.Msgs {
+			list := make([]any, len(records))
+			for i, r := range records {
+				if len(r) != 2 {
+					return op, fmt.Errorf("malformed record %v", r)
+				}
+				list[i] = r
+				if offset, ok := r[0].(float64); ok && int(offset) >= consumed[k] {
+					consumed[k] = int(offset) + 1
+				}
+			}
+			msgs[k] = list
+		}
+		op.Value = msgs
+
+	case "list_committed_offsets":
+		op.Value = resp.Offsets
+	}
+	return op, nil
+}
+
+// Final returns no operations.
+func (*Kafka) Final() []checker.Op { return nil }
+
+// Check verifies the offsets observed by sends and polls.
+func (*Kafka) Check(h checker.History) checker.Result { return checker.Kafka(h) }
@@ -0,0 +1,83 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+// LinKV is the lin-kv workload: clients read, write, and compare-and-swap a
+// handful of keys, and the history must be linearizable.
+type LinKV struct {
+	// Keys is the number of distinct keys. Defaults to 5.
+	Keys int
+}
+
+// Setup does nothing.
+func (*LinKV) Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error { return nil }
+
+// Generate returns a random read, write, or cas of a random key.
+func (w *LinKV) Generate(rnd *rand.Rand, process int) checker.Op {
+	keys := w.Keys
+	if keys <= 0 {
+		keys = 5
+	}
+	key := rnd.Intn(keys)
+	switch rnd.Intn(3) {
+	case 0:
+		return checker.Op{F: "read", Key: key}
+	case 1:
+		return checker.Op{F: "write", Key: key, Value: rnd.Intn(5)}
+	default:
+		return checker.Op{F: "cas", Key: key, Value: []any{rnd.Intn(5), rnd.Intn(5)}}
+	}
+}
+
+// Request returns a "read", "write", or "cas" message.
+func (*LinKV) Request(op checker.Op) any {
+	switch op.F {
+	case "read":
+		return map[string]any{"type": "read", "key": op.Key}
+	case "write":
+		return map[string]any{"type": "write", "key": op.Key, "value": op.Value}
+	default:
+		v := op.Value.([]any)
+		return map[string]any{"type": "cas", "key": op.Key, "from": v[0], "to": v[1]}
+	}
+}
+
+// Complete records the value returned by a read.
+func (*LinKV) Complete(op checker.Op, body json.RawMessage) (checker.Op, error) {
+	if op.F != "read" {
+		return op, nil
+	}
+	var resp struct {
+		Value any `json:"value"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return op, err
+	}
+	op.Value = resp.Value
+	return op, nil
+}
+
+// Final returns no operations.
+func (*LinKV) Final() []checker.Op { return nil }
+
+// Check verifies that the history is linearizable.
+func (*LinKV) Check(h checker.History) checker.Result {
+	res := checker.Result{Valid: checker.Valid, Details: make(map[string]any)}
+	lin, err := checker.LinearizableKV(h)
+	if err != nil {
+		res.Valid = checker.Invalid
+		res.Errors = append(res.Errors, fmt.Sprintf("malformed history: %s", err))
+	} else if !lin.Valid {
+		res.Valid = checker.Invalid
+		res.Errors = append(res.Errors, lin.String())
+	}
+	return res
+}
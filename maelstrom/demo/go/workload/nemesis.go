@@ -0,0 +1,67 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+)
+
+// Nemeses lists the faults supported by Config.Nemesis.
+var Nemeses = []string{"partition"}
+
+// validateNemesis returns an error if any fault in names is unsupported.
+func validateNemesis(names []string) error {
+	for _, name := range names {
+		switch name {
+		case "partition":
+		default:
+			return fmt.Errorf("unknown nemesis %q", name)
+		}
+	}
+	return nil
+}
+
+// nemesis injects faults into the network until ctx is done, alternating
+// between a fault and a healthy network every interval. The network is healed
+// before it returns.
+func nemesis(ctx context.Context, net *sim.Network, nodes []string, names []string, interval time.Duration, seed int64) {
+	if len(names) == 0 {
+		return
+	}
+	defer net.Heal()
+
+	rnd := rand.New(rand.NewSource(seed))
+	faulty := false
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if faulty {
+			log.Printf("nemesis: heal")
+			net.Heal()
+		} else {
+			a, b := randomHalves(rnd, nodes)
+			log.Printf("nemesis: partition %v %v", a, b)
+			net.Partition(a, b)
+		}
+		faulty = !faulty
+	}
+}
+
+// randomHalves splits nodes into two randomly chosen groups, the first of
+// which is the smaller when the count is odd.
+func randomHalves(rnd *rand.Rand, nodes []string) ([]string, []string) {
+	shuffled := append([]string(nil), nodes...)
+	rnd.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	n := len(shuffled) / 2
+	return shuffled[:n], shuffled[n:]
+}
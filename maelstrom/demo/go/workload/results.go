@@ -0,0 +1,197 @@
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+)
+
+// Results summarizes a run of a workload, in the spirit of the results.edn
+// file that Maelstrom writes at the end of a test.
+type Results struct {
+	// Valid combines the validity of Stats, Availability and Workload.
+	Valid checker.Validity
+
+	Stats        Stats
+	Availability Availability
+	Net          NetStats
+	Workload     checker.Result
+
+	// History is every operation performed during the run.
+	History checker.History
+}
+
+// Stats counts completed operations. As in Maelstrom, it is only valid if
+// every kind of operation succeeded at least once.
+type Stats struct {
+	Valid     checker.Validity
+	Count     int
+	OKCount   int
+	FailCount int
+	InfoCount int
+
+	// ByF breaks down the counts by function.
+	ByF map[string]Stats
+}
+
+// newStats counts the completions in h.
+func newStats(h checker.History) Stats {
+	s := Stats{Valid: checker.Valid, ByF: make(map[string]Stats)}
+	for _, op := range h {
+		if op.Type == checker.Invoke {
+			continue
+		}
+		f := s.ByF[op.F]
+		f.add(op)
+		s.ByF[op.F] = f
+		s.add(op)
+	}
+
+	for name, f := range s.ByF {
+		f.Valid = checker.Valid
+		if f.OKCount == 0 {
+			f.Valid = checker.Invalid
+			s.Valid = checker.Invalid
+		}
+		s.ByF[name] = f
+	}
+	return s
+}
+
+// add counts a single completion.
+func (s *Stats) add(op checker.Op) {
+	s.Count++
+	switch op.Type {
+	case checker.OK:
+		s.OKCount++
+	case checker.Fail:
+		s.FailCount++
+	case checker.Info:
+		s.InfoCount++
+	}
+}
+
+// Availability checks that enough operations succeeded. It is the zero value
+// if no target was set.
+type Availability struct {
+	Valid checker.Validity
+
+	// Target is the minimum fraction of operations which had to succeed.
+	Target float64
+
+	// OKFraction is the fraction of operations which did succeed.
+	OKFraction float64
+}
+
+// newAvailability checks the fraction of successful operations in s.
+func newAvailability(s Stats, target float64) Availability {
+	a := Availability{Valid: checker.Valid, Target: target}
+	if s.Count > 0 {
+		a.OKFraction = float64(s.OKCount) / float64(s.Count)
+	}
+	if a.OKFraction < target {
+		a.Valid = checker.Invalid
+	}
+	return a
+}
+
+// NetStats counts network messages.
+type NetStats struct {
+	sim.Stats
+
+	// MsgsPerOp is the number of messages exchanged between servers for each
+	// completed operation.
+	MsgsPerOp float64
+}
+
+// newNetStats computes message counts for a run with history h.
+func newNetStats(s sim.Stats, h checker.History) NetStats {
+	ns := NetStats{Stats: s}
+	if ops := newStats(h).Count; ops > 0 {
+		ns.MsgsPerOp = float64(s.Servers) / float64(ops)
+	}
+	return ns
+}
+
+// String formats the results as EDN, like Maelstrom prints at the end of a test.
+func (r Results) String() string {
+	stats := func(s Stats) map[string]any {
+		return map[string]any{
+			"valid?":     s.Valid,
+			"count":      s.Count,
+			"ok-count":   s.OKCount,
+			"fail-count": s.FailCount,
+			"info-count": s.InfoCount,
+		}
+	}
+
+	byF := make(map[string]any)
+	for name, f := range r.Stats.ByF {
+		byF[name] = stats(f)
+	}
+	s := stats(r.Stats)
+	s["by-f"] = byF
+
+	workload := map[string]any{"valid?": r.Workload.Valid}
+	for k, v := range r.Workload.Details {
+		workload[k] = v
+	}
+	if len(r.Workload.Errors) > 0 {
+		workload["errors"] = r.Workload.Errors
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "{:stats %s,\n", edn(s))
+	if r.Availability.Target > 0 {
+		fmt.Fprintf(&b, " :availability {:valid? %s, :target %.3f, :ok-fraction %.3f},\n",
+			edn(r.Availability.Valid), r.Availability.Target, r.Availability.OKFraction)
+	}
+	fmt.Fprintf(&b, " :net {:all {:msg-count %d},\n", r.Net.All)
+	fmt.Fprintf(&b, "       :clients {:msg-count %d},\n", r.Net.Clients)
+	fmt.Fprintf(&b, "       :servers {:msg-count %d, :msgs-per-op %.3f, :bytes %d},\n", r.Net.Servers, r.Net.MsgsPerOp, r.Net.ServerBytes)
+	fmt.Fprintf(&b, "       :services {:msg-count %d},\n", r.Net.Services)
+	fmt.Fprintf(&b, "       :dropped {:msg-count %d}},\n", r.Net.Dropped)
+	fmt.Fprintf(&b, " :workload %s,\n", edn(workload))
+	fmt.Fprintf(&b, " :valid? %s}\n", r.Valid)
+	return b.String()
+}
+
+// edn formats a value in a Clojure-ish notation. Map keys are keywords.
+func edn(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			key := ":" + k
+			if strings.ContainsAny(k, " \"") {
+				key = fmt.Sprintf("%q", k)
+			}
+			parts[i] = key + " " + edn(v[k])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case []string:
+		parts := make([]string, len(v))
+		for i, s := range v {
+			parts[i] = fmt.Sprintf("%q", s)
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	case checker.Validity:
+		if v == checker.Unknown {
+			return ":unknown"
+		}
+		return string(v)
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,78 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+// Txn is the txn-rw-register workload, or the txn-list-append workload if
+// Append is set: clients submit short transactions of reads and writes (or
+// appends) over a handful of keys.
+type Txn struct {
+	// Append generates appends instead of writes.
+	Append bool
+
+	// Keys is the number of distinct keys. Defaults to 5.
+	Keys int
+
+	values uniqueValues
+}
+
+// Setup does nothing.
+func (*Txn) Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error { return nil }
+
+// Generate returns a transaction of one to four micro-operations. Every
+// written or appended value is unique.
+func (w *Txn) Generate(rnd *rand.Rand, process int) checker.Op {
+	keys := w.Keys
+	if keys <= 0 {
+		keys = 5
+	}
+	write := "w"
+	if w.Append {
+		write = "append"
+	}
+
+	txn := make([]any, 1+rnd.Intn(4))
+	for i := range txn {
+		key := rnd.Intn(keys)
+		if rnd.Intn(2) == 0 {
+			txn[i] = []any{"r", key, nil}
+		} else {
+			txn[i] = []any{write, key, w.values.take()}
+		}
+	}
+	return checker.Op{F: "txn", Value: txn}
+}
+
+// Request returns a "txn" message.
+func (*Txn) Request(op checker.Op) any {
+	return map[string]any{"type": "txn", "txn": op.Value}
+}
+
+// Complete records the transaction with its read values filled in.
+func (*Txn) Complete(op checker.Op, body json.RawMessage) (checker.Op, error) {
+	var resp struct {
+		Txn []any `json:"txn"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return op, err
+	}
+	op.Value = resp.Txn
+	return op, nil
+}
+
+// Final returns no operations.
+func (*Txn) Final() []checker.Op { return nil }
+
+// Check looks for anomalies in the transactions.
+func (w *Txn) Check(h checker.History) checker.Result {
+	if w.Append {
+		return checker.TxnListAppend(h)
+	}
+	return checker.TxnRWRegister(h)
+}
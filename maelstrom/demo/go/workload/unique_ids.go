@@ -0,0 +1,47 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+)
+
+// UniqueIDs is the unique-ids workload: clients ask nodes to generate IDs,
+// which must be globally unique.
+type UniqueIDs struct{}
+
+// Setup does nothing.
+func (*UniqueIDs) Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error {
+	return nil
+}
+
+// Generate returns a "generate" operation.
+func (*UniqueIDs) Generate(rnd *rand.Rand, process int) checker.Op {
+	return checker.Op{F: "generate"}
+}
+
+// Request returns a "generate" message.
+func (*UniqueIDs) Request(op checker.Op) any {
+	return map[string]any{"type": "generate"}
+}
+
+// Complete records the generated ID.
+func (*UniqueIDs) Complete(op checker.Op, body json.RawMessage) (checker.Op, error) {
+	var resp struct {
+		ID any `json:"id"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return op, err
+	}
+	op.Value = resp.ID
+	return op, nil
+}
+
+// Final returns no operations.
+func (*UniqueIDs) Final() []checker.Op { return nil }
+
+// Check verifies that no ID was generated twice.
+func (*UniqueIDs) Check(h checker.History) checker.Result { return checker.UniqueIDs(h) }
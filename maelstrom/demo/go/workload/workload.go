@@ -0,0 +1,389 @@
+// Package workload drives Maelstrom's workloads from Go. It generates
+// randomized client operations at a target rate and concurrency against a
+// simulated cluster, records the history, and checks it.
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+)
+
+// Workload generates operations for one of Maelstrom's workloads and checks
+// the resulting history. Implementations must be safe for concurrent use.
+type Workload interface {
+	// Setup is called once, after every node has been initialized.
+	Setup(ctx context.Context, client *maelstrom.Node, nodes []string) error
+
+	// Generate returns the next operation for a process to invoke. Only F,
+	// Key and Value need to be set.
+	Generate(rnd *rand.Rand, process int) checker.Op
+
+	// Request returns the body of the RPC request for an invocation.
+	Request(op checker.Op) any
+
+	// Complete fills in the Value of a completed operation from the body of
+	// a successful RPC response.
+	Complete(op checker.Op, body json.RawMessage) (checker.Op, error)
+
+	// Final returns operations to invoke on every node once the main phase
+	// is over and the cluster has had some time to converge.
+	Final() []checker.Op
+
+	// Check checks a history of the workload.
+	Check(h checker.History) checker.Result
+}
+
+// Names lists the workloads supported by New.
+var Names = []string{
+	"broadcast",
+	"echo",
+	"g-counter",
+	"g-set",
+	"kafka",
+	"lin-kv",
+	"pn-counter",
+	"txn-list-append",
+	"txn-rw-register",
+	"unique-ids",
+}
+
+// New returns the workload with the given name, as accepted by Maelstrom's
+// -w flag.
+func New(name string) (Workload, error) {
+	switch name {
+	case "broadcast":
+		return &Broadcast{}, nil
+	case "echo":
+		return &Echo{}, nil
+	case "g-counter":
+		return &Counter{}, nil
+	case "g-set":
+		return &GSet{}, nil
+	case "kafka":
+		return NewKafka(), nil
+	case "lin-kv":
+		return &LinKV{}, nil
+	case "pn-counter":
+		return &Counter{Negative: true}, nil
+	case "txn-list-append":
+		return &Txn{Append: true}, nil
+	case "txn-rw-register":
+		return &Txn{}, nil
+	case "unique-ids":
+		return &UniqueIDs{}, nil
+	default:
+		return nil, fmt.Errorf("unknown workload %q", name)
+	}
+}
+
+// Config controls a run of a workload.
+type Config struct {
+	// Nodes are the IDs of the servers under test.
+	Nodes []string
+
+	// Concurrency is the number of client processes. Defaults to the number
+	// of nodes.
+	Concurrency int
+
+	// Rate is the approximate number of requests per second, across all
+	// clients. Unlimited if zero.
+	Rate float64
+
+	// TimeLimit is the duration of the main phase.
+	TimeLimit time.Duration
+
+	// Timeout is how long a client waits for a response before recording
+	// the operation as indeterminate. Defaults to one second.
+	Timeout time.Duration
+
+	// FinalDelay is how long to wait after the main phase before invoking the
+	// workload's final operations. Defaults to one second.
+	FinalDelay time.Duration
+
+	// Seed seeds the operation generators.
+	Seed int64
+
+	// Nemesis lists the faults to inject during the main phase, from
+	// Nemeses. Requires the network the nodes are attached to.
+	Nemesis []string
+
+	// NemesisInterval is how long each fault lasts, and how long the network
+	// is healthy between faults. Defaults to ten seconds, as in Maelstrom.
+	NemesisInterval time.Duration
+
+	// Availability is the minimum fraction of operations which must succeed
+	// for the run to be valid. Not checked if zero.
+	Availability float64
+}
+
+// Run initializes the nodes, runs the workload against them, and checks the
+// resulting history. The nodes must already be attached to net.
+func Run(ctx context.Context, net *sim.Network, w Workload, cfg Config) (Results, error) {
+	if len(cfg.Nodes) == 0 {
+		return Results{}, errors.New("no nodes")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = len(cfg.Nodes)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.FinalDelay <= 0 {
+		cfg.FinalDelay = time.Second
+	}
+	if cfg.NemesisInterval <= 0 {
+		cfg.NemesisInterval = 10 * time.Second
+	}
+	if err := validateNemesis(cfg.Nemesis); err != nil {
+		return Results{}, err
+	}
+
+	r := &runner{w: w, cfg: cfg, start: time.Now()}
+
+	setup := net.AddClient("c0")
+	if err := r.init(ctx, setup); err != nil {
+		return Results{}, err
+	}
+	if err := w.Setup(ctx, setup, cfg.Nodes); err != nil {
+		return Results{}, fmt.Errorf("setup: %w", err)
+	}
+
+	// Main phase: each process repeatedly invokes operations on one node.
+	mainCtx, cancel := context.WithTimeout(ctx, cfg.TimeLimit)
+	defer cancel()
+	tokens := limit(mainCtx, cfg.Rate)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		nemesis(mainCtx, net, cfg.Nodes, cfg.Nemesis, cfg.NemesisInterval, cfg.Seed)
+	}()
+	for p := 0; p < cfg.Concurrency; p++ {
+		p := p
+		client := net.AddClient(fmt.Sprintf("c%d", p+1))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.worker(mainCtx, ctx, client, p, tokens)
+		}()
+	}
+	wg.Wait()
+
+	// Final phase: invoke final operations on every node.
+	if final := w.Final(); len(final) > 0 {
+		select {
+		case <-ctx.Done():
+			return Results{}, ctx.Err()
+		case <-time.After(cfg.FinalDelay):
+		}
+
+		process := r.maxProcess() + 1
+		for _, node := range cfg.Nodes {
+			for _, op := range final {
+				node, op := node, op
+				op.Process, op.Final = process, true
+				process++
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r.invoke(ctx, setup, node, op)
+				}()
+			}
+		}
+		wg.Wait()
+	}
+
+	h := r.history()
+	res := Results{
+		Stats:    newStats(h),
+		Net:      newNetStats(net.Stats(), h),
+		Workload: w.Check(h),
+		History:  h,
+	}
+	res.Valid = res.Stats.Valid.Merge(res.Workload.Valid)
+	if cfg.Availability > 0 {
+		res.Availability = newAvailability(res.Stats, cfg.Availability)
+		res.Valid = res.Valid.Merge(res.Availability.Valid)
+	}
+	return res, nil
+}
+
+// runner holds the state of a single run.
+type runner struct {
+	w     Workload
+	cfg   Config
+	start time.Time
+
+	mu sync.Mutex
+	h  checker.History
+}
+
+// init sends an "init" message to every node.
+func (r *runner) init(ctx context.Context, client *maelstrom.Node) error {
+	for _, id := range r.cfg.Nodes {
+		ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+		_, err := client.SyncRPC(ctx, id, maelstrom.InitMessageBody{
+			MessageBody: maelstrom.MessageBody{Type: "init"},
+			NodeID:      id,
+			NodeIDs:     r.cfg.Nodes,
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("init %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// worker runs a single client process until mainCtx is done. Requests use ctx
+// so that they are not interrupted at the end of the main phase.
+func (r *runner) worker(mainCtx, ctx context.Context, client *maelstrom.Node, p int, tokens <-chan struct{}) {
+	rnd := rand.New(rand.NewSource(r.cfg.Seed + int64(p)))
+	node := r.cfg.Nodes[p%len(r.cfg.Nodes)]
+	process := p
+	for {
+		if tokens != nil {
+			select {
+			case <-mainCtx.Done():
+				return
+			case <-tokens:
+			}
+		} else if mainCtx.Err() != nil {
+			return
+		}
+
+		op := r.w.Generate(rnd, process)
+		op.Process = process
+		if done := r.invoke(ctx, client, node, op); done.Type == checker.Info {
+			// The operation may still be in flight, so continue as a new
+			// process, as Jepsen does.
+			process += r.cfg.Concurrency
+		}
+	}
+}
+
+// invoke performs a single operation against node and records it.
+func (r *runner) invoke(ctx context.Context, client *maelstrom.Node, node string, op checker.Op) checker.Op {
+	op.Type = checker.Invoke
+	r.record(op)
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	resp, err := client.SyncRPC(ctx, node, r.w.Request(op))
+	cancel()
+
+	done := op
+	if err != nil {
+		done.Type, done.Error = completionType(err), err.Error()
+	} else {
+		done.Type = checker.OK
+		if done, err = r.w.Complete(done, resp.Body); err != nil {
+			done = op
+			done.Type, done.Error = checker.Info, fmt.Sprintf("malformed response: %s", err)
+		}
+	}
+	r.record(done)
+	return done
+}
+
+// completionType returns Fail for errors that definitely prevented an
+// operation from taking effect, and Info for all others.
+func completionType(err error) string {
+	var rpcErr *maelstrom.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code != maelstrom.Timeout && rpcErr.Code != maelstrom.Crash {
+		return checker.Fail
+	}
+	return checker.Info
+}
+
+// record appends op to the history.
+func (r *runner) record(op checker.Op) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	op.Time = int64(time.Since(r.start))
+	r.h = append(r.h, op)
+}
+
+// history returns a copy of the recorded history.
+func (r *runner) history() checker.History {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(checker.History(nil), r.h...)
+}
+
+// maxProcess returns the largest process ID in the history.
+func (r *runner) maxProcess() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	max := 0
+	for _, op := range r.h {
+		if op.Process > max {
+			max = op.Process
+		}
+	}
+	return max
+}
+
+// limit returns a channel which yields approximately rate values per second
+// until ctx is done, or nil if rate is not positive.
+func limit(ctx context.Context, rate float64) <-chan struct{} {
+	if rate <= 0 {
+		return nil
+	}
+
+	ch := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- struct{}{}:
+			}
+		}
+	}()
+	return ch
+}
+
+// uniqueValues hands out increasing integers, for workloads which need every
+// written value to be distinct.
+type uniqueValues struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (u *uniqueValues) take() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	v := u.next
+	u.next++
+	return v
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,246 @@
+package workload_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/checker"
+	"github.com/jepsen-io/maelstrom/demo/go/sim"
+	"github.com/jepsen-io/maelstrom/demo/go/workload"
+)
+
+func TestMain(m *testing.M) {
+	// Nodes log every message they send and receive.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestRun_Echo(t *testing.T) {
+	res := run(t, "echo", 1, func(n *maelstrom.Node) {
+		n.Handle("echo", func(msg maelstrom.Message) error {
+			var body map[string]any
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			body["type"] = "echo_ok"
+			return n.Reply(msg, body)
+		})
+	})
+	if res.Valid != checker.Valid {
+		t.Fatalf("unexpected results:\n%s", res)
+	}
+}
+
+func TestRun_UniqueIDs(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		res := run(t, "unique-ids", 3, func(n *maelstrom.Node) {
+			var mu sync.Mutex
+			next := 0
+			n.Handle("generate", func(msg maelstrom.Message) error {
+				mu.Lock()
+				next++
+				id := fmt.Sprintf("%s-%d", n.ID(), next)
+				mu.Unlock()
+				return n.Reply(msg, map[string]any{"type": "generate_ok", "id": id})
+			})
+		})
+		if res.Valid != checker.Valid {
+			t.Fatalf("unexpected results:\n%s", res)
+		}
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		res := run(t, "unique-ids", 3, func(n *maelstrom.Node) {
+			n.Handle("generate", func(msg maelstrom.Message) error {
+				return n.Reply(msg, map[string]any{"type": "generate_ok", "id": 1})
+			})
+		})
+		if res.Valid != checker.Invalid {
+			t.Fatalf("unexpected results:\n%s", res)
+		}
+	})
+}
+
+func TestRun_GSet(t *testing.T) {
+	res := run(t, "g-set", 1, func(n *maelstrom.Node) {
+		var mu sync.Mutex
+		elements := []any{}
+		n.Handle("add", func(msg maelstrom.Message) error {
+			var body struct {
+				Element any `json:"element"`
+			}
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			mu.Lock()
+			elements = append(elements, body.Element)
+			mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "add_ok"})
+		})
+		n.Handle("read", func(msg maelstrom.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "read_ok", "value": elements})
+		})
+	})
+	if res.Valid != checker.Valid {
+		t.Fatalf("unexpected results:\n%s", res)
+	} else if got, want := res.Stats.ByF["read"].OKCount, 1; got < want {
+		t.Fatalf("reads=%d, want at least %d", got, want)
+	}
+}
+
+func TestRun_Counter(t *testing.T) {
+	// A counter which forgets every other add.
+	res := run(t, "g-counter", 1, func(n *maelstrom.Node) {
+		var mu sync.Mutex
+		value, adds := 0, 0
+		n.Handle("add", func(msg maelstrom.Message) error {
+			var body struct {
+				Delta int `json:"delta"`
+			}
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			mu.Lock()
+			if adds++; adds%2 == 0 {
+				value += body.Delta + 1
+			}
+			mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "add_ok"})
+		})
+		n.Handle("read", func(msg maelstrom.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "read_ok", "value": value})
+		})
+	})
+	if res.Workload.Valid != checker.Invalid {
+		t.Fatalf("unexpected results:\n%s", res)
+	}
+}
+
+func TestRun_LinKV(t *testing.T) {
+	// Proxy every request to the lin-kv service.
+	res := run(t, "lin-kv", 2, func(n *maelstrom.Node) {
+		for _, typ := range []string{"read", "write", "cas"} {
+			n.Handle(typ, func(msg maelstrom.Message) error {
+				var body map[string]any
+				if err := json.Unmarshal(msg.Body, &body); err != nil {
+					return err
+				}
+				delete(body, "msg_id")
+				resp, err := n.SyncRPC(context.Background(), maelstrom.LinKV, body)
+				if err != nil {
+					return err
+				}
+				var reply map[string]any
+				if err := json.Unmarshal(resp.Body, &reply); err != nil {
+					return err
+				}
+				delete(reply, "msg_id")
+				delete(reply, "in_reply_to")
+				return n.Reply(msg, reply)
+			})
+		}
+	})
+	if res.Workload.Valid != checker.Valid {
+		t.Fatalf("unexpected results:\n%s", res)
+	}
+}
+
+// run runs a workload briefly against nodes configured by setup.
+func TestRun_Availability(t *testing.T) {
+	// Fails every other request.
+	setup := func(n *maelstrom.Node) {
+		var mu sync.Mutex
+		next := 0
+		n.Handle("generate", func(msg maelstrom.Message) error {
+			mu.Lock()
+			next++
+			id := fmt.Sprintf("%s-%d", n.ID(), next)
+			mu.Unlock()
+			if next%2 == 0 {
+				return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "try again")
+			}
+			return n.Reply(msg, map[string]any{"type": "generate_ok", "id": id})
+		})
+	}
+
+	t.Run("Total", func(t *testing.T) {
+		res := runConfig(t, "unique-ids", 1, workload.Config{Availability: 1}, setup)
+		if res.Valid != checker.Invalid || res.Availability.Valid != checker.Invalid {
+			t.Fatalf("unexpected results:\n%s", res)
+		}
+	})
+
+	t.Run("Partial", func(t *testing.T) {
+		res := runConfig(t, "unique-ids", 1, workload.Config{Availability: 0.25}, setup)
+		if res.Valid != checker.Valid {
+			t.Fatalf("unexpected results:\n%s", res)
+		}
+		if got, want := res.Availability.OKFraction, 0.5; got < want-0.1 || got > want+0.1 {
+			t.Fatalf("ok fraction=%v, want about %v", got, want)
+		}
+	})
+}
+
+func TestRun_Nemesis(t *testing.T) {
+	t.Run("Unknown", func(t *testing.T) {
+		w, _ := workload.New("echo")
+		net := sim.NewNetwork()
+		defer net.Close()
+		_, err := workload.Run(context.Background(), net, w, workload.Config{Nodes: []string{"n0"}, Nemesis: []string{"kill"}})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func run(tb testing.TB, name string, nodes int, setup func(n *maelstrom.Node)) workload.Results {
+	tb.Helper()
+	return runConfig(tb, name, nodes, workload.Config{}, setup)
+}
+
+// runConfig is like run, but with extra configuration such as an availability
+// target.
+func runConfig(tb testing.TB, name string, nodes int, cfg workload.Config, setup func(n *maelstrom.Node)) workload.Results {
+	tb.Helper()
+
+	w, err := workload.New(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	net := sim.NewNetwork()
+	tb.Cleanup(func() { net.Close() })
+	net.AddService(maelstrom.LinKV, sim.NewKV(maelstrom.LinKV).Node())
+
+	var ids []string
+	for i := 0; i < nodes; i++ {
+		id := fmt.Sprintf("n%d", i)
+		n := maelstrom.NewNode()
+		setup(n)
+		net.AddNode(id, n)
+		ids = append(ids, id)
+	}
+
+	cfg.Nodes = ids
+	cfg.Concurrency = 4
+	cfg.Rate = 500
+	cfg.TimeLimit = 500 * time.Millisecond
+	cfg.FinalDelay = 10 * time.Millisecond
+	res, err := workload.Run(context.Background(), net, w, cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}